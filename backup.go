@@ -0,0 +1,214 @@
+package krypto431
+
+// Save() never writes to the persistence file in place. The instance is
+// written to a temporary file in the same directory which is synced to disk
+// and renamed over the persistence file, so a crash, a full disk or Ctrl-C
+// while saving leaves either the old or the new file, never a truncated one.
+// Before the rename, the previous file is kept as an encrypted backup
+// (persistence file name with suffix .1) and older backups are rotated (.1 to
+// .2 and so on), keeping the number of backups configured with WithBackups().
+// Backups are the previous persistence files as is, they open with the
+// password or PFK they were saved with. RestoreBackup() rolls the persistence
+// file back to a backup.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultBackups is the number of rotating backups of the persistence file
+	// kept by Save().
+	DefaultBackups int = 3
+)
+
+var (
+	ErrBackupNotFound = errors.New("backup not found")
+)
+
+// Backup describes a backup of the persistence file.
+type Backup struct {
+	Number   int
+	File     string
+	Size     int64
+	Modified time.Time
+	Header   *PersistenceHeader
+}
+
+// WithBackups sets the number of rotating backups of the persistence file kept
+// by Save(), 0 disables backups.
+func WithBackups(n int) Option {
+	return func(k *Krypto431) {
+		if n < 0 {
+			n = 0
+		}
+		k.backups = n
+	}
+}
+
+// BackupFile returns the file name of backup number n of the persistence file.
+func (k *Krypto431) BackupFile(n int) string {
+	return fmt.Sprintf("%s.%d", k.persistence, n)
+}
+
+// Backups returns the existing backups of the persistence file, most recent
+// (number 1) first.
+func (k *Krypto431) Backups() ([]Backup, error) {
+	if err := k.expandPersistence(); err != nil {
+		return nil, err
+	}
+	backups := make([]Backup, 0, k.backups)
+	for n := 1; n <= k.backups; n++ {
+		file := k.BackupFile(n)
+		fi, err := os.Stat(file)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		// Backups that are not persistence files are listed without header.
+		header, _ := ReadPersistenceHeader(file)
+		backups = append(backups, Backup{
+			Number:   n,
+			File:     file,
+			Size:     fi.Size(),
+			Modified: fi.ModTime(),
+			Header:   header,
+		})
+	}
+	return backups, nil
+}
+
+// RestoreBackup replaces the persistence file with backup number n. The
+// persistence file being replaced becomes backup 1 (the other backups are
+// rotated), so a restore can be undone by restoring backup 1. The instance is
+// not changed, Load() it again to use the restored file.
+func (k *Krypto431) RestoreBackup(n int) error {
+	if err := k.expandPersistence(); err != nil {
+		return err
+	}
+	file := k.BackupFile(n)
+	if _, err := os.Stat(file); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrBackupNotFound, file)
+		}
+		return err
+	}
+	if _, err := ReadPersistenceHeader(file); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	unlock, err := k.lockPersistence(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return k.writeAtomically(func(f *os.File) error {
+		backup, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer backup.Close()
+		_, err = io.Copy(f, backup)
+		return err
+	})
+}
+
+// writeAtomically replaces the persistence file with what write writes to a
+// temporary file in the same directory, rotating backups before the
+// temporary file is renamed over the persistence file.
+func (k *Krypto431) writeAtomically(write func(f *os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(k.persistence), "."+filepath.Base(k.persistence)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0600)
+	}
+	if err == nil {
+		err = k.rotateBackups()
+	}
+	if err == nil {
+		err = os.Rename(tmp, k.persistence)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(k.persistence))
+	return nil
+}
+
+// rotateBackups shifts the backups one step (dropping the oldest) and keeps
+// the current persistence file as backup 1.
+func (k *Krypto431) rotateBackups() error {
+	if k.backups <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(k.persistence); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(k.BackupFile(k.backups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for n := k.backups - 1; n >= 1; n-- {
+		if err := os.Rename(k.BackupFile(n), k.BackupFile(n+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	// A hard link keeps the persistence file in place until it is replaced,
+	// copy where links are not supported.
+	if err := os.Link(k.persistence, k.BackupFile(1)); err == nil {
+		return nil
+	}
+	return copyFile(k.persistence, k.BackupFile(1))
+}
+
+// copyFile copies src to dst (created with mode 0600) and syncs dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// syncDir makes a rename in dir durable where supported (errors are ignored,
+// directories can not be synced on all platforms).
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
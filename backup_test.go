@@ -0,0 +1,84 @@
+package krypto431
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKrypto431_Backups(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.krypto431")
+	k := New(WithPersistence(file), WithPFKString(GeneratePFK()), WithBackups(2), WithOverwritePersistenceIfExists(true))
+	for i := 0; i < 4; i++ {
+		if err := k.GenerateKeys(1, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := k.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := k.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Number != 1 || backups[1].Number != 2 || backups[0].Header == nil {
+		t.Fatalf("Expected backups 1 and 2, got %+v", backups)
+	}
+	if _, err := os.Stat(k.BackupFile(3)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected no backup 3, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Errorf("Expected the persistence file, its lock file, its journal and 2 backups (no temporary files), got %d files", len(entries))
+	}
+
+	// Backup 1 has 3 keys, backup 2 has 2 keys.
+	for n, expected := range map[int]int{1: 3, 2: 2} {
+		b := New(WithPersistence(k.BackupFile(n)), WithPFK(k.GetPFK()), WithJournal(false))
+		if err := b.Load(); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.Keys) != expected {
+			t.Errorf("Expected %d keys in backup %d, got %d", expected, n, len(b.Keys))
+		}
+	}
+
+	if err := k.RestoreBackup(2); err != nil {
+		t.Fatal(err)
+	}
+	r := New(WithPersistence(file), WithPFK(k.GetPFK()))
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Keys) != 2 {
+		t.Errorf("Expected 2 keys after restoring backup 2, got %d", len(r.Keys))
+	}
+	// The replaced file is backup 1, restoring it undoes the restore.
+	if err := k.RestoreBackup(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Keys) != 4 {
+		t.Errorf("Expected 4 keys after undoing the restore, got %d", len(r.Keys))
+	}
+	if err := k.RestoreBackup(3); !errors.Is(err, ErrBackupNotFound) {
+		t.Errorf("Expected %v, got %v", ErrBackupNotFound, err)
+	}
+
+	none := New(WithPersistence(filepath.Join(dir, "none.krypto431")), WithPFKString(GeneratePFK()), WithBackups(0), WithOverwritePersistenceIfExists(true))
+	for i := 0; i < 2; i++ {
+		if err := none.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backups, err := none.Backups(); err != nil || len(backups) != 0 {
+		t.Errorf("Expected no backups, got %+v, %v", backups, err)
+	}
+}
@@ -0,0 +1,315 @@
+package krypto431
+
+// Keys are distributed as printed booklets, one book per station (or group of
+// stations). A Book is a numbered series of keys with the same keepers and
+// validity, each key is a sheet of the book (Key.Book and Key.Sheet). The book
+// id is printed on every sheet together with the sheet number ("SHEET 3 OF
+// 20") so that lost or missing sheets can be identified. Keys without a book
+// are loose keys.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sa6mwa/dtg"
+	"github.com/sa6mwa/krypto431/crand"
+)
+
+const (
+	// DefaultBookIdLength is the length of generated book ids.
+	DefaultBookIdLength int = 4
+	// MaxBookIdLength is the longest accepted book id.
+	MaxBookIdLength int = 8
+)
+
+var (
+	ErrBookNotFound  = errors.New("key book not found")
+	ErrBookExists    = errors.New("key book already exists")
+	ErrInvalidBookId = fmt.Errorf("book id must be 1 to %d characters of A-Z and 0-9", MaxBookIdLength)
+	ErrNoSheets      = errors.New("a key book must have at least one sheet")
+)
+
+// Book describes a key book (series). Keepers, Created and Expires are the
+// keepers and validity period of all keys in the book, Sheets is the number of
+// keys (sheets) the book was generated with.
+type Book struct {
+	Id      []rune
+	Sheets  int
+	Keepers [][]rune
+	Created dtg.DTG
+	Expires dtg.DTG
+}
+
+func (b Book) GoString() string {
+	return fmt.Sprintf("Book{Id:%s Sheets:%d Keepers:[%s] Created:%s Expires:%s}",
+		b.IdString(), b.Sheets, b.JoinKeepers(","), b.Created, b.Expires)
+}
+
+func (b *Book) IdString() string {
+	return string(b.Id)
+}
+
+func (b *Book) JoinKeepers(separator string) string {
+	return JoinRunesToString(&b.Keepers, separator)
+}
+
+// AddKeeper adds keeper(s) to the Keepers slice if not already there. Can be
+// chained.
+func (b *Book) AddKeeper(keepers ...[]rune) *Book {
+	for _, keeper := range keepers {
+		if len(keeper) > 0 && !AnyOfThem(&b.Keepers, &keeper) {
+			b.Keepers = append(b.Keepers, keeper)
+		}
+	}
+	return b
+}
+
+// RemoveKeeper removes keeper(s) from the Keepers slice if found. Can be
+// chained.
+func (b *Book) RemoveKeeper(keepers ...[]rune) *Book {
+	for _, keeper := range keepers {
+		for i := range b.Keepers {
+			if EqualRunesFold(&keeper, &b.Keepers[i]) {
+				b.Keepers[i] = b.Keepers[len(b.Keepers)-1]
+				b.Keepers = b.Keepers[:len(b.Keepers)-1]
+				break
+			}
+		}
+	}
+	return b
+}
+
+// Wipe overwrites the book id and keepers with zeroes.
+func (b *Book) Wipe() {
+	Wipe(&b.Id)
+	for i := range b.Keepers {
+		Wipe(&b.Keepers[i])
+	}
+	b.Keepers = nil
+}
+
+// vettedBookId returns the upper case book id or error if it is invalid.
+func vettedBookId(id string) ([]rune, error) {
+	bookId := []rune(strings.ToUpper(strings.TrimSpace(id)))
+	if len(bookId) == 0 || len(bookId) > MaxBookIdLength {
+		return nil, ErrInvalidBookId
+	}
+	for _, c := range bookId {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return nil, ErrInvalidBookId
+		}
+	}
+	return bookId, nil
+}
+
+// GetBook returns the book with bookId or error if not found.
+func (k *Krypto431) GetBook(bookId []rune) (*Book, error) {
+	id := []rune(strings.ToUpper(strings.TrimSpace(string(bookId))))
+	for i := range k.Books {
+		if EqualRunes(&k.Books[i].Id, &id) {
+			return &k.Books[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrBookNotFound, string(id))
+}
+
+// newUniqueBookId generates a book id of DefaultBookIdLength letters that is
+// not used by any other book.
+func (k *Krypto431) newUniqueBookId() []rune {
+	id := make([]rune, DefaultBookIdLength)
+	for {
+		for i := range id {
+			id[i] = rune(crand.Intn(26)) + 'A'
+		}
+		if _, err := k.GetBook(id); err != nil {
+			return id
+		}
+	}
+}
+
+// GenerateBook generates a key book of sheets keys numbered 1 to sheets. The
+// bookId is the series id printed on every sheet, an empty bookId generates a
+// random id. Expire and keepers are as in GenerateKeys(). Returns a pointer to
+// the new book or error if bookId is invalid or already exists.
+func (k *Krypto431) GenerateBook(bookId string, sheets int, expire *string, keepers ...string) (*Book, error) {
+	if sheets < 1 {
+		return nil, ErrNoSheets
+	}
+	var id []rune
+	if strings.TrimSpace(bookId) == "" {
+		id = k.newUniqueBookId()
+	} else {
+		var err error
+		id, err = vettedBookId(bookId)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := k.GetBook(id); err == nil {
+			return nil, fmt.Errorf("%w: %s", ErrBookExists, string(id))
+		}
+	}
+	expiryTime, err := expiryTimeOf(expire)
+	if err != nil {
+		return nil, err
+	}
+	book := Book{
+		Id:      id,
+		Sheets:  sheets,
+		Keepers: k.ExpandRecipients(VettedKeepers(keepers...)...),
+	}
+	book.Created.Time = time.Now()
+	book.Expires.Time = expiryTime
+	// Keepers of the book are recorded as for the keys (without our call-sign).
+	book.RemoveKeeper(k.CallSign)
+	for sheet := 1; sheet <= sheets; sheet++ {
+		k.NewKey(expiryTime, keepers...)
+		key := &k.Keys[len(k.Keys)-1]
+		key.Book = RuneCopy(&id)
+		key.Sheet = sheet
+	}
+	k.lock()
+	defer k.unlock()
+	k.Books = append(k.Books, book)
+	return &k.Books[len(k.Books)-1], nil
+}
+
+// BookKeys returns the keys of a book ordered by sheet number. Keys of a book
+// that have been deleted are not returned.
+func (k *Krypto431) BookKeys(bookId []rune) []*Key {
+	id := []rune(strings.ToUpper(strings.TrimSpace(string(bookId))))
+	keys := make([]*Key, 0)
+	for i := range k.Keys {
+		if EqualRunes(&k.Keys[i].Book, &id) {
+			keys = append(keys, &k.Keys[i])
+		}
+	}
+	// Insertion sort by sheet, books are small.
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j].Sheet < keys[j-1].Sheet; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+	return keys
+}
+
+// InBook returns true if the key belongs to any of the books (case
+// insensitive). Can be used in filter functions.
+func (k *Key) InBook(bookIds ...[]rune) bool {
+	for i := range bookIds {
+		if EqualRunesFold(&k.Book, &bookIds[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// SheetString returns the book id and sheet number of the key, e.g "ABCD 3/20"
+// or an empty string if the key is not part of a book.
+func (k *Key) SheetString() string {
+	if len(k.Book) == 0 {
+		return ""
+	}
+	sheets := 0
+	if k.instance != nil {
+		if book, err := k.instance.GetBook(k.Book); err == nil {
+			sheets = book.Sheets
+		}
+	}
+	if sheets == 0 {
+		return fmt.Sprintf("%s %d", string(k.Book), k.Sheet)
+	}
+	return fmt.Sprintf("%s %d/%d", string(k.Book), k.Sheet, sheets)
+}
+
+// sheetHeader returns the book header printed on every sheet, e.g "BOOK ABCD
+// SHEET 3 OF 20" or an empty string if the key is not part of a book.
+func (k *Key) sheetHeader() string {
+	if len(k.Book) == 0 {
+		return ""
+	}
+	header := fmt.Sprintf("BOOK %s SHEET %d", string(k.Book), k.Sheet)
+	if k.instance != nil {
+		if book, err := k.instance.GetBook(k.Book); err == nil {
+			header += fmt.Sprintf(" OF %d", book.Sheets)
+		}
+	}
+	return header
+}
+
+// SummaryOfBooks returns a formatted header and one line per book (with the
+// number of un-used keys left in the book) where filter returns true.
+func (k *Krypto431) SummaryOfBooks(filter func(book *Book) bool) (header []rune, lines [][]rune) {
+	var rows [][][]rune
+	for i := range k.Books {
+		if !filter(&k.Books[i]) {
+			continue
+		}
+		b := &k.Books[i]
+		keepers := "Anonymous"
+		if len(b.Keepers) > 0 {
+			keepers = b.JoinKeepers(",")
+		}
+		unused := 0
+		keys := k.BookKeys(b.Id)
+		for x := range keys {
+			if !keys[x].Used && !keys[x].Compromised {
+				unused++
+			}
+		}
+		rows = append(rows, [][]rune{
+			RuneCopy(&b.Id),
+			[]rune(keepers),
+			[]rune(b.Created.String()),
+			[]rune(b.Expires.String()),
+			[]rune(fmt.Sprintf("%d", b.Sheets)),
+			[]rune(fmt.Sprintf("%d", len(keys))),
+			[]rune(fmt.Sprintf("%d", unused)),
+		})
+	}
+	columnHeader := []string{"BOOK", "KEEPERS", "CREATED", "EXPIRES", "SHEETS", "KEYS", "UNUSED"}
+	return formatTable(columnHeader, rows)
+}
+
+// formatTable returns the column header and rows formatted as lines with each
+// column as wide as its widest cell.
+func formatTable(columnHeader []string, rows [][][]rune) (header []rune, lines [][]rune) {
+	columnSizes := make([]int, len(columnHeader))
+	for i := range columnHeader {
+		columnSizes[i] = len(columnHeader[i])
+	}
+	for _, row := range rows {
+		for i := range row {
+			if len(row[i]) > columnSizes[i] {
+				columnSizes[i] = len(row[i])
+			}
+		}
+	}
+	addSpace := 1
+	for i := range columnHeader {
+		header = append(header, withPadding([]rune(columnHeader[i]), columnSizes[i]+addSpace)...)
+	}
+	for _, row := range rows {
+		var line []rune
+		for i := range row {
+			line = append(line, withPadding(row[i], columnSizes[i]+addSpace)...)
+		}
+		lines = append(lines, line)
+	}
+	return
+}
+
+// copyBook returns a copy of the book (as the original may be wiped).
+func copyBook(b *Book) Book {
+	n := Book{
+		Id:      RuneCopy(&b.Id),
+		Sheets:  b.Sheets,
+		Created: b.Created,
+		Expires: b.Expires,
+	}
+	for i := range b.Keepers {
+		n.Keepers = append(n.Keepers, RuneCopy(&b.Keepers[i]))
+	}
+	return n
+}
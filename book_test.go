@@ -0,0 +1,73 @@
+package krypto431
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKrypto431_GenerateBook(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"))
+	if err := k.GenerateKeys(2, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	book, err := k.GenerateBook("qj01", 5, nil, "QJ,SA6MWA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book.IdString() != "QJ01" || book.Sheets != 5 || book.JoinKeepers(",") != "QJ" {
+		t.Errorf("Unexpected book %#v", *book)
+	}
+	keys := k.BookKeys([]rune("QJ01"))
+	if len(keys) != 5 || len(k.Keys) != 7 {
+		t.Fatalf("Expected 5 keys in book and 7 in total, got %d and %d", len(keys), len(k.Keys))
+	}
+	for i := range keys {
+		if keys[i].Sheet != i+1 {
+			t.Errorf("Expected sheet %d, got %d", i+1, keys[i].Sheet)
+		}
+		if !keys[i].InBook([]rune("qj01")) || keys[i].Expires != book.Expires {
+			t.Errorf("Key %s is not part of the book", keys[i].IdString())
+		}
+	}
+	if s := keys[2].SheetString(); s != "QJ01 3/5" {
+		t.Errorf("Expected QJ01 3/5, got %s", s)
+	}
+	if k.Keys[0].SheetString() != "" || k.Keys[0].InBook([]rune("QJ01")) {
+		t.Error("Expected first key to be a loose key")
+	}
+	if text := keys[4].String(); !strings.HasPrefix(text, "BOOK QJ01 SHEET 5 OF 5 _") {
+		t.Errorf("Expected book and sheet on printed key, got:\n%s", text)
+	}
+
+	if _, err := k.GenerateBook("QJ01", 1, nil); !errors.Is(err, ErrBookExists) {
+		t.Errorf("Expected %v, got %v", ErrBookExists, err)
+	}
+	if _, err := k.GenerateBook("QJ-01", 1, nil); !errors.Is(err, ErrInvalidBookId) {
+		t.Errorf("Expected %v, got %v", ErrInvalidBookId, err)
+	}
+	if _, err := k.GenerateBook("", 0, nil); !errors.Is(err, ErrNoSheets) {
+		t.Errorf("Expected %v, got %v", ErrNoSheets, err)
+	}
+	random, err := k.GenerateBook("", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(random.Id) != DefaultBookIdLength {
+		t.Errorf("Expected a random book id of length %d, got %s", DefaultBookIdLength, random.IdString())
+	}
+
+	// Exported keys bring their book along.
+	exported := k.ExportKeys(func(key *Key) bool { return key.InBook([]rune("QJ01")) })
+	if len(exported.Keys) != 5 || len(exported.Books) != 1 {
+		t.Fatalf("Expected 5 keys and 1 book, got %d and %d", len(exported.Keys), len(exported.Books))
+	}
+	if s := exported.Keys[0].SheetString(); s != "QJ01 1/5" {
+		t.Errorf("Expected QJ01 1/5, got %s", s)
+	}
+
+	_, lines := k.SummaryOfBooks(func(*Book) bool { return true })
+	if len(lines) != 2 || !strings.HasPrefix(string(lines[0]), "QJ01 ") {
+		t.Errorf("Unexpected summary of books: %q", lines)
+	}
+}
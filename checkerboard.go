@@ -0,0 +1,366 @@
+package krypto431
+
+// Numeric coding using a straddling checkerboard (CT-37 style). Instead of
+// character tables, each character is coded as one or more digits where the
+// most frequent letters use a single digit. The codes must be prefix-free
+// (no code is the beginning of another code) which makes it possible to decode
+// a stream of digits without separators. Keys of numeric schemes are digits
+// (0-9) and digits are enciphered with modulo 10 subtraction (deciphered with
+// addition) instead of the DIANA trigraph.
+//
+// CT37 CODING LEGEND
+//  0   no-op (padding)
+//  1 A   2 E   3 I   4 N   5 O   6 T
+// 70 B  71 C  72 D  73 F  74 G  75 H  76 J  77 K  78 L  79 M
+// 80 P  81 Q  82 R  83 S  84 U  85 V  86 W  87 X  88 Y  89 Z
+// 90 FIG  91 .  92 :  93 ?  94 ,  95 +  96 -  97 /  99 SPACE
+// 980 toggle binary mode  981 toggle case  982 change key (followed by key id)
+//
+// In figures mode (FIG) every digit is written three times (e.g 7 is 777),
+// figures mode is left with 90. In binary mode every byte is written as three
+// digits (000-255), 8 leaves binary mode, 7 changes key and 9 is a no-op
+// (padding). As in the letter based schemes, a binary section that is left is
+// the UTF-8 representation of runes not in the checkerboard while a binary
+// section that runs until the end of the message is the Binary payload.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Checkerboard describes a straddling checkerboard for numeric coding
+// schemes, see the CT37 scheme for an example.
+type Checkerboard struct {
+	// Codes maps upper case plain text characters to their digit code.
+	Codes map[rune]string
+	// Control codes when not in binary mode.
+	NoOp         string
+	Figures      string
+	BinaryToggle string
+	CaseToggle   string
+	ChangeKey    string
+	// Single digit control codes in binary mode.
+	BinaryLeave     rune
+	BinaryChangeKey rune
+	BinaryNoOp      rune
+}
+
+var (
+	ErrIncompleteCode = errors.New("encoded text ended in the middle of a code")
+)
+
+// ct37 returns the CT37 checkerboard (see legend above).
+func ct37() *Checkerboard {
+	return &Checkerboard{
+		Codes: map[rune]string{
+			'A': "1", 'E': "2", 'I': "3", 'N': "4", 'O': "5", 'T': "6",
+			'B': "70", 'C': "71", 'D': "72", 'F': "73", 'G': "74",
+			'H': "75", 'J': "76", 'K': "77", 'L': "78", 'M': "79",
+			'P': "80", 'Q': "81", 'R': "82", 'S': "83", 'U': "84",
+			'V': "85", 'W': "86", 'X': "87", 'Y': "88", 'Z': "89",
+			'.': "91", ':': "92", '?': "93", ',': "94", '+': "95",
+			'-': "96", '/': "97", ' ': "99",
+		},
+		NoOp:            "0",
+		Figures:         "90",
+		BinaryToggle:    "980",
+		CaseToggle:      "981",
+		ChangeKey:       "982",
+		BinaryLeave:     '8',
+		BinaryChangeKey: '7',
+		BinaryNoOp:      '9',
+	}
+}
+
+// IsNumeric returns true if the scheme codes into digits (has a
+// Checkerboard) instead of letters.
+func (s *CodingScheme) IsNumeric() bool {
+	return s.Checkerboard != nil
+}
+
+// controlCodes returns all control codes (not in binary mode).
+func (c *Checkerboard) controlCodes() []string {
+	return []string{c.NoOp, c.Figures, c.BinaryToggle, c.CaseToggle, c.ChangeKey}
+}
+
+// isPrefix returns true if p is the beginning of (but not equal to) any code.
+func (c *Checkerboard) isPrefix(p string) bool {
+	for _, code := range c.Codes {
+		if len(code) > len(p) && strings.HasPrefix(code, p) {
+			return true
+		}
+	}
+	for _, code := range c.controlCodes() {
+		if len(code) > len(p) && strings.HasPrefix(code, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// character returns the plain text character of a code.
+func (c *Checkerboard) character(code string) (rune, bool) {
+	for r, cc := range c.Codes {
+		if cc == code {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// validate checks that all codes are digits and prefix-free and that the
+// binary mode control codes do not collide with bytes (000-255).
+func (c *Checkerboard) validate() error {
+	codes := c.controlCodes()
+	for r, code := range c.Codes {
+		if unicode.IsDigit(r) {
+			return fmt.Errorf("%w: digits are coded in figures mode", ErrInvalidCodingScheme)
+		}
+		if unicode.ToUpper(r) != r {
+			return fmt.Errorf("%w: character %c is not upper case", ErrInvalidCodingScheme, r)
+		}
+		codes = append(codes, code)
+	}
+	for i := range codes {
+		if len(codes[i]) == 0 {
+			return fmt.Errorf("%w: empty checkerboard code", ErrInvalidCodingScheme)
+		}
+		for _, d := range codes[i] {
+			if d < '0' || d > '9' {
+				return fmt.Errorf("%w: checkerboard code %s is not digits", ErrInvalidCodingScheme, codes[i])
+			}
+		}
+		for j := range codes {
+			if i != j && strings.HasPrefix(codes[j], codes[i]) {
+				return fmt.Errorf("%w: checkerboard code %s is a prefix of %s", ErrInvalidCodingScheme, codes[i], codes[j])
+			}
+		}
+	}
+	// A triple digit in figures mode must not be mistaken for the figures code.
+	if len(c.Figures) != 2 || c.Figures[0] == c.Figures[1] {
+		return fmt.Errorf("%w: figures code must be two different digits", ErrInvalidCodingScheme)
+	}
+	binaryCodes := []rune{c.BinaryLeave, c.BinaryChangeKey, c.BinaryNoOp}
+	for i, r := range binaryCodes {
+		if r < '3' || r > '9' {
+			return fmt.Errorf("%w: binary mode code %c is not between 3 and 9", ErrInvalidCodingScheme, r)
+		}
+		for _, other := range binaryCodes[i+1:] {
+			if r == other {
+				return fmt.Errorf("%w: binary mode code %c is used more than once", ErrInvalidCodingScheme, r)
+			}
+		}
+	}
+	return nil
+}
+
+// legend returns the printable checkerboard, one line per code prefix.
+func (c *Checkerboard) legend(id string) string {
+	labels := map[string]string{
+		c.NoOp:         "PAD",
+		c.Figures:      "FIG",
+		c.BinaryToggle: "BIN",
+		c.CaseToggle:   "CASE",
+		c.ChangeKey:    "KEY",
+	}
+	for r, code := range c.Codes {
+		if r == spaceChar {
+			labels[code] = "SPC"
+		} else {
+			labels[code] = string(r)
+		}
+	}
+	codes := make([]string, 0, len(labels))
+	for code := range labels {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if len(codes[i]) != len(codes[j]) {
+			return len(codes[i]) < len(codes[j])
+		}
+		return codes[i] < codes[j]
+	})
+	legend := fmt.Sprintf("CODING LEGEND (%s CHECKERBOARD)", id)
+	prefix := "-"
+	for _, code := range codes {
+		if p := code[:len(code)-1]; p != prefix {
+			prefix = p
+			legend += LineBreak
+		} else {
+			legend += "  "
+		}
+		legend += code + "=" + labels[code]
+	}
+	legend += LineBreak + fmt.Sprintf("FIG: DIGITS X3 (7=777), %s ENDS. BIN: 000-255=BYTE %c=END %c=KEY %c=PAD",
+		c.Figures, c.BinaryLeave, c.BinaryChangeKey, c.BinaryNoOp) + LineBreak
+	return legend
+}
+
+// write appends a code to the output.
+func (state *codecState) write(code string, output *[]rune) {
+	for _, d := range code {
+		*output = append(*output, d)
+		state.charCounter++
+	}
+}
+
+// clearPending zeroes and empties the pending (undecoded) digits.
+func (state *codecState) clearPending() {
+	for i := range state.pending {
+		state.pending[i] = 0
+	}
+	state.pending = state.pending[:0]
+}
+
+// closeFigures leaves figures mode if the state is in figures mode.
+func (state *codecState) closeFigures(output *[]rune) {
+	if state.figures {
+		state.write(state.scheme.Checkerboard.Figures, output)
+		state.figures = false
+	}
+}
+
+// encodeDigitCharacter is encodeCharacter() for numeric schemes.
+func (state *codecState) encodeDigitCharacter(input *rune, output *[]rune) error {
+	cb := state.scheme.Checkerboard
+	c := unicode.ToUpper(*input)
+	defer func() { c = 0 }()
+	if c >= '0' && c <= '9' {
+		if state.binary {
+			state.write(string(cb.BinaryLeave), output)
+			state.binary = false
+		}
+		if !state.figures {
+			state.write(cb.Figures, output)
+			state.figures = true
+		}
+		*output = append(*output, c, c, c)
+		state.charCounter += 3
+		return nil
+	}
+	code, ok := cb.Codes[c]
+	if !ok {
+		// Not in the checkerboard, encode the rune in binary mode.
+		return state.encodeRuneAsBinary(input, output)
+	}
+	err := state.closeBinary(output)
+	if err != nil {
+		return err
+	}
+	if (isUpper(input) && state.shift) || (isLower(input) && !state.shift) {
+		state.write(cb.CaseToggle, output)
+		state.shift = !state.shift
+	}
+	state.write(code, output)
+	return nil
+}
+
+// encodeDigitByte is encodeByte() for numeric schemes.
+func (state *codecState) encodeDigitByte(input *byte, output *[]rune) error {
+	if !state.binary {
+		state.closeFigures(output)
+		state.write(state.scheme.Checkerboard.BinaryToggle, output)
+		state.binary = true
+	}
+	*output = append(*output, rune('0')+rune(*input/100), rune('0')+rune(*input/10%10), rune('0')+rune(*input%10))
+	state.charCounter += 3
+	return nil
+}
+
+// decodeDigit is decodeCharacter() for numeric schemes.
+func (state *codecState) decodeDigit(input *rune, output *[]rune) error {
+	if *input < '0' || *input > '9' {
+		return ErrInvalidCoding
+	}
+	if state.gotChangeKeyChar {
+		state.gotChangeKeyChar = false
+		state.keyChange = true
+	}
+	if state.keyChange {
+		return nil
+	}
+	cb := state.scheme.Checkerboard
+	switch {
+	case state.binary:
+		if len(state.pending) == 0 {
+			switch *input {
+			case cb.BinaryLeave:
+				// Leave binary mode, the bytes were runes not in the checkerboard.
+				state.binary = false
+				state.flushBinary(output)
+			case cb.BinaryChangeKey:
+				state.gotChangeKeyChar = true
+			case cb.BinaryNoOp:
+				// Padding, nothing to do.
+			case '0', '1', '2':
+				state.pending = append(state.pending, *input)
+			default:
+				return ErrInvalidControlChar
+			}
+			return nil
+		}
+		state.pending = append(state.pending, *input)
+		if len(state.pending) < 3 {
+			return nil
+		}
+		value := (state.pending[0]-'0')*100 + (state.pending[1]-'0')*10 + state.pending[2] - '0'
+		state.clearPending()
+		if value > 255 {
+			return ErrInvalidCoding
+		}
+		state.binaryBuffer = append(state.binaryBuffer, byte(value))
+	case state.figures:
+		state.pending = append(state.pending, *input)
+		p := string(state.pending)
+		switch {
+		case p == cb.Figures:
+			state.figures = false
+			state.clearPending()
+		case strings.Count(p, p[:1]) == len(p):
+			if len(p) == 3 {
+				*output = append(*output, state.pending[0])
+				state.charCounter++
+				state.clearPending()
+			}
+		case strings.HasPrefix(cb.Figures, p):
+		default:
+			state.clearPending()
+			return ErrInvalidCoding
+		}
+	default:
+		state.pending = append(state.pending, *input)
+		p := string(state.pending)
+		if char, ok := cb.character(p); ok {
+			if state.shift && char != spaceChar {
+				toLower(&char, &char)
+			}
+			*output = append(*output, char)
+			state.charCounter++
+			char = 0
+			state.clearPending()
+			return nil
+		}
+		switch p {
+		case cb.NoOp:
+		case cb.Figures:
+			state.figures = true
+		case cb.BinaryToggle:
+			state.binary = true
+		case cb.CaseToggle:
+			state.shift = !state.shift
+		case cb.ChangeKey:
+			state.gotChangeKeyChar = true
+		default:
+			if cb.isPrefix(p) {
+				return nil
+			}
+			state.clearPending()
+			return ErrInvalidControlChar
+		}
+		state.clearPending()
+	}
+	return nil
+}
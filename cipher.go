@@ -6,8 +6,7 @@ import (
 	"os"
 	"strings"
 	"unicode"
-
-	"github.com/sa6mwa/krypto431/diana"
+	"unicode/utf8"
 )
 
 var (
@@ -19,12 +18,15 @@ var (
 	// Q in 2nd = Q
 	// S in 2nd = Z
 	// W in 2nd = switch to binary mode, 1 byte is 2 runes, A to P is one nibble (4 bits, 1-16)
+	// W in binary mode = leave binary mode (back to the 2nd table)
+	// Y in binary mode = change key (as in 2nd)
+	// Z in binary mode = no-op (used for padding)
 	// X in 2nd = switch case (toggle case like CAPS LOCK)
 	// Y in 2nd = change key (followed by 5 character key after which the table is reset)
 
-	// Encoding uses two character tables (primary and secondary). NB! Any changes
-	// to the control characters in these tables need to be reflected in functions
-	// encodeCharacter() and decodeCharacter().
+	// Encoding uses two character tables (primary and secondary) described by a
+	// CodingScheme (see scheme.go). These are the tables of the default scheme
+	// (SV1), the encoder and decoder use the tables of the key's scheme.
 	// CharacterTablePrimary = `ABCDEFGHIJKLMNOP RSTUVWXY¤`,
 	// CharacterTableSecondary = `0123456789?-ÅÄÖ.Q,Z:+/¤¤¤¤`
 	//
@@ -48,6 +50,10 @@ const (
 	// binaryModeChar changes into a binary-only mode where A-P is one nibble
 	// (meaning that 1 rune is 2 characters). To exit the binary mode, put W again
 	// and you return to the secondary character table in the non-binary mode.
+	// Bytes of a binary section terminated by W are the UTF-8 representation of
+	// runes not found in any of the character tables and are decoded back into
+	// the PlainText. Bytes of a binary section that is never terminated (runs
+	// until the end of the message) is the Binary payload of the message.
 	binaryToggleChar rune = 'W'
 
 	// caseToggleChar adds strings.ToLower() on every character depending on previous
@@ -96,6 +102,7 @@ func toLower(c *rune, b *rune) {
 }
 
 type codecState struct {
+	scheme           *CodingScheme
 	keyIndex         int
 	table            int
 	numberOfTables   int
@@ -105,13 +112,21 @@ type codecState struct {
 	shift            bool
 	binary           bool
 	lowerNibble      bool
+	currentByte      byte
+	// figures and pending are only used by numeric schemes (checkerboard.go).
+	figures bool
+	pending []rune
+	// binaryBuffer holds decoded bytes of the current binary section. It is not
+	// cleared by reset() as a binary section can continue in the next key.
+	binaryBuffer []byte
 }
 
-func newState() *codecState {
+func newState(scheme *CodingScheme) *codecState {
 	return &codecState{
+		scheme:           scheme,
 		keyIndex:         0,
 		table:            0,
-		numberOfTables:   len(CharacterTables),
+		numberOfTables:   len(scheme.Tables),
 		charCounter:      0,
 		gotChangeKeyChar: false,
 		keyChange:        false,
@@ -150,19 +165,22 @@ func (state *codecState) reset(p *Message) error {
 func (state *codecState) reset() {
 	state.keyIndex = 0
 	state.table = 0
-	state.numberOfTables = len(CharacterTables)
+	state.numberOfTables = len(state.scheme.Tables)
 	state.charCounter = 0
 	state.gotChangeKeyChar = false
 	state.keyChange = false
 	state.shift = false
 	state.binary = false
 	state.lowerNibble = false
+	state.currentByte = 0
+	state.figures = false
+	state.clearPending()
 }
 
 func (state *codecState) nextTable(output *[]rune) {
 	state.table = (state.table + 1) % state.numberOfTables
 	if output != nil {
-		*output = append(*output, nextTableChar)
+		*output = append(*output, state.scheme.NextTableChar)
 		state.charCounter++
 	}
 }
@@ -173,7 +191,7 @@ func (state *codecState) toggleCase(output *[]rune) error {
 		if err != nil {
 			return err
 		}
-		*output = append(*output, caseToggleChar)
+		*output = append(*output, state.scheme.CaseToggleChar)
 		state.charCounter++
 	}
 	state.shift = !state.shift
@@ -186,7 +204,7 @@ func (state *codecState) toggleBinary(output *[]rune) error {
 		if err != nil {
 			return err
 		}
-		*output = append(*output, binaryToggleChar)
+		*output = append(*output, state.scheme.BinaryToggleChar)
 		state.charCounter++
 	}
 	state.binary = !state.binary
@@ -225,20 +243,32 @@ func (state *codecState) gotoTable(table int, output *[]rune) error {
 // another key. The character immediately following what this function writes
 // must use the new key to encipher or decipher rest of the encoded text. When
 // using the new key to cipher the first character, the state should have been
-// reset to the initial state. NB! This function does not validate that Key is a
-// valid key. Don't forget to state.reset() after calling this function.
-func (state *codecState) changeKey(key *Key, output *[]rune) error {
-	if output == nil || key == nil {
+// reset to the initial state. NB! This function does not validate that keyId
+// is a valid key. Don't forget to state.reset() after calling this function.
+func (state *codecState) changeKey(keyId *[]rune, output *[]rune) error {
+	if output == nil || keyId == nil {
 		return ErrNilPointer
 	}
+	if state.scheme.IsNumeric() {
+		cb := state.scheme.Checkerboard
+		if state.binary {
+			state.write(string(cb.BinaryChangeKey), output)
+		} else {
+			state.closeFigures(output)
+			state.write(cb.ChangeKey, output)
+		}
+		*output = append(*output, *keyId...)
+		state.charCounter = state.charCounter + len(*keyId)
+		return nil
+	}
 	err := state.gotoTable(secondaryTable, output)
 	if err != nil {
 		return err
 	}
-	*output = append(*output, changeKeyChar)
+	*output = append(*output, state.scheme.ChangeKeyChar)
 	state.charCounter++
-	*output = append(*output, key.Id...)
-	state.charCounter = state.charCounter + len(key.Id)
+	*output = append(*output, *keyId...)
+	state.charCounter = state.charCounter + len(*keyId)
 	return nil
 }
 
@@ -250,23 +280,49 @@ func (state *codecState) pad(numberOfCharacters int, output *[]rune) error {
 	if output == nil {
 		return ErrNilPointer
 	}
+	padChar := state.scheme.NextTableChar
+	if state.scheme.IsNumeric() {
+		padChar = []rune(state.scheme.Checkerboard.NoOp)[0]
+		if state.binary {
+			padChar = state.scheme.Checkerboard.BinaryNoOp
+		}
+	}
 	for i := 0; i < numberOfCharacters; i++ {
-		*output = append(*output, nextTableChar)
+		*output = append(*output, padChar)
 		state.charCounter++
 	}
 	return nil
 }
 
 // encodeCharacter figures out which character sequence to write into the
-// EncodedText field and adjust the state. When the first rune that can not be
-// found in one of the tables appear, we switch to binary mode and will not exit
-// this mode unless reaching the end or running out of key runes (where it will
-// switch to the next key).
+// EncodedText field and adjust the state. When a rune that can not be found in
+// one of the tables appear, we switch to binary mode and write the UTF-8
+// representation of the rune as nibbles. Binary mode is left (with the same
+// toggle character) as soon as the next rune can be found in one of the
+// tables. It is up to the calling function to leave binary mode after the
+// last rune of the PlainText (see closeBinary()).
 func (state *codecState) encodeCharacter(input *rune, output *[]rune) error {
-	if state.binary {
-		return errors.New("binary mode not implemented yet")
+	if input == nil || output == nil {
+		return ErrNilPointer
+	}
+	if state.scheme.IsNumeric() {
+		return state.encodeDigitCharacter(input, output)
+	}
+	// find character in one of the tables
+	c := *input
+	toUpper(&c, &c)
+	table, col := state.scheme.find(&c)
+	// zero the copy of rune
+	c = 0
+	if table < 0 {
+		// Not in any table, encode the rune in binary mode.
+		return state.encodeRuneAsBinary(input, output)
+	}
+	// Leave binary mode (we are on the secondary table after this).
+	err := state.closeBinary(output)
+	if err != nil {
+		return err
 	}
-
 	if (isUpper(input) && state.shift) || (isLower(input) && !state.shift) {
 		// need to shift/unshift...
 		err := state.toggleCase(output)
@@ -274,54 +330,143 @@ func (state *codecState) encodeCharacter(input *rune, output *[]rune) error {
 			return err
 		}
 	}
-	// find character in one of the tables
-	c := *input
-	toUpper(&c, &c)
-	foundIt := false
-	for t := range CharacterTables {
-		for i, tc := range CharacterTables[t] {
-			if tc == specialOpChar {
-				// specialOpChar is not part of any character table, skip it
-				continue
-			}
-			if c == tc {
-				foundIt = true
-				err := state.gotoTable(t, output)
-				if err != nil {
-					return err
-				}
-				char := rune(i) + rune('A') // Column A-Z in the character table
-				*output = append(*output, char)
-				state.charCounter++
-				char = 0
-				break
-			}
+	err = state.gotoTable(table, output)
+	if err != nil {
+		return err
+	}
+	char := rune(col) + rune('A') // Column A-Z in the character table
+	*output = append(*output, char)
+	state.charCounter++
+	char = 0
+	return nil
+}
+
+// encodeRuneAsBinary enters binary mode (if not already in binary mode) and
+// writes the UTF-8 representation of input as nibbles (2 runes per byte).
+func (state *codecState) encodeRuneAsBinary(input *rune, output *[]rune) error {
+	if input == nil || output == nil {
+		return ErrNilPointer
+	}
+	if !utf8.ValidRune(*input) {
+		return fmt.Errorf("%w: %U", ErrInvalidRune, *input)
+	}
+	buf := make([]byte, utf8.UTFMax)
+	defer WipeBytes(&buf)
+	n := utf8.EncodeRune(buf, *input)
+	for i := 0; i < n; i++ {
+		err := state.encodeByte(&buf[i], output)
+		if err != nil {
+			return err
 		}
-		if foundIt {
-			break
+	}
+	return nil
+}
+
+// encodeByte enters binary mode (if not already in binary mode) and writes
+// input as two nibbles where A is 0 and P is 15, high nibble first.
+func (state *codecState) encodeByte(input *byte, output *[]rune) error {
+	if input == nil || output == nil {
+		return ErrNilPointer
+	}
+	if state.scheme.IsNumeric() {
+		return state.encodeDigitByte(input, output)
+	}
+	if !state.binary {
+		err := state.toggleBinary(output)
+		if err != nil {
+			return err
 		}
 	}
-	// zero the copy of rune
-	c = 0
-	if !foundIt {
-		// enter binary mode
-		panic("binary mode not implemented yet")
+	*output = append(*output, rune('A')+rune(*input>>4), rune('A')+rune(*input&0x0f))
+	state.charCounter += 2
+	return nil
+}
+
+// closeBinary leaves binary mode if the state is in binary mode, otherwise it
+// does nothing. For numeric schemes, figures mode is also left.
+func (state *codecState) closeBinary(output *[]rune) error {
+	if state.scheme.IsNumeric() {
+		if state.binary {
+			state.write(string(state.scheme.Checkerboard.BinaryLeave), output)
+			state.binary = false
+		}
+		state.closeFigures(output)
+		return nil
+	}
+	if !state.binary {
+		return nil
+	}
+	return state.toggleBinary(output)
+}
+
+// encodedLength returns the number of runes encodeFunc would write to the
+// output given the current state. The state is not changed.
+func (state *codecState) encodedLength(encodeFunc func(s *codecState, output *[]rune) error) (int, error) {
+	s := *state
+	scratch := make([]rune, 0, utf8.UTFMax*2+4)
+	defer Wipe(&scratch)
+	err := encodeFunc(&s, &scratch)
+	if err != nil {
+		return 0, err
 	}
+	return len(scratch), nil
+}
 
+// flushBinary decodes the binary buffer as UTF-8 and appends the runes to the
+// output. Invalid UTF-8 sequences are decoded as utf8.RuneError. The binary
+// buffer is wiped when done.
+func (state *codecState) flushBinary(output *[]rune) {
+	b := state.binaryBuffer
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		*output = append(*output, r)
+		state.charCounter++
+		b = b[size:]
+	}
+	WipeBytes(&state.binaryBuffer)
+}
+
+// decodeBinary decodes one rune in binary mode.
+func (state *codecState) decodeBinary(input *rune, output *[]rune) error {
+	switch {
+	case *input == state.scheme.BinaryToggleChar:
+		// Leave binary mode, the bytes were runes not in the character tables.
+		state.toggleBinary(nil)
+		if state.lowerNibble {
+			return ErrIncompleteByte
+		}
+		state.flushBinary(output)
+	case *input == state.scheme.ChangeKeyChar:
+		state.gotChangeKeyChar = true
+	case *input == state.scheme.NextTableChar:
+		// Padding, nothing to do.
+	case *input >= 'A' && *input <= 'P':
+		nibble := byte(*input - rune('A'))
+		if state.lowerNibble {
+			state.binaryBuffer = append(state.binaryBuffer, state.currentByte|nibble)
+			state.currentByte = 0
+		} else {
+			state.currentByte = nibble << 4
+		}
+		state.lowerNibble = !state.lowerNibble
+	default:
+		return ErrInvalidControlChar
+	}
 	return nil
 }
 
 // decodeCharacter decodes a rune and appends plain text to the output rune
 // slice and/or sets the state for further processing by the calling function.
+// Bytes decoded in binary mode are kept in the state's binaryBuffer until
+// binary mode is left or the message ends.
 func (state *codecState) decodeCharacter(input *rune, output *[]rune) error {
+	if state.scheme.IsNumeric() {
+		return state.decodeDigit(input, output)
+	}
 	if *input < rune('A') || *input > rune('Z') {
 		return ErrInvalidCoding
 	}
 
-	if state.binary {
-		return errors.New("binary mode not implemented yet")
-	}
-
 	// If previous char was a the changeKeyChar (Y), current character is the
 	// first of the new key to change to.
 	if state.gotChangeKeyChar {
@@ -335,40 +480,34 @@ func (state *codecState) decodeCharacter(input *rune, output *[]rune) error {
 		return nil
 	}
 
+	if state.binary {
+		return state.decodeBinary(input, output)
+	}
+
 	// input character is an index (column) in one of the tables (state.table).
 	col := int(*input - rune('A'))
-	if col >= len(CharacterTables[state.table]) {
+	if col >= len(state.scheme.Tables[state.table]) {
 		return ErrTableTooShort
 	}
 
-	char := CharacterTables[state.table][col]
+	char := state.scheme.Tables[state.table][col]
 
 	if char == specialOpChar {
-		switch state.table {
-		case primaryTable:
-			// Only one control character in this table...
-			switch *input {
-			case nextTableChar:
-				state.nextTable(nil)
-			default:
-				return ErrInvalidControlChar
-			}
-		case secondaryTable:
-			// There are four control characters in this table...
-			switch *input {
-			case binaryToggleChar:
-				state.toggleBinary(nil)
-			case caseToggleChar:
-				state.toggleCase(nil)
-			case changeKeyChar:
-				state.gotChangeKeyChar = true
-			case nextTableChar:
-				state.nextTable(nil)
-			default:
-				return ErrInvalidControlChar
-			}
+		// NextTableChar is a control character in all tables, the rest are only
+		// found in the secondary table.
+		switch {
+		case *input == state.scheme.NextTableChar:
+			state.nextTable(nil)
+		case state.table != secondaryTable:
+			return ErrInvalidControlChar
+		case *input == state.scheme.BinaryToggleChar:
+			state.toggleBinary(nil)
+		case *input == state.scheme.CaseToggleChar:
+			state.toggleCase(nil)
+		case *input == state.scheme.ChangeKeyChar:
+			state.gotChangeKeyChar = true
 		default:
-			return ErrUnsupportedTable
+			return ErrInvalidControlChar
 		}
 	} else {
 		if state.shift && char != spaceChar {
@@ -381,78 +520,70 @@ func (state *codecState) decodeCharacter(input *rune, output *[]rune) error {
 	return nil
 }
 
-/* // Encode codes the PlainText field into the EncodedText field of a Message
-// struct. Encode will prepend one star (*) in the beginning and add a key
-// change if the message is more than Krypto431.KeyLength (minus characters
-// needed to make a key change) long and add a star (*) as a placeholder for a
-// key. In order to encrypt this encoded message you need to have key(s) of the
-// correct length available in the database or encryption will fail.
-func (t *Message) Encode() *[]rune {
-	state := newState()
-
-	encodedText := make([]rune, 0, len(t.PlainText)*2)
-
-	x := 0
-	for i := range t.PlainText {
-		// if x >= KeyLength-GroupSize-2, add star for key-change and reset x to 0
-		err := state.encodeCharacter(&t.PlainText[i], &encodedText)
-		if err != nil {
-			Wipe(&encodedText)
-			return nil
-		}
-		x++
-	}
-	//continue here
-	return &encodedText
+// FindKey returns the first un-used and un-compromised key of the configured
+// group size where all recipients (nets expanded to their members) are keepers
+// of that key. Expired keys are skipped unless AllowExpiredKeys is set. If the
+// recipient slice is empty, it will find the first un-used anonymous key (a key
+// without any keepers). Function returns a pointer to the key. FindKey will not
+// mark the key as used.
+func (r *Krypto431) FindKey(recipients ...[]rune) *Key {
+	return r.findKey(nil, recipients...)
 }
-*/
 
-/* // Decode decodes the EncodedText field into the PlainText field of a Message struct
-func (t *Message) Decode() error {
-	Wipe(&t.PlainText)
-	state := newState()
-	decodedText := make([]rune, 0, len(t.EncodedText))
-	for i := range t.EncodedText {
-		err := state.decodeCharacter(&t.EncodedText[i], &decodedText)
-		if err != nil {
-			return err
+// findKey is FindKey() for keys of coding scheme scheme (any scheme if nil),
+// used when chaining keys of a message.
+func (r *Krypto431) findKey(scheme *CodingScheme, recipients ...[]rune) *Key {
+	recipients = r.ExpandRecipients(recipients...)
+	for i := range r.Keys {
+		if r.keyMatchesRecipients(&r.Keys[i], scheme, recipients...) {
+			return &r.Keys[i]
 		}
 	}
-	t.PlainText = decodedText
-	Wipe(&decodedText)
+	// If we reached here, we found no key.
 	return nil
 }
-*/
 
-// FindKey returns the first un-used key of the configured group size where all
-// recipients are keepers of that key. If the recipient slice is empty, it will
-// find the first un-used anonymous key (a key without any keepers). Function
-// returns a pointer to the key. FindKey will not mark the key as used.
-func (r *Krypto431) FindKey(recipients ...[]rune) *Key {
-	numberOfRecipients := len(recipients)
+// FindKeys is similar to FindKey, but returns all un-used keys that FindKey
+// could return (in the order FindKey would return them if each key was marked
+// used). FindKeys will not mark any key as used.
+func (r *Krypto431) FindKeys(recipients ...[]rune) []*Key {
+	recipients = r.ExpandRecipients(recipients...)
+	var keys []*Key
 	for i := range r.Keys {
-		if r.Keys[i].Used {
-			continue
-		}
-		if len(r.Keys[i].Id) != r.GroupSize {
-			continue
-		}
-		if numberOfRecipients == 0 {
-			// Find an anonymous key
-			if len(r.Keys[i].Keepers) == 0 {
-				// Found an anonymous key
-				return &r.Keys[i]
-			}
-		} else {
-			// Find a key where all recipients are keepers of that key.
-			if AllNeedlesInHaystack(&recipients, &r.Keys[i].Keepers) {
-				// Found a key where all recipients are keepers.
-				return &r.Keys[i]
-			}
+		if r.keyMatchesRecipients(&r.Keys[i], nil, recipients...) {
+			keys = append(keys, &r.Keys[i])
 		}
 	}
-	// If we reached here, we found no key.
-	return nil
+	return keys
+}
+
+// keyMatchesRecipients returns true if key is an un-used, un-compromised and
+// un-expired (unless allowed) key of the configured group size where all
+// recipients are keepers of that key (or an anonymous key if there are no
+// recipients). If scheme is not nil, the key must also use that coding scheme.
+func (r *Krypto431) keyMatchesRecipients(key *Key, scheme *CodingScheme, recipients ...[]rune) bool {
+	if key.Used || key.Compromised {
+		return false
+	}
+	if scheme != nil && scheme.checkKey(key) != nil {
+		return false
+	}
+	if key.IsExpired() && !r.AllowExpiredKeys {
+		return false
+	}
+	// A partially consumed key must not be used from the beginning again.
+	if key.Offset > 0 && !r.PartialKeys {
+		return false
+	}
+	if len(key.Id) != r.GroupSize {
+		return false
+	}
+	if len(recipients) == 0 {
+		// Find an anonymous key
+		return len(key.Keepers) == 0
+	}
+	// Find a key where all recipients are keepers of that key.
+	return AllNeedlesInHaystack(&recipients, &key.Keepers)
 }
 
 // GetKey() searches for a Key object with an Id of keyId and returns a pointer
@@ -491,8 +622,8 @@ func (m *Message) EnrichWithKey() error {
 	if len(m.Id) == 0 {
 		m.Id = m.instance.NewUniqueMessageId()
 	}
-	if len(m.PlainText) == 0 {
-		return errors.New("message plain text is empty")
+	if len(m.PlainText) == 0 && len(m.Binary) == 0 {
+		return errors.New("message plain text and binary are empty")
 	}
 	if len(m.KeyId) > 0 {
 		// already enriched with a KeyId, check if it's of correct length or used, if so, return error otherwise OK
@@ -505,6 +636,11 @@ func (m *Message) EnrichWithKey() error {
 					return fmt.Errorf("message already enriched with used KeyId %s", string(m.KeyId))
 				} else if m.instance.Keys[i].Compromised {
 					return fmt.Errorf("message already enriched with compromised KeyId %s", string(m.KeyId))
+				} else if !m.instance.AllowExpiredKeys && m.instance.Keys[i].IsExpired() {
+					return fmt.Errorf("message already enriched with expired KeyId %s", string(m.KeyId))
+				} else if m.instance.Keys[i].Offset > 0 && !m.instance.PartialKeys {
+					// A partially consumed key must not be used from the beginning again.
+					return fmt.Errorf("message already enriched with partially used KeyId %s", string(m.KeyId))
 				} else {
 					return nil
 				}
@@ -522,7 +658,11 @@ func (m *Message) EnrichWithKey() error {
 		return nil
 	}
 
-	designatedKey := m.instance.FindKey(m.Recipients...)
+	// Finding and marking the key is guarded by the instance mutex so that
+	// concurrent messages never get the same key.
+	m.instance.lock()
+	defer m.instance.unlock()
+	designatedKey := m.instance.FindKey(m.keyRecipients()...)
 	if designatedKey == nil {
 		if len(m.Recipients) == 0 {
 			return errors.New("did not find an anonymous key (a key without keepers)")
@@ -536,15 +676,21 @@ func (m *Message) EnrichWithKey() error {
 	}
 	// Mark key as used.
 	designatedKey.Used = true
-	// Enrich message instance with key id.
-	m.KeyId = designatedKey.Id
+	// Enrich message instance with a copy of the key id (the message key id is
+	// wiped with the message).
+	m.KeyId = RuneCopy(&designatedKey.Id)
 	return nil
 }
 
 // Encipher() enciphers the PlainText field into the CipherText field of a
 // Message object. Verbs encrypt and decrypt are only used for AES
 // encryption/decryption of the persistance file, while words encipher and
-// decipher are used for message ciphering in Krypto431.
+// decipher are used for message ciphering in Krypto431. If the Binary field is
+// populated, it is encoded in binary mode after the PlainText and both can be
+// recovered using Decipher(). Encoding is done by Encode() which will chain
+// additional keys (where all Recipients are Keepers) if the message is too
+// long for one key. If the instance has IntegrityCheck enabled, check groups
+// are appended to the CipherText (see integrity.go).
 func (m *Message) Encipher() error {
 	if len(m.Id) == 0 {
 		m.Id = m.instance.NewUniqueMessageId()
@@ -554,118 +700,158 @@ func (m *Message) Encipher() error {
 		return fmt.Errorf("unable to enrich message with a key: %w", err)
 	}
 	Wipe(&m.CipherText)
-	// TODO: The encode phase should really go into a new Encode() function.
-	//
-	// Encode plaintext...
-	state := newState()
-	// An encoded message contains one or more chunks. Each chunk is enciphered
-	// with a key. The last chunk need to fill out with table changers (Z) so that
-	// the sum of the length of all chunks are divided by GroupSize without a
-	// remainder (mod % GroupSize).
-	chunks := make([]chunk, 0, DefaultChunkCapacity)
-	chunk := newChunk(m.instance.GroupSize)
-	// First chunk obviously uses the message key id...
+	// First segment obviously uses the message key id...
 	keyPtr, err := m.instance.GetKey(m.KeyId)
 	if err != nil {
 		return err
 	}
-	chunk.key = keyPtr
+	// The message is coded with the scheme of the first key, all chained keys
+	// must use the same scheme.
+	scheme, err := keyPtr.Scheme()
+	if err != nil {
+		return err
+	}
+	keyPtr.Used = true
+	// With PartialKeys, the first key is used from its first un-used position.
+	start := 0
+	if m.instance.PartialKeys {
+		start = keyPtr.Offset
+	}
+	// keys[i] is the key used to encipher segments[i].
+	keys := make([]*Key, 0, DefaultChunkCapacity)
+	keys = append(keys, keyPtr)
 	// If something fails, we need to release all keys we have used.
 	releaseKeys := true
 	defer func() {
 		if releaseKeys {
-			m.instance.MarkKeyUsed(m.KeyId, false)
-			Wipe(&m.KeyId)
-			chunk.key.Used = false
-			for i := range chunks {
-				chunks[i].key.Used = false
-				chunks[i].Wipe()
+			m.instance.lock()
+			for i := range keys {
+				keys[i].Used = false
 			}
-			chunk.Wipe()
+			m.instance.unlock()
+			Wipe(&m.KeyId)
+			Wipe(&m.CipherText)
 		}
 	}()
-	for i := range m.PlainText {
-		if state.charCounter >= chunk.key.KeyLength()-m.instance.GroupSize-ControlCharactersNeededToChangeKey {
-			keyPtr := m.instance.FindKey(m.Recipients...)
-			if keyPtr == nil {
-				return ErrOutOfKeys
+	segments, err := scheme.Encode(m.PlainText, EncodeOptions{
+		Binary:    m.Binary,
+		GroupSize: m.instance.GroupSize,
+		KeyId:     keyPtr.Id,
+		KeyLength: keyPtr.KeyLength() - start,
+		NextKey: func() ([]rune, int, error) {
+			m.instance.lock()
+			defer m.instance.unlock()
+			key := m.instance.findKey(scheme, m.keyRecipients()...)
+			if m.instance.PartialKeys {
+				// Chained keys are used from the beginning.
+				key = m.instance.findUntouchedKey(scheme, m.keyRecipients()...)
 			}
-			err := state.changeKey(keyPtr, &chunk.encodedText)
-			if err != nil {
-				return err
+			if key == nil {
+				return nil, 0, ErrOutOfKeys
 			}
-			keyPtr.Used = true
-			chunks = append(chunks, chunk)
-			chunk = newChunk(m.instance.GroupSize)
-			chunk.key = keyPtr
-			state.reset()
+			key.Used = true
+			keys = append(keys, key)
+			return key.Id, key.KeyLength(), nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	defer WipeSegments(segments)
+	if len(segments) != len(keys) {
+		return fmt.Errorf("expected %d segments, but got %d", len(keys), len(segments))
+	}
+	if m.instance.PartialKeys {
+		// The indicator group (start offset) is sent in the clear after the key id.
+		combiner, err := keys[0].GetCombiner()
+		if err != nil {
+			return err
 		}
-		err := state.encodeCharacter(&m.PlainText[i], &chunk.encodedText)
+		indicator, err := EncodeIndicator(start, m.instance.GroupSize, []rune(combiner.Alphabet()))
 		if err != nil {
 			return err
 		}
+		m.CipherText = append(m.CipherText, indicator...)
 	}
-	// Count length of all chunks and make sure the last chunk compensates for
-	// modulo GroupSize length of all EncodedTexts.
-	// Last chunk is current chunk...
-	lengthOfAllEncodedTexts := len(chunk.encodedText)
-	for i := range chunks {
-		lengthOfAllEncodedTexts += len(chunks[i].encodedText)
-	}
-	err = state.pad((m.instance.GroupSize-(lengthOfAllEncodedTexts%m.instance.GroupSize))%m.instance.GroupSize, &chunk.encodedText)
-	if err != nil {
-		return err
-	}
-	// Finally, add the current chunk to the slice...
-	chunks = append(chunks, chunk)
 	//
-	// Encipher each encodedText with each chunk's key...
+	// Encipher each encoded text with each segment's key...
 	//
-	for i := range chunks {
-		if chunks[i].key == nil {
-			return ErrNilPointer
-		}
-		if len(chunks[i].encodedText) > chunks[i].key.KeyLength() {
-			tooShortKeyMsg := "key %s is too short to encipher chunk %d "
-			if len(chunks) > 1 {
-				tooShortKeyMsg += "out of %d chunks"
+	for i := range segments {
+		combiner, err := keys[i].GetCombiner()
+		if err != nil {
+			return err
+		}
+		keyOffset := 0
+		if i == 0 {
+			keyOffset = start
+		}
+		if len(segments[i].EncodedText) > keys[i].KeyLength()-keyOffset {
+			tooShortKeyMsg := "key %s is too short to encipher segment %d "
+			if len(segments) > 1 {
+				tooShortKeyMsg += "out of %d segments"
 			} else {
-				tooShortKeyMsg += "(message is only %d chunk)"
+				tooShortKeyMsg += "(message is only %d segment)"
 			}
-			return fmt.Errorf(tooShortKeyMsg, string(chunks[i].key.Id), i+1, len(chunks))
+			return fmt.Errorf(tooShortKeyMsg, string(keys[i].Id), i+1, len(segments))
 		}
-		for ki := range chunks[i].encodedText {
+		for ki := range segments[i].EncodedText {
 			var output rune
-			err := diana.TrigraphRune(&output, &chunks[i].key.Runes[ki], &chunks[i].encodedText[ki])
+			err := combiner.Encipher(&output, &segments[i].EncodedText[ki], &keys[i].Runes[keyOffset+ki])
 			if err != nil {
 				return err
 			}
 			m.CipherText = append(m.CipherText, output)
 		}
 	}
-	// DEBUG: remove print-outs below...
-	// for i := range chunks {
-	// 	grouped, err := groups(&chunks[i].encodedText, m.instance.GroupSize, 0)
-	// 	if err != nil {
-	// 		return err
-	// 	}
-	// 	fmt.Printf("key: %s, enctxt: %s"+LineBreak, string(chunks[i].key.Id), string(*grouped))
-	// }
-	// grouped, err := groups(&m.CipherText, m.instance.GroupSize, 0)
-	// if err != nil {
-	// 	return err
-	// }
-	// fmt.Printf("        ciphertext: %s"+LineBreak, string(*grouped))
+	if m.instance.IntegrityCheck {
+		combiner, err := keys[0].GetCombiner()
+		if err != nil {
+			return err
+		}
+		check, err := CheckGroups(m.CipherText, m.instance.GroupSize, []rune(combiner.Alphabet()))
+		if err != nil {
+			return err
+		}
+		m.CipherText = append(m.CipherText, check...)
+		Wipe(&check)
+	}
+	if m.instance.PartialKeys {
+		// All but the last key are used up, the rest of the last key is left for
+		// the next message.
+		last := len(keys) - 1
+		lastStart := 0
+		if last == 0 {
+			lastStart = start
+		}
+		keys[last].Used = false
+		keys[last].consume(lastStart, len(segments[last].EncodedText), m.instance.GroupSize)
+	}
+	m.instance.lock()
+	for i := range keys {
+		m.instance.record(JournalUsed, keys[i], m.Id, "")
+	}
+	m.instance.unlock()
 	releaseKeys = false
 	return nil
 }
 
 // Decipher deciphers the CipherText field into the PlainText field of a Message
 // object. PlainText will be replaced with deciphered text if text already
-// exists. Decipher does not use a separate decoding function as simultaneous
-// decoding is needed to support CipherText enciphered with multiple keys. If
+// exists. A binary payload in the CipherText replaces the Binary field. If the
+// instance has IntegrityCheck enabled, the check groups are verified first and
+// an *IntegrityError is returned (nothing is deciphered) if any chunk failed.
+// Decipher decodes with a Decoder one rune at a time as simultaneous decoding
+// is needed to support CipherText enciphered with multiple keys. If
 // deciphering succeeds, all keys used in the message will be marked `used`.
 func (m *Message) Decipher() error {
+	return m.decipher(nil)
+}
+
+// decipher is Decipher() when report is nil and DecipherTolerant() when report
+// is not nil. In tolerant mode, runes that can not be deciphered or decoded
+// are skipped and marked in the plain text (see SuspectGroupMarker) and their
+// groups are added to the report.
+func (m *Message) decipher(report *DecipherReport) error {
 	if len(m.Id) == 0 {
 		m.Id = m.instance.NewUniqueMessageId()
 	}
@@ -679,53 +865,165 @@ func (m *Message) Decipher() error {
 	if err != nil {
 		return err
 	}
+	scheme, err := keyPtr.Scheme()
+	if err != nil {
+		return err
+	}
+	combiner, err := keyPtr.GetCombiner()
+	if err != nil {
+		return err
+	}
+	// If the instance uses check groups, verify the cipher text before
+	// deciphering it and leave the check groups out.
+	cipherText := m.CipherText
+	if m.instance.IntegrityCheck {
+		n, err := VerifyCheckGroups(m.CipherText, m.instance.GroupSize, []rune(combiner.Alphabet()))
+		var integrityError *IntegrityError
+		switch {
+		case report != nil && errors.As(err, &integrityError):
+			report.Integrity = integrityError
+		case err != nil:
+			return err
+		}
+		cipherText = m.CipherText[:n]
+	}
+	// groupBase is the group number (as transmitted where the key id is group 1)
+	// of the first group in cipherText.
+	groupBase := 2
+	// With PartialKeys, the first key is used from the offset in the indicator
+	// group.
+	start := 0
+	if m.instance.PartialKeys {
+		if len(cipherText) < m.instance.GroupSize*2 {
+			return ErrCipherTextTooShort
+		}
+		start, err = DecodeIndicator(cipherText[:m.instance.GroupSize], []rune(combiner.Alphabet()))
+		if err != nil {
+			return err
+		}
+		if start >= keyPtr.KeyLength() {
+			return fmt.Errorf("%w: offset %d is beyond the end of key %s", ErrInvalidIndicator, start, keyPtr.IdString())
+		}
+		cipherText = cipherText[m.instance.GroupSize:]
+		groupBase++
+	}
 	keyStack := make([]*Key, 0, DefaultChunkCapacity)
 	keyStack = append(keyStack, keyPtr)
+	keyIndexCounter := start
 	markKeysUsed := false
 	defer func() {
 		if markKeysUsed {
+			m.instance.lock()
+			defer m.instance.unlock()
+			// Keys already used as far as this message are not recorded in the
+			// journal again (messages can be deciphered more than once).
+			before := make([]Key, len(keyStack))
 			for i := range keyStack {
+				before[i] = Key{Used: keyStack[i].Used, Offset: keyStack[i].Offset}
 				keyStack[i].Used = true
 			}
+			if m.instance.PartialKeys {
+				last := len(keyStack) - 1
+				lastStart := 0
+				if last == 0 {
+					lastStart = start
+				}
+				keyStack[last].Used = false
+				keyStack[last].consume(lastStart, keyIndexCounter-lastStart, m.instance.GroupSize)
+			}
+			for i := range keyStack {
+				if keyStack[i].Used != before[i].Used || keyStack[i].Offset != before[i].Offset {
+					m.instance.record(JournalUsed, keyStack[i], m.Id, "")
+				}
+			}
 		}
 	}()
-	if keyPtr.Used {
+	switch {
+	case keyPtr.Used:
 		fmt.Fprintf(os.Stderr, "Warning: key %s marked as already used!"+LineBreak, keyPtr.IdString())
+	case m.instance.PartialKeys && start < keyPtr.Offset:
+		fmt.Fprintf(os.Stderr, "Warning: key %s already used up to position %d, message starts at %d!"+LineBreak, keyPtr.IdString(), keyPtr.Offset, start)
 	}
 	Wipe(&m.PlainText)
-	keyIndexCounter := 0
-	nextKey := make([]rune, 0, m.instance.GroupSize)
-	state := newState()
-	for i := range m.CipherText {
+	WipeBytes(&m.Binary)
+	decoder := scheme.NewDecoder(m.instance.GroupSize)
+	defer decoder.Wipe()
+	// suspect marks the group of cipherText[i] as suspect in tolerant mode,
+	// returns err if not in tolerant mode.
+	suspect := func(i int, err error) error {
+		if report == nil {
+			return err
+		}
+		decoder.Skip(report.add(i/m.instance.GroupSize+groupBase, err))
+		return nil
+	}
+	lastGroup := len(cipherText)/m.instance.GroupSize + groupBase - 1
+	for i := range cipherText {
 		var encodedChar rune
 		if keyIndexCounter >= len(keyPtr.Runes) {
-			//fmt.Fprintf(os.Stderr, "%d (keylen=%d)\n", keyIndexCounter, len(keyPtr.Runes))
-			return fmt.Errorf("out-of-key error, %s is too short", string(keyPtr.Id))
+			err := fmt.Errorf("out-of-key error, %s is too short", string(keyPtr.Id))
+			if report == nil {
+				return err
+			}
+			// The rest of the message can not be deciphered.
+			decoder.Skip(report.addRest(i/m.instance.GroupSize+groupBase, lastGroup, err))
+			break
 		}
-		err := diana.TrigraphRune(&encodedChar, &keyPtr.Runes[keyIndexCounter], &m.CipherText[i])
+		err := combiner.Decipher(&encodedChar, &cipherText[i], &keyPtr.Runes[keyIndexCounter])
+		keyIndexCounter++
 		if err != nil {
-			return err
+			if err := suspect(i, err); err != nil {
+				return err
+			}
+			continue
 		}
-		keyIndexCounter++
-		err = state.decodeCharacter(&encodedChar, &m.PlainText)
+		nextKeyId, err := decoder.Decode(encodedChar)
+		encodedChar = 0
 		if err != nil {
-			return err
+			if err := suspect(i, err); err != nil {
+				return err
+			}
+			continue
 		}
-		if state.keyChange {
-			nextKey = append(nextKey, encodedChar)
-			if len(nextKey) >= m.instance.GroupSize {
-				var err error
-				keyPtr, err = m.instance.GetKey(nextKey)
-				if err != nil {
+		if nextKeyId != nil {
+			keyPtr, err = m.instance.GetKey(nextKeyId)
+			Wipe(&nextKeyId)
+			if err == nil {
+				err = scheme.checkKey(keyPtr)
+			}
+			if err == nil {
+				combiner, err = keyPtr.GetCombiner()
+			}
+			if err != nil {
+				if report == nil {
 					return err
 				}
-				keyStack = append(keyStack, keyPtr)
-				keyIndexCounter = 0
-				Wipe(&nextKey)
-				state.reset()
+				// The rest of the message can not be deciphered without the key.
+				decoder.discardIncomplete()
+				decoder.Skip(report.addRest(i/m.instance.GroupSize+groupBase, lastGroup, err))
+				break
 			}
+			keyStack = append(keyStack, keyPtr)
+			keyIndexCounter = 0
+		}
+	}
+	if report != nil {
+		if err := decoder.incomplete(); err != nil {
+			decoder.Skip(report.add(lastGroup, err))
+			decoder.discardIncomplete()
 		}
 	}
+	plainText, binary, keyChanges, err := decoder.Close()
+	if err != nil {
+		return err
+	}
+	for i := range keyChanges {
+		Wipe(&keyChanges[i].KeyId)
+	}
+	m.PlainText = plainText
+	if len(binary) > 0 {
+		m.Binary = binary
+	}
 	markKeysUsed = true
 	return nil
 }
@@ -753,7 +1051,7 @@ func (m *Message) TryDecipherPlainText(dryrun ...bool) error {
 			continue
 		}
 		c := unicode.ToUpper(m.PlainText[i])
-		if !(c >= 'A' && c <= 'Z') {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
 			return ErrNotCipherText
 		}
 		filteredText = append(filteredText, c)
@@ -787,9 +1085,19 @@ func (m *Message) TryDecipherPlainText(dryrun ...bool) error {
 	m.KeyId = RuneCopy(&key.Id)
 	cipherText := filteredText[m.instance.GroupSize:]
 	m.CipherText = RuneCopy(&cipherText)
-	err = m.Decipher()
-	if err != nil {
-		return fmt.Errorf("%v: %w", ErrNotCipherText, err)
+	if m.instance.tolerantDecipher {
+		report, err := m.DecipherTolerant()
+		if err != nil {
+			return fmt.Errorf("%v: %w", ErrNotCipherText, err)
+		}
+		if !report.OK() {
+			fmt.Fprintf(os.Stderr, "Warning: message %s has suspect groups, %s"+LineBreak, string(m.Id), report)
+		}
+	} else {
+		err = m.Decipher()
+		if err != nil {
+			return fmt.Errorf("%v: %w", ErrNotCipherText, err)
+		}
 	}
 	restore = false
 	return nil
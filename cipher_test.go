@@ -1,5 +1,12 @@
 package krypto431
 
+import (
+	"errors"
+	"testing"
+
+	"github.com/sa6mwa/krypto431/diana"
+)
+
 /*
 func Test_PlainText_Encode(t *testing.T) {
 	testTable := []struct {
@@ -26,3 +33,105 @@ func Test_PlainText_Encode(t *testing.T) {
 
 }
 */
+
+func TestMessage_EncipherDecipher(t *testing.T) {
+	testTable := []struct {
+		name      string
+		keyLength int
+		plainText []rune
+		binary    []byte
+	}{
+		{"text", DefaultKeyLength, []rune("Hello world, this is a short message."), nil},
+		{"unsupported runes", DefaultKeyLength, []rune("Smörgåsbord 🍞 and café ☕."), nil},
+		{"ends with unsupported rune", DefaultKeyLength, []rune("Thumbs up 👍"), nil},
+		{"binary only", DefaultKeyLength, nil, []byte{0x00, 0x01, 0x7f, 0x80, 0xfe, 0xff}},
+		{"text and binary", DefaultKeyLength, []rune("GPX track 🛰"), []byte("<gpx version=\"1.1\"></gpx>")},
+		{"multiple keys", MinimumSupportedKeyLength, []rune("This message is 😀 longer than one key and will need several keys."), []byte{0xde, 0xad, 0xbe, 0xef, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66}},
+	}
+	for _, table := range testTable {
+		t.Run(table.name, func(t *testing.T) {
+			k := New(WithKeyLength(table.keyLength), WithCallSign("SA6MWA"))
+			err := k.GenerateKeys(50, nil, "QJ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := &Message{
+				instance:   &k,
+				Recipients: VettedRecipients("QJ"),
+				From:       RuneCopy(&k.CallSign),
+				PlainText:  RuneCopy(&table.plainText),
+				Binary:     ByteCopy(&table.binary),
+			}
+			err = msg.Encipher()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msg.CipherText)%k.GroupSize != 0 {
+				t.Errorf("cipher text is %d characters long, not a multiple of group size %d", len(msg.CipherText), k.GroupSize)
+			}
+			for i := range k.Keys {
+				k.Keys[i].Used = false
+			}
+			received := &Message{
+				instance:   &k,
+				KeyId:      RuneCopy(&msg.KeyId),
+				CipherText: RuneCopy(&msg.CipherText),
+			}
+			err = received.Decipher()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(received.PlainText) != string(table.plainText) {
+				t.Errorf("Got plain text \"%s\", but wanted \"%s\"", string(received.PlainText), string(table.plainText))
+			}
+			if string(received.Binary) != string(table.binary) {
+				t.Errorf("Got binary %q, but wanted %q", received.Binary, table.binary)
+			}
+		})
+	}
+}
+
+func TestMessage_EncipherWithCombiner(t *testing.T) {
+	k := New(WithKeyLength(MinimumSupportedKeyLength), WithCallSign("SA6MWA"), WithCombiner("vigenere"))
+	if err := k.Assert(); err != nil {
+		t.Fatal(err)
+	}
+	err := k.GenerateKeys(50, nil, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Keys[0].Combiner != diana.Vigenere {
+		t.Fatalf("Expected key combiner %s, got %s", diana.Vigenere, k.Keys[0].Combiner)
+	}
+	// Mixed combiners in a chain of keys.
+	for i := range k.Keys {
+		if i%2 == 1 {
+			k.Keys[i].Combiner = diana.Beaufort
+		}
+	}
+	text := []rune("This message is longer than one key and is enciphered with Vigenère and Beaufort.")
+	msg := &Message{instance: &k, Recipients: VettedRecipients("QJ"), PlainText: RuneCopy(&text)}
+	err = msg.Encipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range k.Keys {
+		k.Keys[i].Used = false
+	}
+	received := &Message{instance: &k, KeyId: RuneCopy(&msg.KeyId), CipherText: RuneCopy(&msg.CipherText)}
+	err = received.Decipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(received.PlainText) != string(text) {
+		t.Errorf("Got \"%s\", wanted \"%s\"", string(received.PlainText), string(text))
+	}
+	numeric := New(WithCodingScheme("CT37"), WithCombiner(diana.DIANA))
+	if err := numeric.Assert(); !errors.Is(err, ErrCombinerMismatch) {
+		t.Errorf("Expected %v, got %v", ErrCombinerMismatch, err)
+	}
+	unknown := New(WithCombiner("ROT13"))
+	if err := unknown.Assert(); !errors.Is(err, diana.ErrUnknownCombiner) {
+		t.Errorf("Expected %v, got %v", diana.ErrUnknownCombiner, err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"unicode/utf8"
+
+	"github.com/sa6mwa/krypto431"
+	"github.com/urfave/cli/v2"
+)
+
+func files(c *cli.Context) error {
+	if c.IsSet(oEncipher) && c.IsSet(oDecipher) {
+		return fmt.Errorf("can not use both --%s and --%s, choose one", oEncipher, oDecipher)
+	}
+	if !c.IsSet(oEncipher) && !c.IsSet(oDecipher) {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+	o := getOptions(c)
+	k := krypto431.New(krypto431.WithPersistence(o.persistence), krypto431.WithInteractive(true))
+	defer k.Close()
+	err := setSaltAndPFK(c, &k)
+	if err != nil {
+		return err
+	}
+	err = lockForChanges(c, &k, oEncipher, oDecipher)
+	if err != nil {
+		return err
+	}
+	err = k.Load()
+	if err != nil {
+		return err
+	}
+
+	// encipher file
+	if c.IsSet(oEncipher) {
+		if utf8.RuneCountInString(o.encipher) == 0 {
+			return ErrMissingInputFilename
+		}
+		output := o.output
+		if !c.IsSet(oOutput) {
+			output = o.encipher + ".txt"
+		}
+		if utf8.RuneCountInString(output) == 0 {
+			return ErrMissingOutputFilename
+		}
+		proceed, err := okToWrite(output, o.yes)
+		if err != nil {
+			return err
+		}
+		if !proceed {
+			return nil
+		}
+		msg, err := k.NewFileMessage(o.encipher, o.to...)
+		if err != nil {
+			return err
+		}
+		err = msg.CipherTextFile(output)
+		if err != nil {
+			return err
+		}
+		err = k.Save()
+		if err != nil {
+			return err
+		}
+		eprintf("Enciphered %s (%d bytes) into %s as message %s, saved in %s."+LineBreak, o.encipher, len(msg.Binary), output, msg.IdString(), k.GetPersistence())
+	}
+
+	// decipher file
+	if c.IsSet(oDecipher) {
+		if utf8.RuneCountInString(o.decipher) == 0 {
+			return ErrMissingInputFilename
+		}
+		msg, err := k.NewFileMessageFromCipherTextFile(o.decipher)
+		if err != nil {
+			return err
+		}
+		name, size, err := msg.FileHeader()
+		if err != nil {
+			return err
+		}
+		output := o.output
+		if !c.IsSet(oOutput) {
+			output = name
+		}
+		proceed, err := okToWrite(output, o.yes)
+		if err != nil {
+			return err
+		}
+		if proceed {
+			output, err = msg.WriteFile(output, true)
+			if err != nil {
+				return err
+			}
+			eprintf("Deciphered %s into %s (%d bytes)."+LineBreak, o.decipher, output, size)
+		}
+		err = k.Save()
+		if err != nil {
+			return err
+		}
+		eprintf("Saved message %s in %s."+LineBreak, msg.IdString(), k.GetPersistence())
+	}
+	return nil
+}
+
+// okToWrite returns true if filename does not exist or if it is OK to
+// overwrite it (force is true or the user answered yes).
+func okToWrite(filename string, force bool) (bool, error) {
+	_, err := os.Stat(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+	if force {
+		return true, nil
+	}
+	if !krypto431.IsTerminal() {
+		return false, fmt.Errorf("file %s already exist (will not overwrite)", filename)
+	}
+	return askYesNo(fmt.Sprintf("Overwrite %s?", filename))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/sa6mwa/krypto431"
@@ -53,6 +54,55 @@ type options struct {
 	to             []string
 	from           []string
 	idSlice        []string
+	encipher       string
+	decipher       string
+	estimate       bool
+	scheme         string
+	combiner       string
+	integrityCheck bool
+	tolerant       bool
+	partialKeys    bool
+	setKeepers     []string
+	setExpire      string
+	setComment     string
+	setUsed        bool
+	setCompromised bool
+	compromise     bool
+	reason         string
+	ingestNotice   bool
+	newBook        int
+	book           []string
+	books          bool
+	dice           int
+	mixCrand       bool
+	importText     string
+	rollover       bool
+	reserve        int
+	allowExpired   bool
+	rolloverDays   int
+	stats          bool
+	byKeeper       bool
+	averageLength  int
+	setNet         string
+	members        []string
+	removeNets     []string
+	split          int
+	threshold      int
+	splitPFK       bool
+	combine        []string
+	deriveKeys     bool
+	seed           string
+	printSeed      bool
+	kdf            string
+	kdfTime        int
+	kdfMemory      int
+	kdfThreads     int
+	rekdf          string
+	backups        int
+	restore        int
+	verify         bool
+	replay         bool
+	resetJournal   bool
 }
 
 const (
@@ -97,6 +147,55 @@ const (
 	oTo             string = "to"
 	oFrom           string = "from"
 	oId             string = "id"
+	oEncipher       string = "encipher"
+	oDecipher       string = "decipher"
+	oEstimate       string = "estimate"
+	oScheme         string = "scheme"
+	oCombiner       string = "combiner"
+	oIntegrityCheck string = "integrity-check"
+	oTolerant       string = "tolerant"
+	oPartialKeys    string = "partial-keys"
+	oSetKeepers     string = "set-keepers"
+	oSetExpire      string = "set-expire"
+	oSetComment     string = "set-comment"
+	oSetUsed        string = "set-used"
+	oSetCompromised string = "set-compromised"
+	oCompromise     string = "compromise"
+	oReason         string = "reason"
+	oIngestNotice   string = "ingest-notice"
+	oNewBook        string = "new-book"
+	oBook           string = "book"
+	oBooks          string = "books"
+	oDice           string = "dice"
+	oMixCrand       string = "mix-crand"
+	oImportText     string = "import-text"
+	oRollover       string = "rollover"
+	oReserve        string = "reserve"
+	oAllowExpired   string = "allow-expired"
+	oRolloverDays   string = "rollover-days"
+	oStats          string = "stats"
+	oByKeeper       string = "by-keeper"
+	oAverageLength  string = "average-length"
+	oSetNet         string = "set"
+	oMembers        string = "members"
+	oRemove         string = "remove"
+	oSplit          string = "split"
+	oThreshold      string = "threshold"
+	oSplitPFK       string = "split-pfk"
+	oCombine        string = "combine"
+	oDeriveKeys     string = "derive-keys"
+	oSeed           string = "seed"
+	oPrintSeed      string = "print-seed"
+	oKDF            string = "kdf"
+	oKDFTime        string = "kdf-time"
+	oKDFMemory      string = "kdf-memory"
+	oKDFThreads     string = "kdf-threads"
+	oRekdf          string = "rekdf"
+	oBackups        string = "backups"
+	oRestore        string = "backup"
+	oVerify         string = "verify"
+	oReplay         string = "replay"
+	oResetJournal   string = "reset"
 )
 
 // For simplicity, collect all values and return a populated options object.
@@ -145,6 +244,55 @@ func getOptions(c *cli.Context) options {
 		to:             c.StringSlice(oTo),
 		from:           c.StringSlice(oFrom),
 		idSlice:        c.StringSlice(oId),
+		encipher:       c.String(oEncipher),
+		decipher:       c.String(oDecipher),
+		estimate:       c.Bool(oEstimate),
+		scheme:         c.String(oScheme),
+		combiner:       c.String(oCombiner),
+		integrityCheck: c.Bool(oIntegrityCheck),
+		tolerant:       c.Bool(oTolerant),
+		partialKeys:    c.Bool(oPartialKeys),
+		setKeepers:     c.StringSlice(oSetKeepers),
+		setExpire:      c.String(oSetExpire),
+		setComment:     c.String(oSetComment),
+		setUsed:        c.Bool(oSetUsed),
+		setCompromised: c.Bool(oSetCompromised),
+		compromise:     c.Bool(oCompromise),
+		reason:         c.String(oReason),
+		ingestNotice:   c.Bool(oIngestNotice),
+		newBook:        c.Int(oNewBook),
+		book:           c.StringSlice(oBook),
+		books:          c.Bool(oBooks),
+		dice:           c.Int(oDice),
+		mixCrand:       c.Bool(oMixCrand),
+		importText:     c.String(oImportText),
+		rollover:       c.Bool(oRollover),
+		reserve:        c.Int(oReserve),
+		allowExpired:   c.Bool(oAllowExpired),
+		rolloverDays:   c.Int(oRolloverDays),
+		stats:          c.Bool(oStats),
+		byKeeper:       c.Bool(oByKeeper),
+		averageLength:  c.Int(oAverageLength),
+		setNet:         c.String(oSetNet),
+		members:        c.StringSlice(oMembers),
+		removeNets:     c.StringSlice(oRemove),
+		split:          c.Int(oSplit),
+		threshold:      c.Int(oThreshold),
+		splitPFK:       c.Bool(oSplitPFK),
+		combine:        c.StringSlice(oCombine),
+		deriveKeys:     c.Bool(oDeriveKeys),
+		seed:           c.String(oSeed),
+		printSeed:      c.Bool(oPrintSeed),
+		kdf:            c.String(oKDF),
+		kdfTime:        c.Int(oKDFTime),
+		kdfMemory:      c.Int(oKDFMemory),
+		kdfThreads:     c.Int(oKDFThreads),
+		rekdf:          c.String(oRekdf),
+		backups:        c.Int(oBackups),
+		restore:        c.Int(oRestore),
+		verify:         c.Bool(oVerify),
+		replay:         c.Bool(oReplay),
+		resetJournal:   c.Bool(oResetJournal),
 	}
 }
 
@@ -165,15 +313,36 @@ func setSaltAndPFK(c *cli.Context, k *krypto431.Krypto431) error {
 			return err
 		}
 	}
+	krypto431.WithBackups(o.backups)(k)
+	switch strings.ToLower(o.kdf) {
+	case "argon2id":
+		krypto431.WithArgon2id(o.kdfTime, uint32(o.kdfMemory)*1024, uint8(o.kdfThreads))(k)
+	case "pbkdf2":
+		krypto431.WithPBKDF2(krypto431.DefaultPBKDF2Iteration)(k)
+	default:
+		return fmt.Errorf("%w: %s (choose argon2id or pbkdf2)", krypto431.ErrUnknownKDF, o.kdf)
+	}
 	if c.IsSet(oPFK) {
 		err := k.SetPFKFromString(o.pfk)
 		if err != nil {
 			return err
 		}
 	} else if c.IsSet(oPassword) {
-		err := k.SetPFKFromPassword(o.password)
-		if err != nil {
-			return err
+		// The key is derived with the parameters of the file when loaded.
+		k.SetPassword(o.password)
+	}
+	return nil
+}
+
+// lockForChanges places an exclusive lock on the persistence file (see
+// Krypto431.Lock()) if any of the options in changing is set, call before
+// Load() and release with k.Close(). The lock is held until the command is
+// done so that no other process loads the file in the meantime, e.g two
+// operators must never be handed the same key.
+func lockForChanges(c *cli.Context, k *krypto431.Krypto431, changing ...string) error {
+	for _, op := range changing {
+		if c.IsSet(op) {
+			return k.Lock()
 		}
 	}
 	return nil
@@ -187,6 +356,17 @@ func askYesNo(msg string) (doit bool, err error) {
 	return
 }
 
+// warnKeysRunningLow prints a warning for each keeper set with fewer usable
+// keys than the key reserve of the instance.
+func warnKeysRunningLow(k *krypto431.Krypto431) {
+	reserve, within := k.KeyReservePolicy()
+	low := k.KeysRunningLow(reserve, within)
+	for i := range low {
+		eprintf("Warning: %s has %d keys valid for %d days (%d expiring sooner), reserve is %d, see keys --%s"+LineBreak,
+			low[i].JoinKeepers(","), low[i].Usable, int(within.Hours()/24), low[i].Expiring, reserve, oRollover)
+	}
+}
+
 func eprintf(format string, a ...any) (int, error) {
 	return fmt.Fprintf(os.Stderr, format, a...)
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sa6mwa/krypto431"
+	"github.com/urfave/cli/v2"
+)
+
+// testContext returns a context where the boolean options are set.
+func testContext(t *testing.T, options ...string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	args := make([]string, 0, len(options))
+	for _, op := range options {
+		set.Bool(op, false, "")
+		args = append(args, "--"+op)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestLockForChanges(t *testing.T) {
+	timeout := krypto431.LockTimeout
+	krypto431.LockTimeout = 200 * time.Millisecond
+	defer func() { krypto431.LockTimeout = timeout }()
+	file := filepath.Join(t.TempDir(), "test.krypto431")
+	pfk := krypto431.GeneratePFK()
+	k := krypto431.New(krypto431.WithPersistence(file), krypto431.WithPFKString(pfk), krypto431.WithCallSign("SA6MWA"))
+	if err := k.GenerateKeys(2, nil, "SA4LGZ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	k.Wipe()
+
+	// Two operators running messages --new at the same time.
+	c := testContext(t, oNew)
+	first := krypto431.New(krypto431.WithPersistence(file), krypto431.WithPFKString(pfk))
+	defer first.Close()
+	if err := lockForChanges(c, &first, oNew, oDelete); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Load(); err != nil {
+		t.Fatal(err)
+	}
+	second := krypto431.New(krypto431.WithPersistence(file), krypto431.WithPFKString(pfk))
+	defer second.Close()
+	if err := lockForChanges(c, &second, oNew, oDelete); !errors.Is(err, krypto431.ErrLocked) {
+		t.Fatalf("Expected %v while the first operator holds the lock, got %v", krypto431.ErrLocked, err)
+	}
+	msg, err := first.NewTextMessage("SA4LGZ DE SA6MWA 012345 C = HELLO = K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := krypto431.RuneCopy(&msg.KeyId)
+	if err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+	first.Close()
+
+	if err := lockForChanges(c, &second, oNew, oDelete); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Load(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err = second.NewTextMessage("SA4LGZ DE SA6MWA 012346 C = HELLO AGAIN = K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if krypto431.EqualRunes(&msg.KeyId, &used) {
+		t.Errorf("Both operators were handed key %s", string(used))
+	}
+
+	// Options that do not change the file do not lock it.
+	third := krypto431.New(krypto431.WithPersistence(file), krypto431.WithPFKString(pfk))
+	defer third.Close()
+	if err := lockForChanges(testContext(t, oList), &third, oNew, oDelete); err != nil {
+		t.Errorf("Expected no lock for --%s, got %v", oList, err)
+	}
+}
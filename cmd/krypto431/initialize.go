@@ -9,9 +9,14 @@ import (
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/sa6mwa/krypto431"
+	"github.com/sa6mwa/krypto431/diana"
 	"github.com/urfave/cli/v2"
 )
 
+// defaultCombinerOption is the interactive choice for the coding scheme's
+// default combiner (DIANA or MOD10 for numeric schemes).
+const defaultCombinerOption string = "DEFAULT"
+
 func initialize(c *cli.Context) error {
 	o := getOptions(c)
 
@@ -32,7 +37,7 @@ func initialize(c *cli.Context) error {
 		o.call = strings.ToUpper(strings.TrimSpace(o.call))
 	}
 
-	flags := []string{oYes, oCall, oKeys, oKeepers, oKeyLength, oGroupSize}
+	flags := []string{oYes, oCall, oKeys, oKeepers, oKeyLength, oGroupSize, oScheme, oCombiner, oIntegrityCheck, oPartialKeys, oAllowExpired, oReserve, oRolloverDays, oDeriveKeys, oSeed}
 	goInteractive := true
 	for i := range flags {
 		if c.IsSet(flags[i]) {
@@ -43,12 +48,20 @@ func initialize(c *cli.Context) error {
 	if goInteractive {
 		// Too few flags used, go interactive with go-survey...
 		answers := struct {
-			NumberOfKeys int    `survey:"keys"`
-			Keepers      string `survey:"keepers"`
-			Expire       string `survey:"expire"`
-			KeyLength    int    `survey:"keylength"`
-			GroupSize    int    `survey:"groupsize"`
+			NumberOfKeys   int    `survey:"keys"`
+			Keepers        string `survey:"keepers"`
+			Expire         string `survey:"expire"`
+			KeyLength      int    `survey:"keylength"`
+			GroupSize      int    `survey:"groupsize"`
+			Scheme         string `survey:"scheme"`
+			Combiner       string `survey:"combiner"`
+			IntegrityCheck bool   `survey:"integritycheck"`
+			PartialKeys    bool   `survey:"partialkeys"`
 		}{}
+		var schemeOptions []string
+		for _, scheme := range krypto431.CodingSchemes() {
+			schemeOptions = append(schemeOptions, scheme.String())
+		}
 		questions := []*survey.Question{
 			{
 				Name: "keys",
@@ -129,6 +142,38 @@ func initialize(c *cli.Context) error {
 					return nil
 				},
 			},
+			{
+				Name: "scheme",
+				Prompt: &survey.Select{
+					Message: "Choose coding scheme (character tables):",
+					Help:    "All stations on the circuit must use the same coding scheme",
+					Options: schemeOptions,
+				},
+			},
+			{
+				Name: "combiner",
+				Prompt: &survey.Select{
+					Message: "Choose cipher table (combiner):",
+					Help:    "DIANA is reciprocal, choose another table only to interoperate with existing pads. DEFAULT is DIANA or MOD10 for numeric schemes",
+					Options: append([]string{defaultCombinerOption}, diana.Combiners()...),
+				},
+			},
+			{
+				Name: "integritycheck",
+				Prompt: &survey.Confirm{
+					Message: "Append check groups to enciphered messages?",
+					Help:    "Check groups tell the receiver which groups were miscopied. All stations on the circuit must use the same setting",
+					Default: false,
+				},
+			},
+			{
+				Name: "partialkeys",
+				Prompt: &survey.Confirm{
+					Message: "Consume keys partially (several messages per key)?",
+					Help:    "The start offset in the key is sent as the second group. All stations on the circuit must use the same setting",
+					Default: false,
+				},
+			},
 		}
 		err := survey.Ask(questions, &answers)
 		if err != nil {
@@ -148,20 +193,50 @@ func initialize(c *cli.Context) error {
 		ik = nil
 		o.keyLength = answers.KeyLength
 		o.groupSize = answers.GroupSize
+		o.scheme, _, _ = strings.Cut(answers.Scheme, " ")
+		o.integrityCheck = answers.IntegrityCheck
+		o.partialKeys = answers.PartialKeys
+		if answers.Combiner != defaultCombinerOption {
+			o.combiner = answers.Combiner
+		}
 		o.expire = strings.TrimSpace(answers.Expire)
 	}
 
+	if c.IsSet(oSeed) && o.deriveKeys {
+		return fmt.Errorf("use either --%s or --%s", oDeriveKeys, oSeed)
+	}
+	if o.deriveKeys {
+		seed := krypto431.GenerateMasterSeed()
+		o.seed = string(seed)
+		krypto431.Wipe(&seed)
+	}
+
 	k := krypto431.New(krypto431.WithPersistence(o.persistence),
 		krypto431.WithInteractive(true),
 		krypto431.WithKeyLength(o.keyLength),
 		krypto431.WithGroupSize(o.groupSize),
+		krypto431.WithCodingScheme(o.scheme),
+		krypto431.WithCombiner(o.combiner),
+		krypto431.WithIntegrityCheck(o.integrityCheck),
+		krypto431.WithPartialKeys(o.partialKeys),
+		krypto431.WithAllowExpiredKeys(o.allowExpired),
+		krypto431.WithKeyReserve(o.reserve),
+		krypto431.WithRolloverDays(o.rolloverDays),
 		krypto431.WithKeyColumns(o.keyColumns),
 		krypto431.WithColumns(o.columns),
+		krypto431.WithMasterSeed(o.seed),
 		krypto431.WithCallSign(o.call),
 		krypto431.WithOverwritePersistenceIfExists(o.yes),
 	)
 	defer k.Wipe()
 
+	// Each file gets a random salt (recorded in the file header) unless given.
+	if !c.IsSet(oSalt) {
+		err := k.SetSaltFromString(krypto431.GenerateSalt())
+		if err != nil {
+			return err
+		}
+	}
 	err := setSaltAndPFK(c, &k)
 	if err != nil {
 		return err
@@ -190,5 +265,15 @@ func initialize(c *cli.Context) error {
 		return err
 	}
 	eprintf("Saved %s"+LineBreak, k.GetPersistence())
+	if o.deriveKeys {
+		seed, err := k.MasterSeedString()
+		if err != nil {
+			return err
+		}
+		eprintln("Keys are derived from the following master seed, they are NOT one-time pad")
+		eprintln("keys. Print the seed and keep it as secure as the keys, the pad set can be")
+		eprintln("regenerated from it with init --seed (in the same order and settings):")
+		fmt.Println(seed)
+	}
 	return nil
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sa6mwa/krypto431"
+	"github.com/urfave/cli/v2"
+)
+
+// journal command
+func journal(c *cli.Context) error {
+	if !c.IsSet(oList) && !c.IsSet(oVerify) && !c.IsSet(oReplay) && !c.IsSet(oResetJournal) {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+	if c.IsSet(oReplay) && c.IsSet(oResetJournal) {
+		return fmt.Errorf("can not use both --%s and --%s, choose one", oReplay, oResetJournal)
+	}
+	o := getOptions(c)
+	// Load() replays the journal, it is only enabled to replay it so that
+	// list and verify show the persistence file as saved.
+	k := krypto431.New(krypto431.WithPersistence(o.persistence), krypto431.WithInteractive(true), krypto431.WithJournal(o.replay))
+	defer k.Close()
+	err := setSaltAndPFK(c, &k)
+	if err != nil {
+		return err
+	}
+	err = lockForChanges(c, &k, oReplay, oResetJournal)
+	if err != nil {
+		return err
+	}
+	err = k.Load()
+	if err != nil {
+		return err
+	}
+
+	if c.IsSet(oList) && o.listItems {
+		entries, err := k.ReadJournal()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			eprintf("There is no journal of %s."+LineBreak, k.GetPersistence())
+		} else {
+			fmt.Printf("%-6s %-12s %-10s %-13s %s"+LineBreak, "SEQ", "DTG", "DE", "ACTION", "KEY")
+			for i := range entries {
+				fmt.Print(entries[i].String() + LineBreak)
+			}
+		}
+	}
+
+	if c.IsSet(oVerify) && o.verify {
+		report, err := k.VerifyJournal()
+		if err != nil {
+			return err
+		}
+		eprintf("Journal %s has %d entries (hash chain intact), %s has seen %d of them."+LineBreak, k.JournalFile(), report.Entries, k.GetPersistence(), report.Sequence)
+		if len(report.Conflicts) > 0 {
+			for i := range report.Conflicts {
+				eprintf("Key %s appears unused or not compromised: %s"+LineBreak, string(report.Conflicts[i].KeyId), report.Conflicts[i].String())
+			}
+			return fmt.Errorf("%s is older than its journal (%d keys), mark the keys again with journal --%s", k.GetPersistence(), len(report.Conflicts), oReplay)
+		}
+		eprintf("No key used, compromised or deleted according to the journal appears unused in %s."+LineBreak, k.GetPersistence())
+	}
+
+	if c.IsSet(oReplay) && o.replay {
+		err := k.Save()
+		if err != nil {
+			return err
+		}
+		eprintf("Saved %s with the journal replayed."+LineBreak, k.GetPersistence())
+	}
+
+	if c.IsSet(oResetJournal) && o.resetJournal {
+		if !o.yes {
+			eprintf("Keys used according to the current journal will not be marked used again, check them with --%s first."+LineBreak, oVerify)
+			doit, err := askYesNo(fmt.Sprintf("Start a new journal of %s?", k.GetPersistence()))
+			if err != nil {
+				return err
+			}
+			if !doit {
+				return nil
+			}
+		}
+		err := k.ResetJournal()
+		if err != nil {
+			return err
+		}
+		err = k.Save()
+		if err != nil {
+			return err
+		}
+		eprintf("Started a new journal of %s (the previous journal is %s)."+LineBreak, k.GetPersistence(), k.JournalFile()+".old")
+	}
+	return nil
+}
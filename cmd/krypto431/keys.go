@@ -1,7 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -9,12 +12,13 @@ import (
 	"unicode/utf8"
 
 	"github.com/AlecAivazis/survey/v2"
+	"github.com/sa6mwa/dtg"
 	"github.com/sa6mwa/krypto431"
 	"github.com/urfave/cli/v2"
 )
 
 func keys(c *cli.Context) error {
-	atLeastOneOfThem := []string{oList, oEdit, oNew, oDelete, oImport, oExport, oOutput}
+	atLeastOneOfThem := []string{oList, oBooks, oPrintSeed, oStats, oEdit, oCompromise, oIngestNotice, oNew, oNewBook, oRollover, oDelete, oImport, oImportText, oCombine, oExport, oSplit, oOutput}
 	opCount := 0
 	for _, op := range atLeastOneOfThem {
 		if c.IsSet(op) {
@@ -26,18 +30,49 @@ func keys(c *cli.Context) error {
 		return nil
 	}
 	o := getOptions(c)
+	var shares []krypto431.Share
+	defer func() {
+		for i := range shares {
+			shares[i].Wipe()
+		}
+	}()
+	if c.IsSet(oCombine) {
+		var err error
+		shares, err = readShares(o.combine...)
+		if err != nil {
+			return err
+		}
+		// A lost PFK is recovered without opening the persistence file.
+		if shares[0].Kind == krypto431.ShareKindPFK {
+			pfk, err := krypto431.RecoverPFK(shares)
+			if err != nil {
+				return err
+			}
+			eprintln("Recovered PFK (use with --pfk):")
+			fmt.Println(pfk)
+			return nil
+		}
+	}
 	k := krypto431.New(krypto431.WithPersistence(o.persistence), krypto431.WithInteractive(true))
-	defer k.Wipe()
+	defer k.Close()
 	err := setSaltAndPFK(c, &k)
 	if err != nil {
 		return err
 	}
+	err = lockForChanges(c, &k, oEdit, oCompromise, oIngestNotice, oNew, oNewBook, oRollover, oDelete, oImport, oImportText, oCombine, oExport, oSplit)
+	if err != nil {
+		return err
+	}
 	err = k.Load()
 	if err != nil {
 		return err
 	}
+	if !c.IsSet(oRollover) {
+		warnKeysRunningLow(&k)
+	}
 	vettedKeepers := krypto431.VettedKeepers(o.keepers...)
 	vettedKeys := krypto431.VettedKeys(o.idSlice...)
+	vettedBooks := krypto431.VettedKeys(o.book...)
 	filterFunction := func(key *krypto431.Key) bool {
 		// Next is almost redundant as function selects all keys if no filters av been applied.
 		if o.all {
@@ -51,6 +86,9 @@ func keys(c *cli.Context) error {
 			}
 			return false
 		}
+		if len(vettedBooks) > 0 && !key.InBook(vettedBooks...) {
+			return false
+		}
 		if len(vettedKeepers) > 0 {
 			if o.or {
 				if !krypto431.AnyNeedleInHaystack(&vettedKeepers, &key.Keepers) {
@@ -115,7 +153,7 @@ func keys(c *cli.Context) error {
 			var response []string
 			prompt := &survey.MultiSelect{
 				Message:  "Select key(s) to delete",
-				Help:     "Columns are ID, KEEPERS, CREATED, EXPIRES, USED, COMPROMISED and COMMENT",
+				Help:     "Columns are ID, KEEPERS, CREATED, EXPIRES, USED, COMPROMISED, BOOK and COMMENT",
 				Options:  keyStrings,
 				PageSize: 20,
 			}
@@ -147,13 +185,114 @@ func keys(c *cli.Context) error {
 		}
 	}
 
+	// edit keys
+	if c.IsSet(oEdit) && o.editItems {
+		err := editKeys(c, o, &k, filterFunction)
+		if err != nil {
+			return err
+		}
+	}
+
+	// compromise keys
+	if c.IsSet(oCompromise) && o.compromise {
+		err := compromiseKeys(o, &k, filterFunction)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ingest compromise notice
+	if c.IsSet(oIngestNotice) && o.ingestNotice {
+		radiogram, err := k.PromptRadiogram()
+		if err != nil {
+			return err
+		}
+		compromised, ignored, err := k.IngestCompromiseNotice(radiogram)
+		if err != nil {
+			return err
+		}
+		if len(ignored) > 0 {
+			eprintf("Ignored unknown keys or keys not kept by the sender: %s"+LineBreak, krypto431.JoinRunesToString(&ignored, " "))
+		}
+		if len(compromised) == 0 {
+			eprintln("No keys were marked compromised.")
+		} else {
+			err = k.Save()
+			if err != nil {
+				return err
+			}
+			eprintf("Marked %d keys compromised: %s"+LineBreak, len(compromised), krypto431.JoinRunesToString(&compromised, " "))
+		}
+	}
+
 	// generate new keys
 	if c.IsSet(oNew) && o.newInt > 0 {
 		var expiryDTG *string = nil
 		if c.IsSet(oExpire) {
 			expiryDTG = &o.expire
 		}
-		err := k.GenerateKeys(o.newInt, expiryDTG, o.keepers...)
+		if c.IsSet(oDice) {
+			err := diceKeys(o, &k, expiryDTG)
+			if err != nil {
+				return err
+			}
+		} else {
+			err := k.GenerateKeys(o.newInt, expiryDTG, o.keepers...)
+			if err != nil {
+				return err
+			}
+		}
+		err = k.Save()
+		if err != nil {
+			return err
+		}
+		eprintf("Generated %d keys"+LineBreak, o.newInt)
+	}
+
+	// generate replacement keys for keeper sets running low
+	if c.IsSet(oRollover) && o.rollover {
+		var expiryDTG *string = nil
+		if c.IsSet(oExpire) {
+			expiryDTG = &o.expire
+		}
+		reserve, within := k.KeyReservePolicy()
+		if c.IsSet(oReserve) {
+			reserve = o.reserve
+		}
+		if c.IsSet(oValid) {
+			within = time.Duration(o.valid) * 24 * time.Hour
+		}
+		low, generated, err := k.RolloverKeys(reserve, within, expiryDTG)
+		if err != nil {
+			return err
+		}
+		for i := range low {
+			eprintf("%s had %d keys valid for %d days, generated %d"+LineBreak,
+				low[i].JoinKeepers(","), low[i].Usable, int(within.Hours()/24), reserve-low[i].Usable)
+		}
+		if generated > 0 {
+			err = k.Save()
+			if err != nil {
+				return err
+			}
+		}
+		eprintf("Generated %d replacement keys"+LineBreak, generated)
+	}
+
+	// generate a new key book
+	if c.IsSet(oNewBook) && o.newBook > 0 {
+		var expiryDTG *string = nil
+		if c.IsSet(oExpire) {
+			expiryDTG = &o.expire
+		}
+		if len(o.book) > 1 {
+			return fmt.Errorf("can only generate one key book at a time, got %d --%s", len(o.book), oBook)
+		}
+		bookId := ""
+		if len(o.book) == 1 {
+			bookId = o.book[0]
+		}
+		book, err := k.GenerateBook(bookId, o.newBook, expiryDTG, o.keepers...)
 		if err != nil {
 			return err
 		}
@@ -161,7 +300,7 @@ func keys(c *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		eprintf("Generated %d keys"+LineBreak, o.newInt)
+		eprintf("Generated key book %s with %d sheets"+LineBreak, book.IdString(), book.Sheets)
 	}
 
 	// import keys
@@ -184,6 +323,62 @@ func keys(c *cli.Context) error {
 		eprintf("Imported %d key%s from %s to %s."+LineBreak, numberOfKeysImported, plural, o.importItems, k.GetPersistence())
 	}
 
+	// import keys from a text printout
+	if c.IsSet(oImportText) {
+		if utf8.RuneCountInString(o.importText) == 0 {
+			return ErrMissingImportFilename
+		}
+		var r io.Reader = os.Stdin
+		if o.importText != "-" {
+			f, err := os.Open(o.importText)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		imported, problems, err := k.ImportKeysText(r, o.keepers...)
+		if err != nil {
+			return err
+		}
+		for i := range problems {
+			eprintln(problems[i].Error())
+		}
+		if imported > 0 {
+			err = k.Save()
+			if err != nil {
+				return err
+			}
+		}
+		plural := ""
+		if imported != 1 {
+			plural = "s"
+		}
+		eprintf("Imported %d key%s from %s to %s."+LineBreak, imported, plural, o.importText, k.GetPersistence())
+		if len(problems) > 0 {
+			return fmt.Errorf("found %d problem(s) in %s, keys with problems were not imported", len(problems), o.importText)
+		}
+	}
+
+	// recover keys from shares
+	if c.IsSet(oCombine) {
+		imported, err := k.RecoverKeys(shares)
+		if err != nil {
+			return err
+		}
+		if imported > 0 {
+			err = k.Save()
+			if err != nil {
+				return err
+			}
+		}
+		plural := ""
+		if imported != 1 {
+			plural = "s"
+		}
+		eprintf("Recovered and imported %d key%s from %d shares to %s."+LineBreak, imported, plural, len(shares), k.GetPersistence())
+	}
+
 	// export keys
 	if c.IsSet(oExport) {
 		if utf8.RuneCountInString(o.exportItems) == 0 {
@@ -195,6 +390,11 @@ func keys(c *cli.Context) error {
 		if err != nil {
 			return err
 		}
+		// The keys are recorded as exported in the journal.
+		err = k.SaveJournal()
+		if err != nil {
+			return err
+		}
 		keysExported := len(k2.Keys)
 		plural := ""
 		if keysExported == 0 || keysExported > 1 {
@@ -230,6 +430,69 @@ func keys(c *cli.Context) error {
 		}
 	}
 
+	// list key books
+	if c.IsSet(oStats) && o.stats {
+		_, within := k.KeyReservePolicy()
+		inventory := k.KeyInventory(filterFunction, o.byKeeper, within, o.averageLength)
+		if len(inventory) == 0 {
+			eprintf("No key out of %d in %s matched criteria."+LineBreak, len(k.Keys), k.GetPersistence())
+			return nil
+		}
+		averageLength := o.averageLength
+		if averageLength <= 0 {
+			averageLength = k.AverageMessageLength()
+		}
+		header, lines := krypto431.SummaryOfInventory(inventory)
+		fmt.Printf("FILE=%s"+LineBreak, k.GetPersistence())
+		fmt.Printf("EXPIRING=%dd AVERAGELENGTH=%d PARTIALKEYS=%t"+LineBreak, int(within.Hours()/24), averageLength, k.PartialKeys)
+		fmt.Println(strings.TrimRightFunc(string(header), unicode.IsSpace))
+		for i := range lines {
+			fmt.Println(strings.TrimRightFunc(string(lines[i]), unicode.IsSpace))
+		}
+	}
+
+	if c.IsSet(oBooks) && o.books {
+		if len(k.Books) == 0 {
+			eprintf("There are no key books in %s."+LineBreak, k.GetPersistence())
+			return nil
+		}
+		header, lines := k.SummaryOfBooks(func(book *krypto431.Book) bool {
+			if len(vettedBooks) > 0 && !krypto431.AnyOfThem(&vettedBooks, &book.Id) {
+				return false
+			}
+			if len(vettedKeepers) > 0 {
+				if o.or {
+					return krypto431.AnyNeedleInHaystack(&vettedKeepers, &book.Keepers)
+				}
+				return krypto431.AllNeedlesInHaystack(&vettedKeepers, &book.Keepers)
+			}
+			return true
+		})
+		if len(lines) == 0 {
+			eprintf("No key book out of %d in %s matched criteria."+LineBreak, len(k.Books), k.GetPersistence())
+			return nil
+		}
+		fmt.Println(strings.TrimRightFunc(string(header), unicode.IsSpace))
+		for i := range lines {
+			fmt.Println(strings.TrimRightFunc(string(lines[i]), unicode.IsSpace))
+		}
+	}
+
+	// print master seed
+	if c.IsSet(oPrintSeed) && o.printSeed {
+		seed, err := k.MasterSeedString()
+		if err != nil {
+			return err
+		}
+		eprintf("Keys in %s are derived from master seed (%d derived):"+LineBreak, k.GetPersistence(), k.SeedCounter)
+		fmt.Println(seed)
+	}
+
+	// split keys or PFK into shares, written to -o instead of the keys
+	if c.IsSet(oSplit) {
+		return splitShares(c, o, &k, filterFunction)
+	}
+
 	// output key(s)
 	if c.IsSet(oOutput) {
 		if utf8.RuneCountInString(o.output) == 0 {
@@ -261,3 +524,348 @@ func keys(c *cli.Context) error {
 	}
 	return nil
 }
+
+// editKeys edits keys selected by filterFunction. If none of the --set-*
+// options are used, keys are selected and edited interactively.
+func editKeys(c *cli.Context, o options, k *krypto431.Krypto431, filterFunction func(key *krypto431.Key) bool) error {
+	keys := len(k.Keys)
+	if keys == 0 {
+		eprintf("There are no keys in %s."+LineBreak, k.GetPersistence())
+		return nil
+	}
+	_, lines := k.SummaryOfKeys(filterFunction)
+	if len(lines) == 0 {
+		plural := ""
+		if keys > 1 {
+			plural = "s"
+		}
+		eprintf("No key out of %d key"+plural+" in %s matched criteria."+LineBreak, keys, k.GetPersistence())
+		return nil
+	}
+	var edit krypto431.KeyEdit
+	nonInteractive := false
+	if c.IsSet(oSetKeepers) {
+		edit.Keepers = make([]string, 0, len(o.setKeepers))
+		edit.Keepers = append(edit.Keepers, o.setKeepers...)
+		nonInteractive = true
+	}
+	if c.IsSet(oSetExpire) {
+		edit.Expires = &o.setExpire
+		nonInteractive = true
+	}
+	if c.IsSet(oSetComment) {
+		edit.Comment = &o.setComment
+		nonInteractive = true
+	}
+	if c.IsSet(oSetUsed) {
+		edit.Used = &o.setUsed
+		nonInteractive = true
+	}
+	if c.IsSet(oSetCompromised) {
+		edit.Compromised = &o.setCompromised
+		nonInteractive = true
+	}
+	if nonInteractive {
+		if !o.yes {
+			for i := range lines {
+				eprintln(strings.TrimRightFunc(string(lines[i]), unicode.IsSpace))
+			}
+			doit, err := askYesNo(fmt.Sprintf("Edit %d key(s)?", len(lines)))
+			if err != nil {
+				return err
+			}
+			if !doit {
+				eprintln("No keys were edited.")
+				return nil
+			}
+		}
+		edited, err := k.EditKeys(filterFunction, edit)
+		if err != nil {
+			return err
+		}
+		err = k.Save()
+		if err != nil {
+			return err
+		}
+		eprintf("Edited %d keys."+LineBreak, edited)
+		return nil
+	}
+	// Interactive, select keys and edit them one by one.
+	var keyStrings []string
+	for i := range lines {
+		keyStrings = append(keyStrings, string(lines[i]))
+	}
+	var response []string
+	prompt := &survey.MultiSelect{
+		Message:  "Select key(s) to edit",
+		Help:     "Columns are ID, KEEPERS, CREATED, EXPIRES, USED, COMPROMISED, BOOK and COMMENT",
+		Options:  keyStrings,
+		PageSize: 20,
+	}
+	err := survey.AskOne(prompt, &response, survey.WithKeepFilter(true))
+	if err != nil {
+		return err
+	}
+	edited := 0
+	for i := range response {
+		id, _, _ := strings.Cut(response[i], " ")
+		key, err := k.GetKey([]rune(id))
+		if err != nil {
+			return err
+		}
+		answers := struct {
+			Keepers     string `survey:"keepers"`
+			Expires     string `survey:"expires"`
+			Comment     string `survey:"comment"`
+			Used        bool   `survey:"used"`
+			Compromised bool   `survey:"compromised"`
+		}{}
+		questions := []*survey.Question{
+			{
+				Name: "keepers",
+				Prompt: &survey.Input{
+					Message: fmt.Sprintf("Keepers of key %s:", key.IdString()),
+					Help:    "Comma or space separated call-signs, leave empty for an anonymous key",
+					Default: key.JoinKeepers(","),
+				},
+			},
+			{
+				Name: "expires",
+				Prompt: &survey.Input{
+					Message: "Expires (DTG):",
+					Default: key.Expires.String(),
+				},
+				Validate: func(val interface{}) error {
+					s, ok := val.(string)
+					if !ok {
+						return errors.New("not a string")
+					}
+					_, err := dtg.Parse(strings.TrimSpace(s))
+					return err
+				},
+			},
+			{
+				Name: "comment",
+				Prompt: &survey.Input{
+					Message: "Comment:",
+					Default: key.CommentString(),
+				},
+			},
+			{
+				Name: "used",
+				Prompt: &survey.Confirm{
+					Message: "Used?",
+					Default: key.Used,
+				},
+			},
+			{
+				Name: "compromised",
+				Prompt: &survey.Confirm{
+					Message: "Compromised?",
+					Default: key.Compromised,
+				},
+			},
+		}
+		err = survey.Ask(questions, &answers)
+		if err != nil {
+			return err
+		}
+		err = k.EditKey(key.Id, krypto431.KeyEdit{
+			Keepers:     []string{answers.Keepers},
+			Expires:     &answers.Expires,
+			Comment:     &answers.Comment,
+			Used:        &answers.Used,
+			Compromised: &answers.Compromised,
+		})
+		if err != nil {
+			return err
+		}
+		edited++
+	}
+	if edited == 0 {
+		eprintln("No keys were edited.")
+		return nil
+	}
+	err = k.Save()
+	if err != nil {
+		return err
+	}
+	eprintf("Edited %d keys."+LineBreak, edited)
+	return nil
+}
+
+// compromiseKeys marks keys selected by filterFunction compromised and prints
+// a compromise notice radiogram to send to the other keepers.
+func compromiseKeys(o options, k *krypto431.Krypto431, filterFunction func(key *krypto431.Key) bool) error {
+	keys := len(k.Keys)
+	if keys == 0 {
+		eprintf("There are no keys in %s."+LineBreak, k.GetPersistence())
+		return nil
+	}
+	filter := func(key *krypto431.Key) bool {
+		return !key.Compromised && filterFunction(key)
+	}
+	_, lines := k.SummaryOfKeys(filter)
+	if len(lines) == 0 {
+		plural := ""
+		if keys > 1 {
+			plural = "s"
+		}
+		eprintf("No un-compromised key out of %d key"+plural+" in %s matched criteria."+LineBreak, keys, k.GetPersistence())
+		return nil
+	}
+	if !o.yes {
+		for i := range lines {
+			eprintln(strings.TrimRightFunc(string(lines[i]), unicode.IsSpace))
+		}
+		doit, err := askYesNo(fmt.Sprintf("Mark %d key(s) compromised?", len(lines)))
+		if err != nil {
+			return err
+		}
+		if !doit {
+			eprintln("No keys were marked compromised.")
+			return nil
+		}
+	}
+	compromised := k.CompromiseKeys(filter, o.reason)
+	err := k.Save()
+	if err != nil {
+		return err
+	}
+	eprintf("Marked %d keys compromised, send the following notice to the other keepers:"+LineBreak, len(compromised))
+	notice, err := k.CompromiseNotice(compromised...)
+	if err != nil {
+		return err
+	}
+	fmt.Println(notice)
+	return nil
+}
+
+// splitShares splits keys selected by filterFunction (or the PFK if
+// --split-pfk) into o.split shares and writes them to the -o file (pdf or txt)
+// or stdout.
+func splitShares(c *cli.Context, o options, k *krypto431.Krypto431, filterFunction func(key *krypto431.Key) bool) error {
+	threshold := o.threshold
+	if !c.IsSet(oThreshold) {
+		threshold = o.split/2 + 1
+	}
+	var shares []krypto431.Share
+	var err error
+	if o.splitPFK {
+		shares, err = k.SplitPFK(o.split, threshold)
+	} else {
+		shares, err = k.SplitKeys(filterFunction, o.split, threshold)
+		if err == nil {
+			// The keys are recorded as exported in the journal.
+			err = k.SaveJournal()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for i := range shares {
+			shares[i].Wipe()
+		}
+	}()
+	what := "keys"
+	if o.splitPFK {
+		what = "PFK"
+	}
+	if !c.IsSet(oOutput) {
+		fmt.Print(krypto431.SharesAsText(shares))
+		eprintf("Split %s of %s into %d shares, any %d of them recover the %s."+LineBreak, what, k.GetPersistence(), len(shares), threshold, what)
+		return nil
+	}
+	if utf8.RuneCountInString(o.output) == 0 {
+		return ErrMissingOutputFilename
+	}
+	outputType := o.outputType
+	if !c.IsSet(oType) && (filepath.Ext(o.output) == ".txt" || filepath.Ext(o.output) == ".TXT") {
+		outputType = "txt"
+	}
+	switch outputType {
+	case "pdf", "PDF":
+		err = krypto431.SharesPDF(shares, o.output)
+	case "txt", "text", "TXT":
+		err = krypto431.SharesTextFile(shares, o.output)
+	default:
+		return fmt.Errorf("un-supported output-type \"%s\"", o.outputType)
+	}
+	if err != nil {
+		return err
+	}
+	eprintf("Split %s of %s into %d shares in %s, any %d of them recover the %s."+LineBreak, what, k.GetPersistence(), len(shares), o.output, threshold, what)
+	return nil
+}
+
+// readShares parses shares from files (- for stdin). Returns error if there
+// are no shares.
+func readShares(filenames ...string) ([]krypto431.Share, error) {
+	var shares []krypto431.Share
+	for _, filename := range filenames {
+		var r io.Reader = os.Stdin
+		if filename != "-" {
+			f, err := os.Open(filename)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			r = f
+		}
+		parsed, err := krypto431.ParseShares(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		shares = append(shares, parsed...)
+	}
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("%w: found no shares in %s", krypto431.ErrNotEnoughShares, strings.Join(filenames, ", "))
+	}
+	return shares, nil
+}
+
+// diceKeys generates o.newInt keys from dice rolls, prompting for rolls if
+// stdin is a terminal or reading them from stdin if not.
+func diceKeys(o options, k *krypto431.Krypto431, expire *string) error {
+	expiryTime := time.Now().Add(365 * 24 * time.Hour)
+	if expire != nil {
+		d, err := dtg.Parse(*expire)
+		if err != nil {
+			return err
+		}
+		expiryTime = d.Time
+	}
+	var dice *krypto431.DiceSource
+	var err error
+	if krypto431.IsTerminal() {
+		dice, err = krypto431.NewDicePrompt(o.dice, func(d *krypto431.DiceSource) (string, error) {
+			var rolls string
+			prompt := &survey.Input{
+				Message: fmt.Sprintf("d%d rolls (%d so far):", d.Sides, d.Rolls()),
+				Help:    "Enter one or more rolls separated by space, d6 and d10 rolls can be entered without space",
+			}
+			err := survey.AskOne(prompt, &rolls)
+			return rolls, err
+		})
+	} else {
+		dice, err = krypto431.NewDiceSource(o.dice, os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	base := 26
+	if scheme, err := krypto431.GetCodingScheme(k.CodingScheme); err == nil && scheme.IsNumeric() {
+		base = 10
+	}
+	for i := 0; i < o.newInt; i++ {
+		eprintf("Key %d of %d needs at least %d rolls of a d%d."+LineBreak, i+1, o.newInt, k.KeyLength*dice.RollsPerNumber(base), o.dice)
+		key, err := k.NewDiceKey(expiryTime, dice, o.mixCrand, o.keepers...)
+		if err != nil {
+			return err
+		}
+		eprintf("Generated key %s from dice."+LineBreak, key.IdString())
+	}
+	eprintf("Used %d rolls, %d numbers were discarded."+LineBreak, dice.Rolls(), dice.Rejected())
+	return nil
+}
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/sa6mwa/krypto431"
+	"github.com/sa6mwa/krypto431/diana"
 	"github.com/urfave/cli/v2"
 )
 
@@ -15,6 +17,7 @@ var (
 	ErrMissingImportFilename error = errors.New("filename to import keys from is missing")
 	ErrMissingExportFilename error = errors.New("filename to export keys to is missing")
 	ErrMissingOutputFilename error = errors.New("missing or empty output filename")
+	ErrMissingInputFilename  error = errors.New("missing or empty input filename")
 )
 
 func fatalf(format string, a ...any) {
@@ -72,7 +75,7 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 				Aliases: []string{"S"},
 				EnvVars: []string{"KRYPTO_SALT"},
 				Value:   krypto431.DefaultSalt,
-				Usage:   "Custom hex-encoded `salt`",
+				Usage:   "Custom hex-encoded `salt` (new files get a random salt, only needed for files saved before the salt was recorded in the file header)",
 			},
 			&cli.StringFlag{
 				Name:    oPFK,
@@ -86,6 +89,36 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 				EnvVars: []string{"KRYPTO_PASSWORD"},
 				Usage:   "Insecurely supply clear-text `pass`word to derive persistence key (avoid)",
 			},
+			&cli.IntFlag{
+				Name:    oBackups,
+				EnvVars: []string{"KRYPTO_BACKUPS"},
+				Value:   krypto431.DefaultBackups,
+				Usage:   "Keep `number` of rotating encrypted backups of the persistence file when saving (see the restore command), 0 to disable",
+			},
+			&cli.StringFlag{
+				Name:    oKDF,
+				EnvVars: []string{"KRYPTO_KDF"},
+				Value:   "argon2id",
+				Usage:   "Derive new persistence keys from passwords with `kdf` argon2id or pbkdf2 (files are opened with the KDF they were saved with)",
+			},
+			&cli.IntFlag{
+				Name:    oKDFTime,
+				EnvVars: []string{"KRYPTO_KDF_TIME"},
+				Value:   krypto431.DefaultArgon2idTime,
+				Usage:   "Argon2id time parameter (`passes`) for new persistence keys",
+			},
+			&cli.IntFlag{
+				Name:    oKDFMemory,
+				EnvVars: []string{"KRYPTO_KDF_MEMORY"},
+				Value:   int(krypto431.DefaultArgon2idMemory / 1024),
+				Usage:   "Argon2id memory in `MiB` for new persistence keys",
+			},
+			&cli.IntFlag{
+				Name:    oKDFThreads,
+				EnvVars: []string{"KRYPTO_KDF_THREADS"},
+				Value:   int(krypto431.DefaultArgon2idThreads),
+				Usage:   "Argon2id `threads` for new persistence keys",
+			},
 			&cli.Float64Flag{
 				Name:    oMinimumEntropy,
 				Aliases: []string{"M"},
@@ -144,6 +177,47 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 						Value:   krypto431.DefaultGroupSize,
 						Usage:   "Number of characters per group",
 					},
+					&cli.StringFlag{
+						Name:    oScheme,
+						Aliases: []string{"s"},
+						Value:   krypto431.DefaultCodingSchemeId,
+						Usage:   fmt.Sprintf("Coding scheme (character tables) of new keys, one of %s", strings.Join(krypto431.CodingSchemeIds(), ", ")),
+					},
+					&cli.StringFlag{
+						Name:    oCombiner,
+						Aliases: []string{"t"},
+						Usage:   fmt.Sprintf("Cipher table of new keys, one of %s (default DIANA or MOD10 for numeric schemes)", strings.Join(diana.Combiners(), ", ")),
+					},
+					&cli.BoolFlag{
+						Name:    oIntegrityCheck,
+						Aliases: []string{"i"},
+						Usage:   "Append check groups to enciphered messages and verify them when deciphering",
+					},
+					&cli.BoolFlag{
+						Name:    oPartialKeys,
+						Aliases: []string{"p"},
+						Usage:   "Consume keys partially (start offset is sent in an indicator group) instead of one key per message",
+					},
+					&cli.BoolFlag{
+						Name:  oAllowExpired,
+						Usage: "Allow enciphering with expired keys (expired keys are skipped by default)",
+					},
+					&cli.IntFlag{
+						Name:  oReserve,
+						Usage: fmt.Sprintf("Warn when a set of keepers has fewer than `n` valid keys, negative disables (default %d)", krypto431.DefaultKeyReserve),
+					},
+					&cli.IntFlag{
+						Name:  oRolloverDays,
+						Usage: fmt.Sprintf("Keys must be valid for `days` to count towards the reserve (default %d)", krypto431.DefaultRolloverDays),
+					},
+					&cli.BoolFlag{
+						Name:  oDeriveKeys,
+						Usage: "Derive keys from a new master seed (printed) so the pad set can be regenerated, derived keys are NOT one-time pad keys",
+					},
+					&cli.StringFlag{
+						Name:  oSeed,
+						Usage: "Derive keys from master `seed` (letters A-Z), e.g to regenerate a pad set, derived keys are NOT one-time pad keys",
+					},
 					&cli.IntFlag{
 						Name:  oKeyColumns,
 						Usage: "Width of key in print-out",
@@ -188,10 +262,14 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 						Aliases: []string{"c", "p"},
 						Usage:   "Change key of persistence `file`",
 					},
+					&cli.StringFlag{
+						Name:  oRekdf,
+						Usage: "Derive the key of persistence `file` again from its password with the --kdf* global options and a new random salt",
+					},
 					&cli.StringFlag{
 						Name:    oNewSalt,
 						Aliases: []string{"s"},
-						Usage:   "Provide salt for new password (hex-encoded, not persisted), use \"default\" to reset",
+						Usage:   "Provide salt for new password (hex-encoded, recorded in the file header, random if omitted), use \"default\" to reset",
 					},
 					&cli.StringFlag{
 						Name:    oNewPFK,
@@ -230,14 +308,44 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 						Value:   false,
 						Usage:   "List keys",
 					},
-					/*
-						&cli.BoolFlag{
-							Name:    oEdit,
-							Aliases: []string{"e"},
-							Value:   false,
-							Usage:   "Edit key(s)",
-						},
-					*/
+					&cli.BoolFlag{
+						Name:    oEdit,
+						Aliases: []string{"e"},
+						Value:   false,
+						Usage:   "Edit key(s), interactively unless one of the --set-* options is used",
+					},
+					&cli.StringSliceFlag{
+						Name:  oSetKeepers,
+						Usage: "Replace keepers of edited keys with `QRZ` (use \"\" for anonymous keys)",
+					},
+					&cli.StringFlag{
+						Name:  oSetExpire,
+						Usage: "Set expiry date of edited keys as `DTG` (Date-Time Group)",
+					},
+					&cli.StringFlag{
+						Name:  oSetComment,
+						Usage: "Set `comment` of edited keys",
+					},
+					&cli.BoolFlag{
+						Name:  oSetUsed,
+						Usage: "Mark edited keys used (--set-used=false to un-mark)",
+					},
+					&cli.BoolFlag{
+						Name:  oSetCompromised,
+						Usage: "Mark edited keys compromised (--set-compromised=false to un-mark)",
+					},
+					&cli.BoolFlag{
+						Name:  oCompromise,
+						Usage: "Mark selected keys compromised and print a compromise notice radiogram for the other keepers",
+					},
+					&cli.StringFlag{
+						Name:  oReason,
+						Usage: "Record `reason` in the comment of keys marked compromised with --compromise",
+					},
+					&cli.BoolFlag{
+						Name:  oIngestNotice,
+						Usage: "Mark keys compromised from a received compromise notice radiogram (read from stdin unless terminal)",
+					},
 					&cli.IntFlag{
 						Name:    oNew,
 						Aliases: []string{"n"},
@@ -249,6 +357,51 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 						Aliases: []string{"x"},
 						Usage:   "Set expiry date as `DTG` (Date-Time Group) on new keys",
 					},
+					&cli.IntFlag{
+						Name:  oDice,
+						Usage: "Generate new keys from rolls of a d6, d10 or d20 (`sides`) entered interactively or on stdin",
+					},
+					&cli.BoolFlag{
+						Name:  oMixCrand,
+						Usage: "Mix dice rolls with the computer's random number generator (with --dice)",
+					},
+					&cli.BoolFlag{
+						Name:  oRollover,
+						Usage: "Generate replacement keys for keepers with fewer than --reserve keys valid for --valid days (defaults from init)",
+					},
+					&cli.IntFlag{
+						Name:  oReserve,
+						Usage: "Number of keys (`n`) each set of keepers should have (with --rollover)",
+					},
+					&cli.IntFlag{
+						Name:  oNewBook,
+						Usage: "Generate a key book of `n` numbered sheets (keys), book id from --book or random",
+					},
+					&cli.StringSliceFlag{
+						Name:    oBook,
+						Aliases: []string{"b"},
+						Usage:   "Key book `ID` (new book/filter)",
+					},
+					&cli.BoolFlag{
+						Name:  oBooks,
+						Usage: "List key books",
+					},
+					&cli.BoolFlag{
+						Name:  oPrintSeed,
+						Usage: "Print the master seed keys are derived from (see init --derive-keys)",
+					},
+					&cli.BoolFlag{
+						Name:  oStats,
+						Usage: "Show key inventory (unused, used, expired, compromised, expiring keys and estimated messages left) per set of keepers",
+					},
+					&cli.BoolFlag{
+						Name:  oByKeeper,
+						Usage: "Show key inventory per keeper instead of per set of keepers (with --stats)",
+					},
+					&cli.IntFlag{
+						Name:  oAverageLength,
+						Usage: "Estimate messages left with an average message length of `n` characters (with --stats, default from messages)",
+					},
 					&cli.BoolFlag{
 						Name:    oDelete,
 						Aliases: []string{"d"},
@@ -260,11 +413,31 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 						Aliases: []string{"I"},
 						Usage:   "Import keys from `file`",
 					},
+					&cli.StringFlag{
+						Name:  oImportText,
+						Usage: "Import keys from a text printout or typed/OCR'd paper pad in `file` (- for stdin), --keepers are added as keepers",
+					},
 					&cli.StringFlag{
 						Name:    oExport,
 						Aliases: []string{"E"},
 						Usage:   "Export keys from main persistence to new `file`",
 					},
+					&cli.IntFlag{
+						Name:  oSplit,
+						Usage: "Split selected keys into `n` printable shares (to -o file or stdout), any --threshold of them recover the keys",
+					},
+					&cli.IntFlag{
+						Name:  oThreshold,
+						Usage: "Number of shares (`k`) needed to recover split keys or PFK (with --split, default is a majority)",
+					},
+					&cli.BoolFlag{
+						Name:  oSplitPFK,
+						Usage: "Split the PFK of the persistence file instead of keys (with --split)",
+					},
+					&cli.StringSliceFlag{
+						Name:  oCombine,
+						Usage: "Recover keys (imported) or PFK (printed) by combining shares in `file`s (- for stdin)",
+					},
 					&cli.StringFlag{
 						Name:    oOutput,
 						Aliases: []string{"o"},
@@ -355,6 +528,17 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 						Usage:   "Write or receive a message",
 						Value:   false,
 					},
+					&cli.BoolFlag{
+						Name:    oEstimate,
+						Aliases: []string{"e"},
+						Usage:   "Estimate groups and keys a new message would consume (dry-run)",
+						Value:   false,
+					},
+					&cli.BoolFlag{
+						Name:  oTolerant,
+						Usage: "Decipher received messages past garbled groups, mark them in the text and list groups to ask a repeat of",
+						Value: false,
+					},
 					&cli.BoolFlag{
 						Name:    oDelete,
 						Aliases: []string{"d"},
@@ -404,11 +588,138 @@ KRYPTO431 is dedicated to the memory of Maximilian Kolbe (SP3RN).
 					},
 				},
 			},
+			{
+				Name:   "nets",
+				Usage:  "List, add or remove nets (named groups of stations sharing keys)",
+				Action: nets,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    oList,
+						Aliases: []string{"l"},
+						Usage:   "List nets",
+					},
+					&cli.StringFlag{
+						Name:    oSetNet,
+						Aliases: []string{"s"},
+						Usage:   "Add net `NAME` or replace its members with --members",
+					},
+					&cli.StringSliceFlag{
+						Name:    oMembers,
+						Aliases: []string{"m"},
+						Usage:   "Members (`QRZ`) of the net, including yourself",
+					},
+					&cli.StringSliceFlag{
+						Name:    oRemove,
+						Aliases: []string{"r"},
+						Usage:   "Remove net `NAME` (keys are left as is)",
+					},
+					&cli.BoolFlag{
+						Name:    oYes,
+						Aliases: []string{"y"},
+						Usage:   "Force option, answer yes on all questions",
+						Value:   false,
+					},
+				},
+			},
+			{
+				Name:   "restore",
+				Usage:  "List backups of the persistence file or restore one",
+				Action: restore,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    oList,
+						Aliases: []string{"l"},
+						Usage:   "List backups",
+					},
+					&cli.IntFlag{
+						Name:    oRestore,
+						Aliases: []string{"n"},
+						Usage:   "Restore backup `number` (the current file becomes backup 1)",
+					},
+					&cli.BoolFlag{
+						Name:    oYes,
+						Aliases: []string{"y"},
+						Usage:   "Force option, answer yes on all questions",
+						Value:   false,
+					},
+				},
+			},
+			{
+				Name:   "journal",
+				Usage:  "List, verify, replay or reset the journal of key state changes of the persistence file",
+				Action: journal,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    oList,
+						Aliases: []string{"l"},
+						Usage:   "List journal entries",
+					},
+					&cli.BoolFlag{
+						Name:    oVerify,
+						Aliases: []string{"v"},
+						Usage:   "Verify the journal and check that no key used, compromised or deleted according to it appears unused in the persistence file",
+					},
+					&cli.BoolFlag{
+						Name:    oReplay,
+						Aliases: []string{"r"},
+						Usage:   "Mark keys used or compromised according to the journal again and save the persistence file",
+					},
+					&cli.BoolFlag{
+						Name:  oResetJournal,
+						Usage: "Start a new journal (the current is kept with suffix .old) when the persistence file can not be used with it, e.g a backup older than the journal",
+					},
+					&cli.BoolFlag{
+						Name:    oYes,
+						Aliases: []string{"y"},
+						Usage:   "Force option, answer yes on all questions",
+						Value:   false,
+					},
+				},
+			},
+			{
+				Name:    "files",
+				Aliases: []string{"file"},
+				Usage:   "Encipher or decipher binary file(s)",
+				Action:  files,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:      oEncipher,
+						Aliases:   []string{"e"},
+						Usage:     "Encipher `file` into a radiogram",
+						TakesFile: true,
+					},
+					&cli.StringFlag{
+						Name:      oDecipher,
+						Aliases:   []string{"d"},
+						Usage:     "Decipher radiogram `file` and write the original file",
+						TakesFile: true,
+					},
+					&cli.StringSliceFlag{
+						Name:    oTo,
+						Aliases: []string{"t"},
+						Usage:   "Recipients (addressees, `TO`) of the enciphered file",
+					},
+					&cli.StringFlag{
+						Name:    oOutput,
+						Aliases: []string{"o"},
+						Usage:   "Write radiogram or deciphered file to `filename` (default file.txt or original name)",
+					},
+					&cli.BoolFlag{
+						Name:    oYes,
+						Aliases: []string{"y"},
+						Usage:   "Force option, overwrite existing files without asking",
+						Value:   false,
+					},
+				},
+			},
 		},
 	}
 
 	err := app.Run(os.Args)
 	if err != nil {
+		if errors.Is(err, krypto431.ErrJournalKey) || errors.Is(err, krypto431.ErrJournalBroken) || errors.Is(err, krypto431.ErrJournalTruncated) {
+			eprintf("The persistence file can not be used with its journal, restore a newer backup (restore --%s) or check the journal with journal --%s and start a new one with journal --%s."+LineBreak, oList, oVerify, oResetJournal)
+		}
 		fatalf("Error: %v", err)
 	}
 }
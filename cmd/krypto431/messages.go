@@ -13,7 +13,7 @@ import (
 )
 
 func messages(c *cli.Context) error {
-	atLeastOneOfThem := []string{oList, oNew, oDelete, oOutput}
+	atLeastOneOfThem := []string{oList, oNew, oEstimate, oDelete, oOutput}
 	opCount := 0
 	for _, op := range atLeastOneOfThem {
 		if c.IsSet(op) {
@@ -25,16 +25,21 @@ func messages(c *cli.Context) error {
 		return nil
 	}
 	o := getOptions(c)
-	k := krypto431.New(krypto431.WithPersistence(o.persistence), krypto431.WithInteractive(true))
-	defer k.Wipe()
+	k := krypto431.New(krypto431.WithPersistence(o.persistence), krypto431.WithInteractive(true), krypto431.WithTolerantDecipher(o.tolerant))
+	defer k.Close()
 	err := setSaltAndPFK(c, &k)
 	if err != nil {
 		return err
 	}
+	err = lockForChanges(c, &k, oNew, oDelete)
+	if err != nil {
+		return err
+	}
 	err = k.Load()
 	if err != nil {
 		return err
 	}
+	warnKeysRunningLow(&k)
 	vettedAddressees := krypto431.VettedCallSigns(o.to...)
 	vettedSenders := krypto431.VettedCallSigns(o.from...)
 	vettedMessageIds := krypto431.VettedMessageIds(o.idSlice...)
@@ -132,14 +137,47 @@ func messages(c *cli.Context) error {
 		if err != nil {
 			return err
 		}
-		fmt.Println(msg.String())
+		// Keys are saved as used before the cipher text is shown.
 		err = k.Save()
 		if err != nil {
 			return err
 		}
+		fmt.Println(msg.String())
+		if !msg.IsMyCall() && msg.IsCompromiseNotice() {
+			eprintf("Message %s is a compromise notice, use keys --%s to mark the keys compromised."+LineBreak, msg.IdString(), oIngestNotice)
+		}
 		eprintf("Saved message %s in %s."+LineBreak, msg.IdString(), k.GetPersistence())
 	}
 
+	// estimate key consumption of a new message
+	if c.IsSet(oEstimate) && o.estimate {
+		radiogram, err := k.PromptRadiogram()
+		if err != nil {
+			return err
+		}
+		msg, err := k.ParseRadiogram(radiogram)
+		if err != nil {
+			return err
+		}
+		defer msg.Wipe()
+		if !msg.IsMyCall() {
+			return fmt.Errorf("can only estimate outgoing messages (DE %s)", k.CallSignString())
+		}
+		estimate, err := msg.Estimate()
+		if err != nil {
+			return err
+		}
+		recipients := string(krypto431.NilRunes)
+		if len(msg.Recipients) > 0 {
+			recipients = msg.JoinRecipients(",")
+		}
+		fmt.Printf("FILE=%s"+LineBreak+"TO=%s %s"+LineBreak, k.GetPersistence(), recipients, estimate)
+		if !estimate.Sufficient {
+			return fmt.Errorf("message needs %d keys, but there are only %d unused keys for %s", estimate.Keys, estimate.AvailableKeys, recipients)
+		}
+		eprintf("OK: %d of %d unused keys for %s would be used."+LineBreak, estimate.Keys, estimate.AvailableKeys, recipients)
+	}
+
 	// list messages
 	if c.IsSet(oList) && o.listItems {
 		// First, ensure there are messages in this instance.
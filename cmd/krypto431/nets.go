@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/sa6mwa/krypto431"
+	"github.com/urfave/cli/v2"
+)
+
+func nets(c *cli.Context) error {
+	if !c.IsSet(oList) && !c.IsSet(oSetNet) && !c.IsSet(oRemove) {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+	o := getOptions(c)
+	k := krypto431.New(krypto431.WithPersistence(o.persistence), krypto431.WithInteractive(true))
+	defer k.Close()
+	err := setSaltAndPFK(c, &k)
+	if err != nil {
+		return err
+	}
+	err = lockForChanges(c, &k, oSetNet, oRemove)
+	if err != nil {
+		return err
+	}
+	err = k.Load()
+	if err != nil {
+		return err
+	}
+
+	// add or replace a net
+	if c.IsSet(oSetNet) {
+		if _, err := k.GetNet([]rune(o.setNet)); err == nil && !o.yes {
+			doit, err := askYesNo(fmt.Sprintf("Replace members of net %s?", strings.ToUpper(o.setNet)))
+			if err != nil {
+				return err
+			}
+			if !doit {
+				return nil
+			}
+		}
+		net, err := k.SetNet(o.setNet, o.members...)
+		if err != nil {
+			return err
+		}
+		if !net.ContainsMember(k.CallSign) {
+			eprintf("Warning: you (%s) are not a member of net %s."+LineBreak, k.CallSignString(), net.NameString())
+		}
+		err = k.Save()
+		if err != nil {
+			return err
+		}
+		eprintf("Net %s: %s"+LineBreak, net.NameString(), net.JoinMembers(" "))
+	}
+
+	// remove nets
+	if c.IsSet(oRemove) {
+		deleted := k.DeleteNet(krypto431.VettedCallSigns(o.removeNets...)...)
+		if deleted > 0 {
+			err = k.Save()
+			if err != nil {
+				return err
+			}
+		}
+		eprintf("Removed %d nets."+LineBreak, deleted)
+	}
+
+	// list nets
+	if c.IsSet(oList) {
+		if len(k.Nets) == 0 {
+			eprintf("There are no nets in %s."+LineBreak, k.GetPersistence())
+			return nil
+		}
+		header, lines := k.SummaryOfNets()
+		fmt.Println(strings.TrimRightFunc(string(header), unicode.IsSpace))
+		for i := range lines {
+			fmt.Println(strings.TrimRightFunc(string(lines[i]), unicode.IsSpace))
+		}
+	}
+	return nil
+}
@@ -23,25 +23,29 @@ func managePFK(c *cli.Context) error {
 	} else if c.IsSet(oGenerateSalt) && o.generateSalt {
 		generateSalt()
 		return nil
+	} else if c.IsSet(oRekdf) {
+		return rekdf(c)
 	} else if c.IsSet(oChange) {
 		// Change PFK of file o.change
 		k := krypto431.New(krypto431.WithPersistence(o.change), krypto431.WithInteractive(true))
-		defer k.Wipe()
+		defer k.Close()
 		err := setSaltAndPFK(c, &k)
 		if err != nil {
 			return err
 		}
+		err = k.Lock()
+		if err != nil {
+			return err
+		}
 		if c.IsSet(oOld) {
-			err := k.SetPFKFromPassword(o.old)
-			if err != nil {
-				return err
-			}
+			k.SetPassword(o.old)
 		}
 		err = k.Load()
 		if err != nil {
 			return err
 		}
 
+		// A new password is derived with a new random salt unless one is given.
 		if c.IsSet(oNewSalt) {
 			if o.newsalt == "default" {
 				o.newsalt = krypto431.DefaultSalt
@@ -50,6 +54,11 @@ func managePFK(c *cli.Context) error {
 			if err != nil {
 				return err
 			}
+		} else {
+			err := k.SetSaltFromString(krypto431.GenerateSalt())
+			if err != nil {
+				return err
+			}
 		}
 
 		if c.IsSet(oRandom) && o.random && c.IsSet(oNew) {
@@ -135,6 +144,62 @@ func managePFK(c *cli.Context) error {
 	return nil
 }
 
+// rekdf() derives the PFK of persistence file o.rekdf again from the same
+// password with the KDF chosen by the global --kdf* options and a new random
+// salt, for example to upgrade a file from PBKDF2 to Argon2id.
+func rekdf(c *cli.Context) error {
+	o := getOptions(c)
+	k := krypto431.New(krypto431.WithPersistence(o.rekdf), krypto431.WithInteractive(true))
+	defer k.Close()
+	err := setSaltAndPFK(c, &k)
+	if err != nil {
+		return err
+	}
+	err = k.Lock()
+	if err != nil {
+		return err
+	}
+	if c.IsSet(oPFK) {
+		return fmt.Errorf("--%s requires a password, not a persistence file key (--%s)", oRekdf, oPFK)
+	}
+	// The password is needed after Load() to derive the new key.
+	var pwd *[]byte
+	if c.IsSet(oOld) {
+		pwd = krypto431.BytePtr([]byte(o.old))
+	} else {
+		pwd = krypto431.AskForPassword(krypto431.DecryptionPrompt, 0)
+		if pwd == nil {
+			return krypto431.ErrPasswordInput
+		}
+	}
+	defer krypto431.WipeBytes(pwd)
+	k.SetPasswordBytes(krypto431.BytePtr(krypto431.ByteCopy(pwd)))
+	err = k.Load()
+	if err != nil {
+		return err
+	}
+	old := k.PFKString()
+	if !o.yes {
+		doit, err := askYesNo(fmt.Sprintf("Derive encryption key for %s again with %s (currently %s)?", o.rekdf, k.KDFString(), old))
+		if err != nil {
+			return err
+		}
+		if !doit {
+			return nil
+		}
+	}
+	err = k.RederivePFK(pwd)
+	if err != nil {
+		return err
+	}
+	err = k.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Encryption key for %s derived with %s (was %s)"+LineBreak, o.rekdf, k.PFKString(), old)
+	return nil
+}
+
 // generatePFK() produces a random persistence file key (PFK) for use as perhaps
 // the KRYPTO_PFK environment variable.
 func generatePFK(c *cli.Context) error {
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sa6mwa/dtg"
+	"github.com/sa6mwa/krypto431"
+	"github.com/urfave/cli/v2"
+)
+
+// restore command
+func restore(c *cli.Context) error {
+	if !c.IsSet(oList) && !c.IsSet(oRestore) {
+		cli.ShowSubcommandHelp(c)
+		return nil
+	}
+	o := getOptions(c)
+	k := krypto431.New(krypto431.WithPersistence(o.persistence), krypto431.WithInteractive(true))
+	defer k.Close()
+	err := setSaltAndPFK(c, &k)
+	if err != nil {
+		return err
+	}
+	err = lockForChanges(c, &k, oRestore)
+	if err != nil {
+		return err
+	}
+
+	if c.IsSet(oList) {
+		backups, err := k.Backups()
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			eprintf("There are no backups of %s."+LineBreak, k.GetPersistence())
+			return nil
+		}
+		fmt.Printf("%-3s %-13s %10s %s"+LineBreak, "NO", "SAVED", "SIZE", "FILE")
+		for _, b := range backups {
+			fmt.Printf("%-3d %-13s %10d %s"+LineBreak, b.Number, dtg.DTG{Time: b.Modified}.String(), b.Size, b.File)
+		}
+	}
+
+	if c.IsSet(oRestore) {
+		backups, err := k.Backups()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, backup := range backups {
+			found = found || backup.Number == o.restore
+		}
+		if !found {
+			return fmt.Errorf("%w: number %d of %s", krypto431.ErrBackupNotFound, o.restore, k.GetPersistence())
+		}
+		// Open the backup first, it may have been saved with another password.
+		b := krypto431.New(krypto431.WithPersistence(k.BackupFile(o.restore)), krypto431.WithInteractive(true), krypto431.WithJournal(false))
+		defer b.Wipe()
+		err = setSaltAndPFK(c, &b)
+		if err != nil {
+			return err
+		}
+		err = b.Load()
+		if err != nil {
+			return err
+		}
+		eprintf("Backup %d (%s) has %d keys and %d messages."+LineBreak, o.restore, b.CallSignString(), len(b.Keys), len(b.Messages))
+		if !o.yes {
+			doit, err := askYesNo(fmt.Sprintf("Replace %s with backup %d?", k.GetPersistence(), o.restore))
+			if err != nil {
+				return err
+			}
+			if !doit {
+				return nil
+			}
+		}
+		err = k.RestoreBackup(o.restore)
+		if err != nil {
+			return err
+		}
+		eprintf("Restored backup %d to %s (the replaced file is backup 1)."+LineBreak, o.restore, k.GetPersistence())
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package krypto431
+
+// Encoding and decoding of plain text (and binary) into and from the
+// character tables, independent of keys and the DIANA trigraph. Encipher() and
+// Decipher() use these functions, but they can also be used to unit-test the
+// coding, pre-compute how many keys a message will need or to produce
+// Krypto431-encoded text in third-party tools.
+
+// EncodeOptions are the options for Encode(). The zero value encodes
+// everything into a single segment using DefaultGroupSize.
+type EncodeOptions struct {
+	// Binary is encoded in binary mode after the plain text (the Binary field of
+	// a Message).
+	Binary []byte
+	// GroupSize is the length of a key id. The total length of the encoded text
+	// is padded to a multiple of GroupSize. Zero means DefaultGroupSize.
+	GroupSize int
+	// KeyId is the id of the key the first segment is to be enciphered with
+	// (optional, only copied to the first Segment).
+	KeyId []rune
+	// KeyLength is the length of the first key. Zero means no limit, everything
+	// is encoded into a single segment.
+	KeyLength int
+	// NextKey is called when the current segment is full and must return the id
+	// and length of the key to change to. If NextKey is nil, a placeholder key id
+	// (GroupSize number of A) is used and all keys are assumed to be KeyLength
+	// long, useful to calculate how many keys a message needs.
+	NextKey func() (id []rune, length int, err error)
+}
+
+// KeyChange describes a key change in an encoded text. Position is the index
+// of the first encoded rune to be enciphered/deciphered with KeyId.
+type KeyChange struct {
+	Position int
+	KeyId    []rune
+}
+
+// Encode codes plain text (and optional binary in opts) into one or more
+// segments using the default coding scheme, see CodingScheme.Encode().
+func Encode(plainText []rune, opts EncodeOptions) ([]Segment, error) {
+	return DefaultCodingScheme().Encode(plainText, opts)
+}
+
+// Encode codes plain text (and optional binary in opts) into one or more
+// segments where each segment is to be enciphered with one key. All but the
+// last segment end with a key change to the next segment's key. The total
+// length of all encoded texts is a multiple of the group size. Don't forget to
+// wipe the segments when done (see WipeSegments()).
+func (s *CodingScheme) Encode(plainText []rune, opts EncodeOptions) ([]Segment, error) {
+	groupSize := opts.GroupSize
+	if groupSize == 0 {
+		groupSize = DefaultGroupSize
+	}
+	if groupSize < 1 {
+		return nil, ErrInvalidGroupSize
+	}
+	keyLength := opts.KeyLength
+	state := newState(s)
+	segments := make([]Segment, 0, DefaultChunkCapacity)
+	segment := newSegment(RuneCopy(&opts.KeyId))
+	success := false
+	defer func() {
+		if !success {
+			WipeSegments(segments)
+			segment.Wipe()
+		}
+	}()
+	// nextSegmentIfNeeded changes to a new key if the next encoded sequence
+	// (length) does not fit in the current key. Runes not found in the character
+	// tables are encoded as binary sections that are closed before changing key
+	// (closeBinary is true) while the Binary payload continues in the next key.
+	nextSegmentIfNeeded := func(length int, closeBinary bool) error {
+		if keyLength <= 0 || state.charCounter+length <= keyLength-groupSize-s.controlCharactersNeededToChangeKey() {
+			return nil
+		}
+		var nextKeyId []rune
+		var nextKeyLength int
+		if opts.NextKey == nil {
+			nextKeyId = make([]rune, groupSize)
+			for i := range nextKeyId {
+				nextKeyId[i] = 'A'
+			}
+			nextKeyLength = opts.KeyLength
+		} else {
+			var err error
+			nextKeyId, nextKeyLength, err = opts.NextKey()
+			if err != nil {
+				return err
+			}
+			if len(nextKeyId) != groupSize {
+				return ErrNoKey
+			}
+		}
+		if closeBinary {
+			err := state.closeBinary(&segment.EncodedText)
+			if err != nil {
+				return err
+			}
+		}
+		err := state.changeKey(&nextKeyId, &segment.EncodedText)
+		if err != nil {
+			return err
+		}
+		segments = append(segments, segment)
+		segment = newSegment(RuneCopy(&nextKeyId))
+		keyLength = nextKeyLength
+		state.reset()
+		return nil
+	}
+	for i := range plainText {
+		length, err := state.encodedLength(func(s *codecState, output *[]rune) error {
+			return s.encodeCharacter(&plainText[i], output)
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = nextSegmentIfNeeded(length, true)
+		if err != nil {
+			return nil, err
+		}
+		err = state.encodeCharacter(&plainText[i], &segment.EncodedText)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// A binary section in the plain text must be closed, otherwise it will be
+	// taken as the Binary payload when decoded.
+	err := state.closeBinary(&segment.EncodedText)
+	if err != nil {
+		return nil, err
+	}
+	for i := range opts.Binary {
+		length, err := state.encodedLength(func(s *codecState, output *[]rune) error {
+			return s.encodeByte(&opts.Binary[i], output)
+		})
+		if err != nil {
+			return nil, err
+		}
+		err = nextSegmentIfNeeded(length, false)
+		if err != nil {
+			return nil, err
+		}
+		err = state.encodeByte(&opts.Binary[i], &segment.EncodedText)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Count length of all segments and make sure the last segment compensates
+	// for modulo GroupSize length of all encoded texts. Last segment is current
+	// segment...
+	lengthOfAllEncodedTexts := len(segment.EncodedText)
+	for i := range segments {
+		lengthOfAllEncodedTexts += len(segments[i].EncodedText)
+	}
+	err = state.pad((groupSize-(lengthOfAllEncodedTexts%groupSize))%groupSize, &segment.EncodedText)
+	if err != nil {
+		return nil, err
+	}
+	segments = append(segments, segment)
+	success = true
+	return segments, nil
+}
+
+// Decoder decodes an encoded text one rune at a time. It is used when the
+// next key is not known in advance (e.g when deciphering a message enciphered
+// with multiple keys). Use Decode() to decode a complete encoded text.
+type Decoder struct {
+	state      *codecState
+	groupSize  int
+	position   int
+	nextKey    []rune
+	plainText  []rune
+	keyChanges []KeyChange
+}
+
+// NewDecoder returns a new Decoder for the default coding scheme where
+// groupSize is the length of key ids in key changes.
+func NewDecoder(groupSize int) *Decoder {
+	return DefaultCodingScheme().NewDecoder(groupSize)
+}
+
+// NewDecoder returns a new Decoder for the coding scheme where groupSize is the
+// length of key ids in key changes.
+func (s *CodingScheme) NewDecoder(groupSize int) *Decoder {
+	return &Decoder{
+		state:     newState(s),
+		groupSize: groupSize,
+		nextKey:   make([]rune, 0, groupSize),
+		plainText: make([]rune, 0, DefaultPlainTextCapacity),
+	}
+}
+
+// Decode decodes one encoded rune (A-Z or 0-9 for numeric schemes). If the
+// rune completes a key change, the id of the key to decode (decipher) the next
+// rune with is returned, otherwise the returned key id is nil.
+func (d *Decoder) Decode(encoded rune) ([]rune, error) {
+	if d.groupSize < 1 {
+		return nil, ErrInvalidGroupSize
+	}
+	err := d.state.decodeCharacter(&encoded, &d.plainText)
+	if err != nil {
+		return nil, err
+	}
+	d.position++
+	if !d.state.keyChange {
+		return nil, nil
+	}
+	d.nextKey = append(d.nextKey, encoded)
+	if len(d.nextKey) < d.groupSize {
+		return nil, nil
+	}
+	keyId := RuneCopy(&d.nextKey)
+	d.keyChanges = append(d.keyChanges, KeyChange{Position: d.position, KeyId: RuneCopy(&keyId)})
+	Wipe(&d.nextKey)
+	d.state.reset()
+	return keyId, nil
+}
+
+// Skip is called after Decode() returned an error to continue decoding past
+// the failed rune (e.g when deciphering a garbled message). Any incomplete
+// byte or code is discarded, marker is appended to the plain text and the
+// decoder stays in its current table or mode.
+func (d *Decoder) Skip(marker []rune) {
+	d.state.lowerNibble = false
+	d.state.currentByte = 0
+	d.state.clearPending()
+	if !d.state.binary {
+		d.state.flushBinary(&d.plainText)
+	}
+	d.plainText = append(d.plainText, marker...)
+	d.position++
+}
+
+// incomplete returns an error if the encoded text so far ended in the middle
+// of a byte in binary mode, in the middle of a code or a key change.
+func (d *Decoder) incomplete() error {
+	if d.state.lowerNibble || (d.state.binary && len(d.state.pending) > 0) {
+		return ErrIncompleteByte
+	}
+	if len(d.state.pending) > 0 {
+		return ErrIncompleteCode
+	}
+	if d.state.gotChangeKeyChar || d.state.keyChange {
+		return ErrIncompleteKeyChange
+	}
+	return nil
+}
+
+// discardIncomplete discards an incomplete byte, code or key change at the end
+// of the encoded text so that Close() does not fail.
+func (d *Decoder) discardIncomplete() {
+	d.state.lowerNibble = false
+	d.state.currentByte = 0
+	d.state.clearPending()
+	d.state.gotChangeKeyChar = false
+	d.state.keyChange = false
+	Wipe(&d.nextKey)
+}
+
+// Position returns the number of runes decoded so far.
+func (d *Decoder) Position() int {
+	return d.position
+}
+
+// Close returns the decoded plain text, the binary payload (nil if there is
+// none) and all key changes. It is an error if the encoded text ended in the
+// middle of a byte in binary mode or in the middle of a key change. The
+// returned slices are no longer referenced by the Decoder and it is up to the
+// caller to wipe them.
+func (d *Decoder) Close() (plainText []rune, binary []byte, keyChanges []KeyChange, err error) {
+	if err := d.incomplete(); err != nil {
+		return nil, nil, nil, err
+	}
+	plainText = d.plainText
+	d.plainText = nil
+	// A binary section that was never closed is the Binary payload.
+	if len(d.state.binaryBuffer) > 0 {
+		binary = d.state.binaryBuffer
+		d.state.binaryBuffer = nil
+	}
+	keyChanges = d.keyChanges
+	d.keyChanges = nil
+	return plainText, binary, keyChanges, nil
+}
+
+// Wipe wipes everything the decoder holds.
+func (d *Decoder) Wipe() {
+	Wipe(&d.plainText)
+	Wipe(&d.nextKey)
+	WipeBytes(&d.state.binaryBuffer)
+	for i := range d.keyChanges {
+		Wipe(&d.keyChanges[i].KeyId)
+	}
+	d.keyChanges = nil
+	d.state.reset()
+}
+
+// Decode decodes a complete encoded text using the default coding scheme, see
+// CodingScheme.Decode().
+func Decode(encoded []rune, groupSize int) ([]rune, []byte, []KeyChange, error) {
+	return DefaultCodingScheme().Decode(encoded, groupSize)
+}
+
+// Decode decodes a complete encoded text (A-Z) where groupSize is the length
+// of key ids in key changes. Returns the plain text, the binary payload (nil if
+// there is none) and all key changes found in the encoded text.
+func (s *CodingScheme) Decode(encoded []rune, groupSize int) ([]rune, []byte, []KeyChange, error) {
+	decoder := s.NewDecoder(groupSize)
+	defer decoder.Wipe()
+	for i := range encoded {
+		_, err := decoder.Decode(encoded[i])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return decoder.Close()
+}
@@ -0,0 +1,133 @@
+package krypto431
+
+import (
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	testTable := []struct {
+		text    []rune
+		encoded []rune
+	}{
+		{[]rune("Hello"), []rune("HZXZELLOZZ")},
+		{[]rune("This is a test message."), []rune("TZXZHISQISQAQTESTQMESSAGEZPZZZ")},
+		{[]rune("QUEEN & ZERBA 2023"), []rune("ZQZUEENQZWCGWZQZSZERBAQZCACDZZ")},
+		{[]rune("Åke 😀"), []rune("ZMXZKEQZWPAJPJIIAWZZ")},
+	}
+	for _, table := range testTable {
+		segments, err := Encode(table.text, EncodeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(segments) != 1 {
+			t.Fatalf("Got %d segments, wanted 1", len(segments))
+		}
+		if string(table.encoded) != string(segments[0].EncodedText) {
+			t.Errorf("Got \"%s\", but wanted \"%s\"", string(segments[0].EncodedText), string(table.encoded))
+		}
+		plainText, binary, keyChanges, err := Decode(segments[0].EncodedText, DefaultGroupSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plainText) != string(table.text) {
+			t.Errorf("Decoded \"%s\", but wanted \"%s\"", string(plainText), string(table.text))
+		}
+		if len(binary) != 0 || len(keyChanges) != 0 {
+			t.Errorf("Decoded unexpected binary %q or key changes %v", binary, keyChanges)
+		}
+	}
+}
+
+func TestEncode_MultipleKeys(t *testing.T) {
+	text := []rune("Hello world, this message needs more than one key.")
+	binary := []byte{0x00, 0xff, 0x10, 0x01}
+	keyLength := MinimumSupportedKeyLength
+	nextKeyIds := []string{"BBBBB", "CCCCC", "DDDDD", "EEEEE", "FFFFF", "GGGGG", "HHHHH"}
+	n := 0
+	segments, err := Encode(text, EncodeOptions{
+		Binary:    binary,
+		KeyId:     []rune("AAAAA"),
+		KeyLength: keyLength,
+		NextKey: func() ([]rune, int, error) {
+			if n >= len(nextKeyIds) {
+				return nil, 0, ErrOutOfKeys
+			}
+			n++
+			return []rune(nextKeyIds[n-1]), keyLength, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer WipeSegments(segments)
+	if len(segments) != n+1 {
+		t.Fatalf("Got %d segments, but NextKey was called %d times", len(segments), n)
+	}
+	var encoded []rune
+	for i := range segments {
+		if len(segments[i].EncodedText) > keyLength {
+			t.Errorf("Segment %d is %d runes long, longer than the key (%d)", i, len(segments[i].EncodedText), keyLength)
+		}
+		encoded = append(encoded, segments[i].EncodedText...)
+	}
+	if len(encoded)%DefaultGroupSize != 0 {
+		t.Errorf("Encoded text is %d runes long, not a multiple of %d", len(encoded), DefaultGroupSize)
+	}
+	plainText, decodedBinary, keyChanges, err := Decode(encoded, DefaultGroupSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plainText) != string(text) {
+		t.Errorf("Decoded \"%s\", but wanted \"%s\"", string(plainText), string(text))
+	}
+	if string(decodedBinary) != string(binary) {
+		t.Errorf("Decoded binary %q, but wanted %q", decodedBinary, binary)
+	}
+	if len(keyChanges) != len(segments)-1 {
+		t.Fatalf("Got %d key changes, wanted %d", len(keyChanges), len(segments)-1)
+	}
+	position := 0
+	for i := range keyChanges {
+		position += len(segments[i].EncodedText)
+		if keyChanges[i].Position != position {
+			t.Errorf("Key change %d at position %d, wanted %d", i, keyChanges[i].Position, position)
+		}
+		if string(keyChanges[i].KeyId) != string(segments[i+1].KeyId) {
+			t.Errorf("Key change %d to %s, wanted %s", i, string(keyChanges[i].KeyId), string(segments[i+1].KeyId))
+		}
+	}
+}
+
+func TestEncode_PlaceholderKeys(t *testing.T) {
+	segments, err := Encode([]rune("Count the keys needed for this message without having any keys."), EncodeOptions{KeyLength: MinimumSupportedKeyLength})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 2 {
+		t.Errorf("Got %d segments, expected more than one key to be needed", len(segments))
+	}
+	for i := 1; i < len(segments); i++ {
+		if string(segments[i].KeyId) != "AAAAA" {
+			t.Errorf("Segment %d has key id %s, wanted placeholder AAAAA", i, string(segments[i].KeyId))
+		}
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	testTable := []struct {
+		encoded []rune
+		err     error
+	}{
+		{[]rune("ZWAB"), nil},
+		{[]rune("ZWA"), ErrIncompleteByte},
+		{[]rune("ZYAB"), ErrIncompleteKeyChange},
+		{[]rune("HELLo"), ErrInvalidCoding},
+		{[]rune("ZWQ"), ErrInvalidControlChar},
+	}
+	for _, table := range testTable {
+		_, _, _, err := Decode(table.encoded, DefaultGroupSize)
+		if err != table.err {
+			t.Errorf("Decode(\"%s\") returned error %v, wanted %v", string(table.encoded), err, table.err)
+		}
+	}
+}
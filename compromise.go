@@ -0,0 +1,164 @@
+package krypto431
+
+// A compromised key must never be used again, neither by us nor by the other
+// keepers of the key. CompromiseKeys() marks keys compromised (FindKey and
+// EnrichWithKey refuse compromised keys) and CompromiseNotice() produces a
+// short radiogram listing the compromised key ids, for example...
+//
+//	AB CD DE EF 161230ZOCT26 = COMPROMISED KEYS ABCDE FGHIJ = K
+//
+// ...that is sent in the clear to the other keepers. On the receiving side,
+// IngestCompromiseNotice() marks the same keys compromised. The notice is not
+// enciphered (the key ids are sent in the clear in every message anyway) and
+// is only honoured for keys where the sender is one of the keepers, a forged
+// notice can at worst burn keys, never make a compromised key usable again.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sa6mwa/dtg"
+)
+
+const (
+	// CompromiseNoticeText is the start of the text of a compromise notice
+	// radiogram, followed by the compromised key ids.
+	CompromiseNoticeText string = "COMPROMISED KEYS"
+)
+
+var (
+	ErrNotACompromiseNotice = errors.New("radiogram is not a compromise notice")
+	ErrNoKeysToCompromise   = errors.New("no key ids to include in compromise notice")
+)
+
+// compromisedComment returns the comment of a compromised key, the reason and
+// DTG is prepended to any existing comment.
+func compromisedComment(when dtg.DTG, reason string, comment []rune) string {
+	c := "COMPROMISED " + when.String()
+	if reason = strings.TrimSpace(reason); reason != "" {
+		c += ": " + reason
+	}
+	if existing := strings.TrimSpace(string(comment)); existing != "" {
+		c += " / " + existing
+	}
+	return c
+}
+
+// compromise marks key compromised and records the reason and DTG in the
+// key's Comment. Returns false if the key was already compromised.
+func (k *Key) compromise(when dtg.DTG, reason string) bool {
+	if k.Compromised {
+		return false
+	}
+	comment := compromisedComment(when, reason, k.Comment)
+	Wipe(&k.Comment)
+	k.Comment = []rune(comment)
+	k.Compromised = true
+	return true
+}
+
+// CompromiseKeys marks all keys where filter returns true as compromised with
+// reason and the current DTG in the Comment of the key. To compromise all keys
+// of a group of keepers, use a filter matching the keepers. Keys already
+// compromised are left as is. Returns copies of the ids of the keys that were
+// compromised (to be used with CompromiseNotice). Call Save() to persist the
+// changes.
+func (k *Krypto431) CompromiseKeys(filter func(key *Key) bool, reason string) [][]rune {
+	k.lock()
+	defer k.unlock()
+	now := dtg.DTG{Time: time.Now()}
+	var compromised [][]rune
+	for i := range k.Keys {
+		if !filter(&k.Keys[i]) {
+			continue
+		}
+		if k.Keys[i].compromise(now, reason) {
+			k.record(JournalCompromised, &k.Keys[i], nil, reason)
+			compromised = append(compromised, RuneCopy(&k.Keys[i].Id))
+		}
+	}
+	return compromised
+}
+
+// CompromiseNotice returns a compromise notice radiogram from the instance's
+// call-sign listing keyIds, addressed to all keepers of the keys (an anonymous
+// key has no keepers to address). The radiogram can be sent to the other
+// keepers and ingested on their side with IngestCompromiseNotice().
+func (k *Krypto431) CompromiseNotice(keyIds ...[]rune) (string, error) {
+	if len(keyIds) == 0 {
+		return "", ErrNoKeysToCompromise
+	}
+	if len(k.CallSign) == 0 {
+		return "", ErrNoCallSign
+	}
+	var recipients [][]rune
+	ids := make([]string, 0, len(keyIds))
+	for i := range keyIds {
+		key, err := k.GetKey(keyIds[i])
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrKeyNotFound, string(keyIds[i]))
+		}
+		for x := range key.Keepers {
+			if !AnyNeedleInHaystack(&[][]rune{key.Keepers[x]}, &recipients) {
+				recipients = append(recipients, key.Keepers[x])
+			}
+		}
+		ids = append(ids, key.IdString())
+	}
+	var radiogram string
+	if len(recipients) > 0 {
+		radiogram = JoinRunesToString(&recipients, " ") + " "
+	}
+	radiogram += fmt.Sprintf("DE %s %s = %s %s = K", k.CallSignString(), dtg.DTG{Time: time.Now()}.String(),
+		CompromiseNoticeText, strings.Join(ids, " "))
+	return radiogram, nil
+}
+
+// IsCompromiseNotice returns true if the plain text of the message is a
+// compromise notice.
+func (m *Message) IsCompromiseNotice() bool {
+	return strings.HasPrefix(strings.ToUpper(string(m.PlainText)), CompromiseNoticeText)
+}
+
+// IngestCompromiseNotice parses a compromise notice radiogram (see
+// CompromiseNotice) and marks the listed keys compromised with the DTG and
+// sender of the notice in the Comment. A key is only marked if the sender is
+// one of the keepers of the key (or the key is anonymous). Returns the ids of
+// the keys that were marked compromised and the ids that were ignored (unknown
+// keys or keys the sender does not keep), keys already compromised are in
+// neither. Call Save() to persist the changes.
+func (k *Krypto431) IngestCompromiseNotice(radiogram string) (compromised [][]rune, ignored [][]rune, err error) {
+	m, err := k.ParseRadiogram(radiogram)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer m.Wipe()
+	if !m.IsCompromiseNotice() {
+		return nil, nil, ErrNotACompromiseNotice
+	}
+	ids := VettedKeys(string(m.PlainText[len([]rune(CompromiseNoticeText)):]))
+	if len(ids) == 0 {
+		return nil, nil, fmt.Errorf("%w: no key ids", ErrNotACompromiseNotice)
+	}
+	reason := "NOTICE DE " + string(m.From)
+	k.lock()
+	defer k.unlock()
+	for i := range ids {
+		key, err := k.GetKey(ids[i])
+		if err != nil {
+			ignored = append(ignored, ids[i])
+			continue
+		}
+		if len(key.Keepers) > 0 && !key.ContainsKeeper(m.From) && !m.IsMyCall() {
+			ignored = append(ignored, ids[i])
+			continue
+		}
+		if key.compromise(m.DTG, reason) {
+			k.record(JournalCompromised, key, nil, reason)
+			compromised = append(compromised, ids[i])
+		}
+	}
+	return compromised, ignored, nil
+}
@@ -0,0 +1,81 @@
+package krypto431
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKrypto431_CompromiseKeys(t *testing.T) {
+	sender := New(WithCallSign("SA6MWA"))
+	if err := sender.GenerateKeys(2, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.GenerateKeys(1, nil, "SM5ABC"); err != nil {
+		t.Fatal(err)
+	}
+	sender.Keys[0].Comment = []rune("sheet 1")
+	// The receiver holds the same keys with the sender as keeper.
+	receiver := sender.ExportKeys(func(*Key) bool { return true })
+	receiver.CallSign = []rune("QJ")
+	for i := range receiver.Keys {
+		receiver.Keys[i].Keepers = [][]rune{[]rune("SA6MWA")}
+	}
+	receiver.Keys[2].Keepers = [][]rune{[]rune("SM5ABC")}
+
+	compromised := sender.CompromiseKeys(func(key *Key) bool {
+		return key.ContainsKeeper([]rune("QJ"))
+	}, "pad lost")
+	if len(compromised) != 2 {
+		t.Fatalf("Expected 2 compromised keys, got %d", len(compromised))
+	}
+	if !strings.HasPrefix(sender.Keys[0].CommentString(), "COMPROMISED ") ||
+		!strings.HasSuffix(sender.Keys[0].CommentString(), ": pad lost / sheet 1") {
+		t.Errorf("Unexpected comment %q", sender.Keys[0].CommentString())
+	}
+	if sender.Keys[2].Compromised {
+		t.Error("Expected key of another keeper to be left as is")
+	}
+	if key := sender.FindKey([]rune("QJ")); key != nil {
+		t.Errorf("FindKey returned compromised key %s", key.IdString())
+	}
+	msg := &Message{instance: &sender, KeyId: RuneCopy(&sender.Keys[0].Id), PlainText: []rune("HELLO")}
+	if err := msg.EnrichWithKey(); err == nil {
+		t.Error("Expected EnrichWithKey to refuse a compromised key")
+	}
+	if again := sender.CompromiseKeys(func(*Key) bool { return true }, ""); len(again) != 1 {
+		t.Errorf("Expected already compromised keys to be left as is, got %d", len(again))
+	}
+
+	notice, err := sender.CompromiseNotice(compromised...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := "QJ DE SA6MWA "
+	if !strings.HasPrefix(notice, wanted) || !strings.HasSuffix(notice, " = COMPROMISED KEYS "+string(compromised[0])+" "+string(compromised[1])+" = K") {
+		t.Errorf("Unexpected notice %q", notice)
+	}
+
+	marked, ignored, err := receiver.IngestCompromiseNotice(notice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marked) != 2 || len(ignored) != 0 || !receiver.Keys[0].Compromised || !receiver.Keys[1].Compromised {
+		t.Errorf("Expected 2 keys marked compromised, marked %d, ignored %d", len(marked), len(ignored))
+	}
+	if !strings.Contains(receiver.Keys[0].CommentString(), "NOTICE DE SA6MWA") {
+		t.Errorf("Unexpected comment %q", receiver.Keys[0].CommentString())
+	}
+	// The sender does not keep the third key.
+	forged := "QJ DE SA6MWA = COMPROMISED KEYS " + receiver.Keys[2].IdString() + " XXXXX = K"
+	marked, ignored, err = receiver.IngestCompromiseNotice(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(marked) != 0 || len(ignored) != 2 || receiver.Keys[2].Compromised {
+		t.Errorf("Expected notice to be ignored, marked %d, ignored %d", len(marked), len(ignored))
+	}
+	if _, _, err := receiver.IngestCompromiseNotice("QJ DE SA6MWA = HELLO WORLD = K"); !errors.Is(err, ErrNotACompromiseNotice) {
+		t.Errorf("Expected %v, got %v", ErrNotACompromiseNotice, err)
+	}
+}
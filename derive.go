@@ -0,0 +1,155 @@
+package krypto431
+
+// Keys can optionally be derived from a master seed instead of generated from
+// crand, so that a pad set can be regenerated from a short printed seed if the
+// persistence file is destroyed. This is a deliberate trade-off: derived keys
+// are only as strong as the seed and the key derivation (HKDF-SHA256 keying a
+// ChaCha20 stream), they are NOT one-time pad keys and do not have the
+// information-theoretic security of truly random keys. Derived keys are
+// labelled DERIVED in their comment. Key ids are derived from the seed and a
+// counter (persisted as SeedCounter), the key characters from the seed, the
+// counter and the key id, so a key id that comes up again (after the key was
+// deleted) never gets the characters of the old key. Regenerating keys in a
+// new instance with the same seed, group size, key length and coding scheme
+// yields the same keys in the same order.
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sa6mwa/krypto431/crand"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// DefaultMasterSeedLength is the number of letters (A-Z) of a generated
+	// master seed (about 141 bits).
+	DefaultMasterSeedLength int = 30
+	// MinimumMasterSeedLength is the shortest accepted master seed (about 117
+	// bits).
+	MinimumMasterSeedLength int = 25
+	// DerivedKeyComment is the comment of keys derived from a master seed.
+	DerivedKeyComment string = "DERIVED"
+)
+
+var (
+	ErrMasterSeedTooShort = fmt.Errorf("master seed must be at least %d letters (A-Z)", MinimumMasterSeedLength)
+	ErrNoMasterSeed       = errors.New("instance has no master seed")
+)
+
+// masterSeedSalt is the HKDF salt of all key derivations.
+var masterSeedSalt []byte = []byte("krypto431 master seed")
+
+// WithMasterSeed makes NewKey() (and all functions generating keys) derive
+// keys from seed instead of generating them from crand. Only letters A-Z of
+// seed are used (case insensitive, spaces and other characters are ignored).
+// Use Assert() to validate the length of the seed. An empty seed disables key
+// derivation.
+func WithMasterSeed(seed string) Option {
+	return func(k *Krypto431) {
+		Wipe(&k.MasterSeed)
+		k.MasterSeed = VettedMasterSeed(seed)
+	}
+}
+
+// WithSeedCounter sets the number of the next key id to derive from the master
+// seed (zero for a new or regenerated pad set).
+func WithSeedCounter(n int) Option {
+	return func(k *Krypto431) {
+		k.SeedCounter = n
+	}
+}
+
+// VettedMasterSeed returns the upper case letters A-Z of seed.
+func VettedMasterSeed(seed string) []rune {
+	var vetted []rune
+	for _, r := range strings.ToUpper(seed) {
+		if r >= 'A' && r <= 'Z' {
+			vetted = append(vetted, r)
+		}
+	}
+	return vetted
+}
+
+// GenerateMasterSeed returns a new random master seed of
+// DefaultMasterSeedLength letters.
+func GenerateMasterSeed() []rune {
+	seed := make([]rune, DefaultMasterSeedLength)
+	for i := range seed {
+		seed[i] = rune(crand.Intn(26)) + 'A'
+	}
+	return seed
+}
+
+// HasMasterSeed returns true if keys are derived from a master seed.
+func (k *Krypto431) HasMasterSeed() bool {
+	return len(k.MasterSeed) > 0
+}
+
+// MasterSeedString returns the master seed in groups (for printing) or error
+// if the instance has no master seed.
+func (k *Krypto431) MasterSeedString() (string, error) {
+	if !k.HasMasterSeed() {
+		return "", ErrNoMasterSeed
+	}
+	g, err := groups(&k.MasterSeed, k.GroupSize, k.Columns)
+	if err != nil {
+		return "", err
+	}
+	defer Wipe(g)
+	return string(*g), nil
+}
+
+// assertMasterSeed returns error if the master seed is set but too short.
+func (k *Krypto431) assertMasterSeed() error {
+	if k.HasMasterSeed() && len(k.MasterSeed) < MinimumMasterSeedLength {
+		return ErrMasterSeedTooShort
+	}
+	return nil
+}
+
+// derivedIntn returns a function with the signature of crand.Intn drawing
+// numbers from a ChaCha20 stream keyed by HKDF-SHA256 of the master seed and
+// info. Numbers are uniform (rejection sampling), n must be 1 to 256.
+func (k *Krypto431) derivedIntn(info string) func(n int) int {
+	seed := []byte(string(k.MasterSeed))
+	defer ZeroWipeBytes(&seed)
+	key := make([]byte, chacha20.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, seed, masterSeedSalt, []byte(info)), key); err != nil {
+		panic(err)
+	}
+	defer ZeroWipeBytes(&key)
+	stream, err := chacha20.NewUnauthenticatedCipher(key, make([]byte, chacha20.NonceSize))
+	if err != nil {
+		panic(err)
+	}
+	b := make([]byte, 1)
+	return func(n int) int {
+		limit := 256 - 256%n
+		for {
+			b[0] = 0
+			stream.XORKeyStream(b, b)
+			if int(b[0]) < limit {
+				return int(b[0]) % n
+			}
+		}
+	}
+}
+
+// derivedIdIntn returns the random source of the next derived key id and
+// increments the seed counter.
+func (k *Krypto431) derivedIdIntn() func(n int) int {
+	intn := k.derivedIntn(fmt.Sprintf("key id %d", k.SeedCounter))
+	k.SeedCounter++
+	return intn
+}
+
+// derivedKeyIntn returns the random source of the characters of key id
+// derived at seed counter.
+func (k *Krypto431) derivedKeyIntn(counter int, id []rune) func(n int) int {
+	return k.derivedIntn(fmt.Sprintf("key %d %s", counter, string(id)))
+}
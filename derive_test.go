@@ -0,0 +1,83 @@
+package krypto431
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKrypto431_MasterSeed(t *testing.T) {
+	seed := "ABCDE FGHIJ KLMNO PQRST UVWXY ZABCD"
+	k := New(WithCallSign("SA6MWA"), WithMasterSeed(seed))
+	if err := k.GenerateKeys(3, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	if k.SeedCounter != 3 {
+		t.Errorf("Expected seed counter 3, got %d", k.SeedCounter)
+	}
+	if k.Keys[1].CommentString() != DerivedKeyComment+" 1" {
+		t.Errorf("Unexpected comment of derived key: %s", k.Keys[1].CommentString())
+	}
+	// Regenerating from the printed seed yields the same keys.
+	printed, err := k.MasterSeedString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(WithCallSign("QJ"), WithMasterSeed(printed))
+	if err := r.GenerateKeys(3, nil, "SA6MWA"); err != nil {
+		t.Fatal(err)
+	}
+	for i := range k.Keys {
+		if !EqualRunes(&k.Keys[i].Id, &r.Keys[i].Id) || !EqualRunes(&k.Keys[i].Runes, &r.Keys[i].Runes) {
+			t.Errorf("Regenerated key %s differs from %s", r.Keys[i].IdString(), k.Keys[i].IdString())
+		}
+	}
+	// A single key is regenerated from its number.
+	single := New(WithMasterSeed(seed), WithSeedCounter(2))
+	key := single.NewKey(k.Keys[2].Expires.Time)
+	if !EqualRunes(&key.Id, &k.Keys[2].Id) || !EqualRunes(&key.Runes, &k.Keys[2].Runes) {
+		t.Errorf("Expected key %s, got %s", k.Keys[2].IdString(), key.IdString())
+	}
+
+	// A key id that comes up again after the key was deleted gets new key
+	// characters (numeric key ids are likely to come up again).
+	numeric := New(WithMasterSeed(seed), WithCodingScheme("CT37"))
+	deleted := make(map[string][]rune)
+	for n := 0; n < 10000; n++ {
+		key := numeric.NewKey(time.Time{})
+		if runes, ok := deleted[key.IdString()]; ok {
+			if EqualRunes(&runes, &key.Runes) {
+				t.Errorf("Key %s got the characters of the deleted key with the same id", key.IdString())
+			}
+			break
+		}
+		deleted[key.IdString()] = RuneCopy(&key.Runes)
+		if _, err := numeric.DeleteKey(RuneCopy(&key.Id)); err != nil {
+			t.Fatal(err)
+		}
+		if n == 9999 {
+			t.Fatal("Expected a numeric key id to come up again")
+		}
+	}
+
+	other := New(WithMasterSeed("ABCDEFGHIJKLMNOPQRSTUVWXYZABCE"))
+	other.GenerateKeys(1, nil)
+	if EqualRunes(&other.Keys[0].Runes, &k.Keys[0].Runes) {
+		t.Errorf("Expected a different seed to derive a different key")
+	}
+	random := New()
+	random.GenerateKeys(1, nil)
+	if len(random.Keys[0].Comment) != 0 || random.SeedCounter != 0 {
+		t.Errorf("Expected keys without master seed to be random")
+	}
+	if _, err := random.MasterSeedString(); !errors.Is(err, ErrNoMasterSeed) {
+		t.Errorf("Expected %v, got %v", ErrNoMasterSeed, err)
+	}
+	short := New(WithPersistence("test.krypto431"), WithMasterSeed("ABCDE"))
+	if err := short.Assert(); !errors.Is(err, ErrMasterSeedTooShort) {
+		t.Errorf("Expected %v, got %v", ErrMasterSeedTooShort, err)
+	}
+	if len(GenerateMasterSeed()) != DefaultMasterSeedLength {
+		t.Errorf("Expected generated seed of %d letters", DefaultMasterSeedLength)
+	}
+}
@@ -0,0 +1,191 @@
+package diana
+
+// Combiners combine an encoded (plain) character with a key character into a
+// cipher character and back. DIANA is the default combiner in Krypto431 as it
+// is reciprocal (same procedure for enciphering and deciphering), the other
+// classical combiners are provided to interoperate with other groups' existing
+// paper pads. All letter combiners work on A-Z (A=0 to Z=25) and the MOD10
+// combiner on digits (0-9, modulo 10 without borrowing or carrying, e.g 3-7 = 6
+// and 6+7 = 3).
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	DIANA           string = "DIANA"
+	Vigenere        string = "VIGENERE"
+	Beaufort        string = "BEAUFORT"
+	VariantBeaufort string = "VBEAUFORT"
+	Mod10           string = "MOD10"
+
+	DefaultCombiner string = DIANA
+
+	letters string = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digits  string = "0123456789"
+)
+
+var (
+	ErrUnknownCombiner = errors.New("unknown combiner")
+)
+
+// Combiner is implemented by each cipher table. Encipher writes the cipher
+// character of a plain (encoded) character and a key character, Decipher does
+// the opposite. Table returns the printable table (printed next to the key).
+// Alphabet returns the characters the combiner works on, e.g A-Z.
+type Combiner interface {
+	Name() string
+	Encipher(writeTo *rune, plain *rune, key *rune) error
+	Decipher(writeTo *rune, cipher *rune, key *rune) error
+	Table() string
+	Alphabet() string
+}
+
+// combiners in the order they are presented to the user.
+var combiners []Combiner = []Combiner{
+	dianaCombiner{},
+	newModularCombiner(Vigenere, letters, "C = P + K", func(p, k int) int { return p + k }, func(c, k int) int { return c - k }),
+	newModularCombiner(Beaufort, letters, "C = K - P", func(p, k int) int { return k - p }, func(c, k int) int { return k - c }),
+	newModularCombiner(VariantBeaufort, letters, "C = P - K", func(p, k int) int { return p - k }, func(c, k int) int { return c + k }),
+	newModularCombiner(Mod10, digits, "C = P - K", func(p, k int) int { return p - k }, func(c, k int) int { return c + k }),
+}
+
+// GetCombiner returns the combiner by name (case-insensitive). An empty name
+// returns the DefaultCombiner (DIANA).
+func GetCombiner(name string) (Combiner, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "" {
+		name = DefaultCombiner
+	}
+	for i := range combiners {
+		if combiners[i].Name() == name {
+			return combiners[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCombiner, name)
+}
+
+// Combiners returns the names of all combiners.
+func Combiners() []string {
+	names := make([]string, 0, len(combiners))
+	for i := range combiners {
+		names = append(names, combiners[i].Name())
+	}
+	return names
+}
+
+// dianaCombiner is the reciprocal DIANA trigraph, see TrigraphRune.
+type dianaCombiner struct{}
+
+func (dianaCombiner) Name() string {
+	return DIANA
+}
+
+func (dianaCombiner) Encipher(writeTo *rune, plain *rune, key *rune) error {
+	return TrigraphRune(writeTo, key, plain)
+}
+
+func (dianaCombiner) Decipher(writeTo *rune, cipher *rune, key *rune) error {
+	return TrigraphRune(writeTo, key, cipher)
+}
+
+func (dianaCombiner) Table() string {
+	return ReciprocalTable
+}
+
+func (dianaCombiner) Alphabet() string {
+	return letters
+}
+
+// modularCombiner is a combiner where the cipher character is a function of
+// the plain and key characters modulo the size of the alphabet.
+type modularCombiner struct {
+	name     string
+	alphabet []rune
+	formula  string
+	encipher func(p, k int) int
+	decipher func(c, k int) int
+	table    string
+}
+
+func newModularCombiner(name string, alphabet string, formula string, encipher func(p, k int) int, decipher func(c, k int) int) modularCombiner {
+	c := modularCombiner{
+		name:     name,
+		alphabet: []rune(alphabet),
+		formula:  formula,
+		encipher: encipher,
+		decipher: decipher,
+	}
+	c.table = c.renderTable()
+	return c
+}
+
+func (c modularCombiner) Name() string {
+	return c.name
+}
+
+// index returns the position of r in the alphabet.
+func (c modularCombiner) index(r *rune) (int, error) {
+	first, last := c.alphabet[0], c.alphabet[len(c.alphabet)-1]
+	if *r < first || *r > last {
+		return 0, fmt.Errorf("%s: input must be between %c and %c", strings.ToLower(c.name), first, last)
+	}
+	return int(*r - first), nil
+}
+
+func (c modularCombiner) combine(writeTo *rune, x *rune, key *rune, f func(x, k int) int) error {
+	if writeTo == nil || x == nil || key == nil {
+		return fmt.Errorf("%s: %w", strings.ToLower(c.name), ErrNilPointer)
+	}
+	xi, err := c.index(x)
+	if err != nil {
+		return err
+	}
+	ki, err := c.index(key)
+	if err != nil {
+		return err
+	}
+	n := len(c.alphabet)
+	*writeTo = c.alphabet[((f(xi, ki)%n)+n)%n]
+	return nil
+}
+
+func (c modularCombiner) Encipher(writeTo *rune, plain *rune, key *rune) error {
+	return c.combine(writeTo, plain, key, c.encipher)
+}
+
+func (c modularCombiner) Decipher(writeTo *rune, cipher *rune, key *rune) error {
+	return c.combine(writeTo, cipher, key, c.decipher)
+}
+
+func (c modularCombiner) Table() string {
+	return c.table
+}
+
+func (c modularCombiner) Alphabet() string {
+	return string(c.alphabet)
+}
+
+// renderTable renders a table where the row is the key character (K), the
+// column the plain character (P) and each cell is the cipher character (C).
+func (c modularCombiner) renderTable() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s (ROW K, COLUMN P)\n", c.name, c.formula)
+	b.WriteString("K\\P")
+	for _, p := range c.alphabet {
+		fmt.Fprintf(&b, " %c", p)
+	}
+	b.WriteString("\n")
+	for ki, k := range c.alphabet {
+		fmt.Fprintf(&b, "%c  ", k)
+		for pi := range c.alphabet {
+			var cipher rune
+			c.combine(&cipher, &c.alphabet[pi], &c.alphabet[ki], c.encipher)
+			fmt.Fprintf(&b, " %c", cipher)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,84 @@
+package diana
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCombiners(t *testing.T) {
+	for _, name := range Combiners() {
+		combiner, err := GetCombiner(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alphabet := []rune(combiner.Alphabet())
+		for i := range alphabet {
+			for j := range alphabet {
+				var cipher, plain rune
+				if err := combiner.Encipher(&cipher, &alphabet[i], &alphabet[j]); err != nil {
+					t.Fatal(err)
+				}
+				if err := combiner.Decipher(&plain, &cipher, &alphabet[j]); err != nil {
+					t.Fatal(err)
+				}
+				if plain != alphabet[i] {
+					t.Fatalf("%s: %c with key %c enciphered to %c deciphered to %c", name, alphabet[i], alphabet[j], cipher, plain)
+				}
+			}
+		}
+		if !strings.Contains(combiner.Table(), name) && name != DIANA {
+			t.Errorf("%s: table does not contain the combiner name", name)
+		}
+	}
+	if c, err := GetCombiner(""); err != nil || c.Name() != DefaultCombiner {
+		t.Errorf("Expected empty name to return %s", DefaultCombiner)
+	}
+	if c, err := GetCombiner("vigenere"); err != nil || c.Name() != Vigenere {
+		t.Error("Expected case-insensitive combiner name")
+	}
+	if _, err := GetCombiner("ROT13"); err == nil {
+		t.Error("Expected unknown combiner to fail")
+	}
+}
+
+func TestCombiner_Encipher(t *testing.T) {
+	testTable := []struct {
+		name, plain, key, cipher string
+	}{
+		{Vigenere, "ATTACKATDAWN", "LEMONLEMONLE", "LXFOPVEFRNHR"},
+		{Beaufort, "ATTACKATDAWN", "LEMONLEMONLE", "LLTOLBETLNPR"},
+		{VariantBeaufort, "ATTACKATDAWN", "LEMONLEMONLE", "PPHMPZWHPNLJ"},
+		{DIANA, "ATTACKATDAWN", "LEMONLEMONLE", "OCULKEVUIMSI"},
+		{Mod10, "0123456789", "3141592653", "7082964136"},
+	}
+	for _, test := range testTable {
+		combiner, err := GetCombiner(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, key := []rune(test.plain), []rune(test.key)
+		cipher := make([]rune, len(plain))
+		for i := range plain {
+			if err := combiner.Encipher(&cipher[i], &plain[i], &key[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if string(cipher) != test.cipher {
+			t.Errorf("%s: got %s, expected %s", test.name, string(cipher), test.cipher)
+		}
+	}
+	// Beaufort is reciprocal like DIANA.
+	beaufort, _ := GetCombiner(Beaufort)
+	p, k := 'H', 'Q'
+	var c, d rune
+	beaufort.Encipher(&c, &p, &k)
+	beaufort.Encipher(&d, &c, &k)
+	if d != p {
+		t.Errorf("Beaufort is not reciprocal, got %c expected %c", d, p)
+	}
+	var output rune
+	x, y := '1', 'A'
+	if err := beaufort.Encipher(&output, &x, &y); err == nil {
+		t.Error("Expected non A to Z character input to fail")
+	}
+}
@@ -0,0 +1,222 @@
+package krypto431
+
+// Keys can be generated from dice rolls instead of (or mixed with) the
+// computer's random number generator. A DiceSource turns rolls of a d6, d10 or
+// d20 into uniformly distributed numbers using rejection sampling: rolls are
+// read as digits in base "sides" and a number that would make the result
+// biased is discarded (re-rolled). With two d6 for 0-25 this is exactly the
+// table in the README, where the first roll selects the range of the second
+// roll and a first roll of 6 (or 5 followed by 3-6) is discarded.
+//
+// A key from dice can optionally be mixed with crand by adding a random number
+// modulo the alphabet size to each dice character. The result is at least as
+// random as the best of the two sources.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sa6mwa/krypto431/crand"
+)
+
+var (
+	ErrUnsupportedDice = errors.New("unsupported dice, use d6, d10 or d20")
+	ErrInvalidRoll     = errors.New("invalid dice roll")
+	ErrNotEnoughRolls  = errors.New("ran out of dice rolls")
+	ErrNoDiceSource    = errors.New("received a nil dice source")
+)
+
+// DiceSource reads dice rolls from lines of text, 1-6 for a d6, 0-9 for a d10
+// (as printed on the die) and 1-20 for a d20. Rolls can be separated by space,
+// comma or any other non-digit. For d6 and d10, each digit is a roll and rolls
+// can be written together (e.g 3415). Rolls of a d20 must be separated.
+type DiceSource struct {
+	Sides    int
+	next     func(d *DiceSource) (string, error)
+	queue    []int
+	rolls    int
+	rejected int
+}
+
+// NewDiceSource returns a DiceSource reading rolls of a sides-sided die from r
+// (until EOF).
+func NewDiceSource(sides int, r io.Reader) (*DiceSource, error) {
+	scanner := bufio.NewScanner(r)
+	return NewDicePrompt(sides, func(*DiceSource) (string, error) {
+		if scanner.Scan() {
+			return scanner.Text(), nil
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	})
+}
+
+// NewDicePrompt returns a DiceSource where prompt is called each time more
+// rolls are needed. The prompt function returns a line of rolls or error (io.EOF
+// if there are no more rolls).
+func NewDicePrompt(sides int, prompt func(d *DiceSource) (string, error)) (*DiceSource, error) {
+	switch sides {
+	case 6, 10, 20:
+	default:
+		return nil, fmt.Errorf("%w: d%d", ErrUnsupportedDice, sides)
+	}
+	return &DiceSource{Sides: sides, next: prompt}, nil
+}
+
+// Rolls returns the number of rolls read so far.
+func (d *DiceSource) Rolls() int {
+	return d.rolls
+}
+
+// Rejected returns the number of numbers discarded by rejection sampling.
+func (d *DiceSource) Rejected() int {
+	return d.rejected
+}
+
+// parse returns the rolls of a line of input.
+func (d *DiceSource) parse(line string) ([]int, error) {
+	var rolls []int
+	fields := strings.FieldsFunc(line, func(r rune) bool {
+		return !unicode.IsDigit(r)
+	})
+	for _, field := range fields {
+		if d.Sides <= 10 {
+			for _, c := range field {
+				roll := int(c - '0')
+				if d.Sides == 10 {
+					// Faces of a d10 are 0-9.
+					roll++
+				}
+				if roll < 1 || roll > d.Sides {
+					return nil, fmt.Errorf("%w: %c is not a d%d roll", ErrInvalidRoll, c, d.Sides)
+				}
+				rolls = append(rolls, roll)
+			}
+			continue
+		}
+		roll, err := strconv.Atoi(field)
+		if err != nil || roll < 1 || roll > d.Sides {
+			return nil, fmt.Errorf("%w: %s is not a d%d roll", ErrInvalidRoll, field, d.Sides)
+		}
+		rolls = append(rolls, roll)
+	}
+	return rolls, nil
+}
+
+// roll returns the next roll (1 to Sides, a d10 face 0 is 1), reading more
+// input if needed.
+func (d *DiceSource) roll() (int, error) {
+	for len(d.queue) == 0 {
+		line, err := d.next(d)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, ErrNotEnoughRolls
+			}
+			return 0, err
+		}
+		rolls, err := d.parse(line)
+		if err != nil {
+			return 0, err
+		}
+		d.queue = rolls
+	}
+	r := d.queue[0]
+	d.queue = d.queue[1:]
+	d.rolls++
+	return r, nil
+}
+
+// RollsPerNumber returns the number of rolls needed for one number between 0
+// and n-1 (when no roll is discarded).
+func (d *DiceSource) RollsPerNumber(n int) int {
+	rolls, span := 1, d.Sides
+	for span < n {
+		span *= d.Sides
+		rolls++
+	}
+	return rolls
+}
+
+// Intn returns a uniformly distributed number between 0 and n-1 from dice
+// rolls. Rolls are read as a number in base Sides, numbers at or above the
+// largest multiple of n are discarded as soon as the first rolls tell so.
+func (d *DiceSource) Intn(n int) (int, error) {
+	if n < 1 {
+		return 0, fmt.Errorf("invalid argument to Intn: %d", n)
+	}
+	rolls := d.RollsPerNumber(n)
+	span := 1
+	for i := 0; i < rolls; i++ {
+		span *= d.Sides
+	}
+	limit := (span / n) * n
+	for {
+		v, weight := 0, span
+		rejected := false
+		for i := 0; i < rolls; i++ {
+			r, err := d.roll()
+			if err != nil {
+				return 0, err
+			}
+			weight /= d.Sides
+			v = v*d.Sides + r - 1
+			// Smallest number possible with the remaining rolls.
+			if v*weight >= limit {
+				rejected = true
+				break
+			}
+		}
+		if !rejected {
+			return v % n, nil
+		}
+		d.rejected++
+	}
+}
+
+// NewDiceKey is NewKey() where the key characters are generated from dice
+// instead of crand (the key id is still random from crand). If mixWithCrand is
+// true, each character from the dice is added to a character from crand
+// (modulo 26 or 10 for numeric keys). Returns a pointer to the key in the
+// instance's Keys slice or error if the dice ran out of rolls or a roll was
+// invalid (no key is created).
+func (k *Krypto431) NewDiceKey(expire time.Time, dice *DiceSource, mixWithCrand bool, keepers ...string) (*Key, error) {
+	if dice == nil {
+		return nil, ErrNoDiceSource
+	}
+	base, first := 26, rune('A')
+	if scheme, err := k.Scheme(); err == nil && scheme.IsNumeric() {
+		base, first = 10, rune('0')
+	}
+	// Roll all characters before the key is created, same length as NewKey().
+	runes := make([]rune, int(math.Ceil(float64(k.KeyLength)/float64(k.GroupSize)))*k.GroupSize)
+	defer Wipe(&runes)
+	for i := range runes {
+		n, err := dice.Intn(base)
+		if err != nil {
+			return nil, err
+		}
+		if mixWithCrand {
+			n = (n + crand.Intn(base)) % base
+		}
+		runes[i] = rune(n) + first
+	}
+	k.NewKey(expire, keepers...)
+	key := &k.Keys[len(k.Keys)-1]
+	copy(key.Runes, runes)
+	source := fmt.Sprintf("DICE D%d", dice.Sides)
+	if mixWithCrand {
+		source += "+CRAND"
+	}
+	Wipe(&key.Comment)
+	key.Comment = []rune(source)
+	return key, nil
+}
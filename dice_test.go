@@ -0,0 +1,115 @@
+package krypto431
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiceSource_Intn(t *testing.T) {
+	// Same as the two dice table in the README: 3,4 is 15, a first roll of 6
+	// is discarded at once, 5,3 is discarded, 5,2 is 25 and 5,1 is 24.
+	d, err := NewDiceSource(6, strings.NewReader("34 6 12\n53,52 51"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, wanted := range []int{15, 1, 25, 24} {
+		n, err := d.Intn(26)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != wanted {
+			t.Errorf("Expected %d, got %d", wanted, n)
+		}
+	}
+	if d.Rolls() != 11 || d.Rejected() != 2 {
+		t.Errorf("Expected 11 rolls and 2 rejected, got %d and %d", d.Rolls(), d.Rejected())
+	}
+	if _, err := d.Intn(26); !errors.Is(err, ErrNotEnoughRolls) {
+		t.Errorf("Expected %v, got %v", ErrNotEnoughRolls, err)
+	}
+
+	// All 400 outcomes of two d20 give every letter equally often.
+	var rolls strings.Builder
+	for first := 1; first <= 20; first++ {
+		for second := 1; second <= 20; second++ {
+			fmt.Fprintf(&rolls, "%d %d ", first, second)
+		}
+	}
+	d, err = NewDiceSource(20, strings.NewReader(rolls.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := make([]int, 26)
+	for {
+		n, err := d.Intn(26)
+		if errors.Is(err, ErrNotEnoughRolls) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		count[n]++
+	}
+	for i := range count {
+		if count[i] != 15 {
+			t.Errorf("Expected %c 15 times, got %d", rune(i)+'A', count[i])
+		}
+	}
+
+	// A d10 is read as printed (0-9).
+	d, err = NewDiceSource(10, strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for wanted := 0; wanted < 10; wanted++ {
+		if n, err := d.Intn(10); err != nil || n != wanted {
+			t.Errorf("Expected %d, got %d (%v)", wanted, n, err)
+		}
+	}
+
+	d, _ = NewDiceSource(6, strings.NewReader("17"))
+	if _, err := d.Intn(26); !errors.Is(err, ErrInvalidRoll) {
+		t.Errorf("Expected %v, got %v", ErrInvalidRoll, err)
+	}
+	if _, err := NewDiceSource(8, strings.NewReader("")); !errors.Is(err, ErrUnsupportedDice) {
+		t.Errorf("Expected %v, got %v", ErrUnsupportedDice, err)
+	}
+}
+
+func TestKrypto431_NewDiceKey(t *testing.T) {
+	k := New(WithKeyLength(20), WithCallSign("SA6MWA"))
+	// A is 1,1 and B is 1,2 in the two dice table.
+	d, err := NewDiceSource(6, strings.NewReader(strings.Repeat("1112", 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := k.NewDiceKey(time.Now().Add(time.Hour), d, false, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key.Runes) != strings.Repeat("AB", 10) || key.CommentString() != "DICE D6" {
+		t.Errorf("Unexpected key %#v", *key)
+	}
+	d, _ = NewDiceSource(6, strings.NewReader(strings.Repeat("1112", 10)))
+	key, err = k.NewDiceKey(time.Now().Add(time.Hour), d, true, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.CommentString() != "DICE D6+CRAND" || len(key.Runes) != 20 {
+		t.Errorf("Unexpected key %#v", *key)
+	}
+	// Running out of rolls does not leave a half-made key behind.
+	d, _ = NewDiceSource(6, strings.NewReader("11"))
+	if _, err := k.NewDiceKey(time.Now(), d, false); !errors.Is(err, ErrNotEnoughRolls) {
+		t.Errorf("Expected %v, got %v", ErrNotEnoughRolls, err)
+	}
+	if len(k.Keys) != 2 {
+		t.Errorf("Expected 2 keys, got %d", len(k.Keys))
+	}
+	if len(k.pending) != 2 {
+		t.Errorf("Expected 2 journal entries, got %d", len(k.pending))
+	}
+}
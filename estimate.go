@@ -0,0 +1,131 @@
+package krypto431
+
+import (
+	"fmt"
+)
+
+// Estimate is the result of a dry-run of Message.Encipher(), see
+// Message.Estimate().
+type Estimate struct {
+	// EncodedLength is the number of encoded characters (the length of the
+	// CipherText excluding the key id group).
+	EncodedLength int
+	// Groups is the group count of the radiogram including the key id group
+	// (and check groups if the instance has IntegrityCheck enabled and the
+	// indicator group if the instance has PartialKeys enabled).
+	Groups int
+	// Keys is the number of keys the message will consume.
+	Keys int
+	// AvailableKeys is the number of un-used keys where all recipients are
+	// keepers (see FindKeys()).
+	AvailableKeys int
+	// Sufficient is true if there are enough un-used keys to encipher the
+	// message.
+	Sufficient bool
+}
+
+// String returns a one-line summary of the estimate.
+func (e Estimate) String() string {
+	return fmt.Sprintf("ENCODED=%d GROUPS=%d KEYS=%d AVAILABLE=%d", e.EncodedLength, e.Groups, e.Keys, e.AvailableKeys)
+}
+
+// Estimate does a dry-run of Encipher() and returns how many encoded
+// characters, groups and keys the message would consume and whether there are
+// enough un-used keys for the message's Recipients. No key is marked used and
+// the message is not changed. If the message KeyId is set, it is used as the
+// first key (as Encipher() would). If the available keys run out, the rest of
+// the message is estimated using the instance's KeyLength.
+func (m *Message) Estimate() (Estimate, error) {
+	var estimate Estimate
+	if len(m.PlainText) == 0 && len(m.Binary) == 0 {
+		return estimate, fmt.Errorf("message plain text and binary are empty")
+	}
+	candidates := m.instance.FindKeys(m.keyRecipients()...)
+	estimate.AvailableKeys = len(candidates)
+	if len(m.KeyId) > 0 {
+		key, err := m.instance.GetKey(m.KeyId)
+		if err != nil {
+			return estimate, err
+		}
+		// The key in KeyId is the first key, remove it from the candidates if
+		// present, otherwise it is an additional available key.
+		found := false
+		for i := range candidates {
+			if candidates[i] == key {
+				candidates = append(candidates[:i], candidates[i+1:]...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			if key.Used || key.Compromised {
+				return estimate, fmt.Errorf("message key id %s is used or compromised", string(m.KeyId))
+			}
+			estimate.AvailableKeys++
+		}
+		candidates = append([]*Key{key}, candidates...)
+	}
+	// The message is coded with the scheme of the first key.
+	scheme, err := m.instance.Scheme()
+	if len(candidates) > 0 {
+		scheme, err = candidates[0].Scheme()
+	}
+	if err != nil {
+		return estimate, err
+	}
+	if len(candidates) > 0 {
+		// Chained keys must use the scheme of the first key. The first key can
+		// be partially consumed, chained keys must be untouched.
+		chained := []*Key{candidates[0]}
+		for _, key := range candidates[1:] {
+			if scheme.checkKey(key) == nil && (key.Offset == 0 || !m.instance.PartialKeys) {
+				chained = append(chained, key)
+			}
+		}
+		estimate.AvailableKeys -= len(candidates) - len(chained)
+		candidates = chained
+	}
+	// keyLength returns the length of the n:th key (candidate) or the instance's
+	// KeyLength if we have run out of keys.
+	keyLength := func(n int) int {
+		if n < len(candidates) {
+			if n == 0 && m.instance.PartialKeys {
+				return candidates[n].Remaining()
+			}
+			return candidates[n].KeyLength()
+		}
+		return m.instance.KeyLength
+	}
+	n := 0
+	segments, err := scheme.Encode(m.PlainText, EncodeOptions{
+		Binary:    m.Binary,
+		GroupSize: m.instance.GroupSize,
+		KeyLength: keyLength(0),
+		NextKey: func() ([]rune, int, error) {
+			n++
+			placeholder := make([]rune, m.instance.GroupSize)
+			for i := range placeholder {
+				placeholder[i] = 'A'
+			}
+			return placeholder, keyLength(n), nil
+		},
+	})
+	if err != nil {
+		return estimate, err
+	}
+	defer WipeSegments(segments)
+	for i := range segments {
+		estimate.EncodedLength += len(segments[i].EncodedText)
+	}
+	estimate.Groups = estimate.EncodedLength/m.instance.GroupSize + 1
+	if m.instance.PartialKeys {
+		// Indicator group.
+		estimate.Groups++
+	}
+	if m.instance.IntegrityCheck {
+		estimate.Groups += checkGroupCount(estimate.EncodedLength/m.instance.GroupSize, m.instance.GroupSize)
+	}
+	estimate.Keys = len(segments)
+	estimate.Sufficient = estimate.Keys <= estimate.AvailableKeys
+	return estimate, nil
+}
@@ -0,0 +1,45 @@
+package krypto431
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMessage_Estimate(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"), WithKeyLength(50))
+	err := k.GenerateKeys(5, nil, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := "QJ DE SA6MWA " + strings.Repeat("Estimate the number of keys. ", 5)
+	msg, err := k.NewTextMessage(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NewTextMessage enciphers the message, estimate a copy of the plain text.
+	used := 0
+	for i := range k.Keys {
+		if k.Keys[i].Used {
+			used++
+			k.Keys[i].Used = false
+		}
+	}
+	estimate, err := msg.Estimate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if estimate.Keys != used {
+		t.Errorf("Estimated %d keys, Encipher used %d", estimate.Keys, used)
+	}
+	if wanted := len(msg.CipherText)/k.GroupSize + 1; estimate.Groups != wanted {
+		t.Errorf("Estimated %d groups, Encipher produced %d", estimate.Groups, wanted)
+	}
+	if !estimate.Sufficient || estimate.AvailableKeys != 5 {
+		t.Errorf("Got %s, wanted 5 available keys", estimate)
+	}
+	for i := range k.Keys {
+		if k.Keys[i].Used {
+			t.Fatal("Estimate marked a key used")
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package krypto431
+
+// Expired keys are retired automatically: FindKey() and FindKeys() skip keys
+// that have expired unless the instance allows expired keys
+// (WithAllowExpiredKeys). To avoid running out of keys, each set of keepers
+// (the stations sharing the same keys) should have a reserve of keys that are
+// still valid after a rollover period. KeysRunningLow() reports keeper sets
+// below the reserve and RolloverKeys() generates replacement keys for them.
+// The reserve and period are instance settings (KeyReserve and RolloverDays),
+// zero means the defaults below and a negative KeyReserve disables the check.
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultKeyReserve is the number of usable keys each keeper set should
+	// have after the rollover period.
+	DefaultKeyReserve int = 5
+	// DefaultRolloverDays is the period keys must be valid for to count
+	// towards the reserve.
+	DefaultRolloverDays int = 30
+)
+
+var (
+	ErrNegativeRolloverPeriod = errors.New("rollover period can not be negative")
+	ErrExpiresTooSoon         = errors.New("new keys would expire within the rollover period")
+)
+
+// KeeperReserve is the key reserve of a set of keepers (nil Keepers is the set
+// of anonymous keys). Usable is the number of un-used and un-compromised keys
+// valid for the whole rollover period, Expiring is the number of such keys
+// expiring within the period.
+type KeeperReserve struct {
+	Keepers  [][]rune
+	Usable   int
+	Expiring int
+}
+
+// JoinKeepers returns the keepers joined by separator or Anonymous if the
+// keeper set is empty.
+func (r *KeeperReserve) JoinKeepers(separator string) string {
+	if len(r.Keepers) == 0 {
+		return "Anonymous"
+	}
+	return JoinRunesToString(&r.Keepers, separator)
+}
+
+// WithAllowExpiredKeys makes FindKey() (and enciphering) use keys that have
+// expired. Expired keys are skipped by default.
+func WithAllowExpiredKeys(b bool) Option {
+	return func(k *Krypto431) {
+		k.AllowExpiredKeys = b
+	}
+}
+
+// WithKeyReserve sets the number of usable keys each keeper set should have
+// after the rollover period, negative disables KeysRunningLow().
+func WithKeyReserve(n int) Option {
+	return func(k *Krypto431) {
+		k.KeyReserve = n
+	}
+}
+
+// WithRolloverDays sets the number of days keys must be valid for to count
+// towards the key reserve.
+func WithRolloverDays(days int) Option {
+	return func(k *Krypto431) {
+		k.RolloverDays = days
+	}
+}
+
+// KeyReservePolicy returns the key reserve and rollover period of the instance
+// (the defaults if not set).
+func (k *Krypto431) KeyReservePolicy() (reserve int, within time.Duration) {
+	reserve = k.KeyReserve
+	if reserve == 0 {
+		reserve = DefaultKeyReserve
+	}
+	days := k.RolloverDays
+	if days <= 0 {
+		days = DefaultRolloverDays
+	}
+	return reserve, time.Duration(days) * 24 * time.Hour
+}
+
+// keeperSetId returns a string identifying the set of keepers regardless of
+// order.
+func keeperSetId(keepers [][]rune) string {
+	ids := make([]string, 0, len(keepers))
+	for i := range keepers {
+		ids = append(ids, strings.ToUpper(string(keepers[i])))
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// KeeperReserves returns the key reserve of every keeper set among the keys of
+// the configured group size (including sets where all keys are spent) sorted
+// by keepers. Keys are counted as usable if they are valid for at least within
+// (expiring otherwise).
+func (k *Krypto431) KeeperReserves(within time.Duration) []KeeperReserve {
+	var reserves []KeeperReserve
+	index := make(map[string]int)
+	for i := range k.Keys {
+		key := &k.Keys[i]
+		if len(key.Id) != k.GroupSize {
+			continue
+		}
+		id := keeperSetId(key.Keepers)
+		x, ok := index[id]
+		if !ok {
+			reserve := KeeperReserve{}
+			for y := range key.Keepers {
+				reserve.Keepers = append(reserve.Keepers, RuneCopy(&key.Keepers[y]))
+			}
+			reserves = append(reserves, reserve)
+			x = len(reserves) - 1
+			index[id] = x
+		}
+		if key.Used || key.Compromised || key.IsExpired() {
+			continue
+		}
+		if key.IsValid(within) {
+			reserves[x].Usable++
+		} else {
+			reserves[x].Expiring++
+		}
+	}
+	sort.SliceStable(reserves, func(i, j int) bool {
+		return keeperSetId(reserves[i].Keepers) < keeperSetId(reserves[j].Keepers)
+	})
+	return reserves
+}
+
+// KeysRunningLow returns the keeper sets with fewer than reserve keys usable
+// for at least within. Returns nil if reserve is negative.
+func (k *Krypto431) KeysRunningLow(reserve int, within time.Duration) []KeeperReserve {
+	if reserve < 0 {
+		return nil
+	}
+	var low []KeeperReserve
+	for _, r := range k.KeeperReserves(within) {
+		if r.Usable < reserve {
+			low = append(low, r)
+		}
+	}
+	return low
+}
+
+// RolloverKeys generates replacement keys for every keeper set returned by
+// KeysRunningLow() so that each set has reserve keys usable for at least
+// within. New keys expire at expire (a DTG, nil for the default one year) and
+// must be valid longer than within to count. Returns the keeper sets that got
+// new keys (with Usable as before the rollover) and the number of keys
+// generated. Call Save() to persist the keys.
+func (k *Krypto431) RolloverKeys(reserve int, within time.Duration, expire *string) ([]KeeperReserve, int, error) {
+	if within < 0 {
+		return nil, 0, ErrNegativeRolloverPeriod
+	}
+	expiryTime, err := expiryTimeOf(expire)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !time.Now().Add(within).Before(expiryTime) {
+		return nil, 0, ErrExpiresTooSoon
+	}
+	low := k.KeysRunningLow(reserve, within)
+	generated := 0
+	for _, r := range low {
+		keepers := make([]string, 0, len(r.Keepers))
+		for i := range r.Keepers {
+			keepers = append(keepers, string(r.Keepers[i]))
+		}
+		for n := r.Usable; n < reserve; n++ {
+			k.NewKey(expiryTime, keepers...)
+			generated++
+		}
+	}
+	return low, generated, nil
+}
@@ -0,0 +1,67 @@
+package krypto431
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sa6mwa/dtg"
+)
+
+func TestKrypto431_RolloverKeys(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"))
+	if err := k.GenerateKeys(2, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.GenerateKeys(3, nil, "SM5ABC,QJ"); err != nil {
+		t.Fatal(err)
+	}
+	// Both QJ keys expire within the rollover period, one has expired.
+	k.Keys[0].Expires.Time = time.Now().Add(-time.Hour)
+	k.Keys[1].Expires.Time = time.Now().Add(24 * time.Hour)
+
+	if key := k.FindKey([]rune("QJ")); key != &k.Keys[1] {
+		t.Errorf("Expected FindKey to skip the expired key")
+	}
+	allowing := New(WithCallSign("SA6MWA"), WithAllowExpiredKeys(true))
+	allowing.Keys = k.Keys
+	if key := allowing.FindKey([]rune("QJ")); key != &allowing.Keys[0] {
+		t.Errorf("Expected FindKey to return the expired key with AllowExpiredKeys")
+	}
+
+	reserve, within := k.KeyReservePolicy()
+	if reserve != DefaultKeyReserve || within != time.Duration(DefaultRolloverDays)*24*time.Hour {
+		t.Errorf("Unexpected default policy %d %s", reserve, within)
+	}
+	reserves := k.KeeperReserves(within)
+	if len(reserves) != 2 || reserves[0].JoinKeepers(",") != "QJ" || reserves[0].Usable != 0 || reserves[0].Expiring != 1 {
+		t.Fatalf("Unexpected reserves %+v", reserves)
+	}
+	if reserves[1].Usable != 3 {
+		t.Errorf("Expected 3 usable keys, got %d", reserves[1].Usable)
+	}
+	if low := k.KeysRunningLow(3, within); len(low) != 1 {
+		t.Errorf("Expected 1 keeper set running low, got %d", len(low))
+	}
+	if low := k.KeysRunningLow(-1, within); low != nil {
+		t.Errorf("Expected negative reserve to disable the check, got %d", len(low))
+	}
+
+	low, generated, err := k.RolloverKeys(3, within, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(low) != 1 || generated != 3 || len(k.Keys) != 8 {
+		t.Fatalf("Expected 3 new keys for 1 keeper set, got %d keys for %d sets", generated, len(low))
+	}
+	if !k.Keys[7].ContainsKeeper([]rune("QJ")) || len(k.Keys[7].Keepers) != 1 {
+		t.Errorf("Unexpected keepers of new key: %s", k.Keys[7].JoinKeepers(","))
+	}
+	if low := k.KeysRunningLow(3, within); len(low) != 0 {
+		t.Errorf("Expected no keeper sets running low after rollover, got %d", len(low))
+	}
+	soon := dtg.DTG{Time: time.Now().Add(48 * time.Hour)}.String()
+	if _, _, err := k.RolloverKeys(3, within, &soon); !errors.Is(err, ErrExpiresTooSoon) {
+		t.Errorf("Expected %v, got %v", ErrExpiresTooSoon, err)
+	}
+}
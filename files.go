@@ -0,0 +1,226 @@
+package krypto431
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sa6mwa/blox"
+)
+
+var (
+	// FileHeaderPrefix is the beginning of the PlainText of a file message. The
+	// complete PlainText is FileHeaderPrefix followed by the size of the file in
+	// bytes, a space and the base name of the file, e.g "FILE 1234 track.gpx".
+	// The content of the file is in the message's Binary field.
+	FileHeaderPrefix string = "FILE "
+)
+
+var (
+	ErrNotAFileMessage  = errors.New("message does not contain a file")
+	ErrFileSizeMismatch = errors.New("size of deciphered file does not match the size in the file header")
+	ErrEmptyFile        = errors.New("file is empty")
+)
+
+// NewFileMessage reads a file and enciphers it into a new outgoing message for
+// recipients (one call-sign per variadic, comma-separated call-signs or a
+// combination of both). The base name and size of the file is put in the
+// PlainText (see FileHeaderPrefix) and the content in the Binary field. The
+// message is enciphered across as many chained keys as needed and appended to
+// the instance's Messages. Use Message.Traffic() or Message.CipherTextFile() to
+// produce the group-formatted ciphertext to transmit.
+func (k *Krypto431) NewFileMessage(filename string, recipients ...string) (*Message, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer WipeBytes(&content)
+	if len(content) == 0 {
+		return nil, fmt.Errorf("%s: %w", filename, ErrEmptyFile)
+	}
+	message := &Message{
+		instance:   k,
+		Id:         k.NewUniqueMessageId(),
+		Recipients: VettedRecipients(recipients...),
+		From:       RuneCopy(&k.CallSign),
+		PlainText:  []rune(fmt.Sprintf("%s%d %s", FileHeaderPrefix, len(content), filepath.Base(filename))),
+		Binary:     ByteCopy(&content),
+	}
+	message.DTG.Time = time.Now()
+	reset := true
+	defer func() {
+		if reset {
+			message.Wipe()
+		}
+	}()
+	err = message.Encipher()
+	if err != nil {
+		return nil, err
+	}
+	k.lock()
+	k.Messages = append(k.Messages, *message)
+	k.unlock()
+	reset = false
+	return message, nil
+}
+
+// NewFileMessageFromReader reads a radiogram produced by Message.Traffic() (for
+// example a file written by Message.CipherTextFile()) from an io.Reader (until
+// EOF), deciphers it and verifies that it is a file message. The message is
+// appended to the instance's Messages. Use Message.WriteFile() to write the
+// deciphered file to disk.
+func (k *Krypto431) NewFileMessageFromReader(r io.Reader) (*Message, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	message, err := k.ParseRadiogram(string(b))
+	if err != nil {
+		return nil, err
+	}
+	reset := true
+	defer func() {
+		if reset {
+			message.Wipe()
+		}
+	}()
+	if len(message.Recipients) == 0 {
+		message.AddRecipient(k.GetCallSign())
+	}
+	err = message.TryDecipherPlainText()
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = message.FileHeader()
+	if err != nil {
+		return nil, err
+	}
+	k.lock()
+	k.Messages = append(k.Messages, *message)
+	k.unlock()
+	reset = false
+	return message, nil
+}
+
+// NewFileMessageFromCipherTextFile is a wrapper to NewFileMessageFromReader
+// reading the radiogram from file.
+func (k *Krypto431) NewFileMessageFromCipherTextFile(filename string) (*Message, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return k.NewFileMessageFromReader(f)
+}
+
+// IsFile returns true if the message is a file message (the PlainText is a
+// file header and the Binary field is populated), false if not.
+func (m *Message) IsFile() bool {
+	_, _, err := m.FileHeader()
+	return err == nil
+}
+
+// FileHeader parses the PlainText of a file message and returns the base name
+// of the file and the size in bytes. Returns error if the message is not a file
+// message or if the size of the Binary field does not match the size in the
+// header.
+func (m *Message) FileHeader() (name string, size int, err error) {
+	if !strings.HasPrefix(string(m.PlainText), FileHeaderPrefix) {
+		return "", 0, ErrNotAFileMessage
+	}
+	header := strings.TrimPrefix(string(m.PlainText), FileHeaderPrefix)
+	sizeString, name, found := strings.Cut(header, " ")
+	if !found {
+		return "", 0, ErrNotAFileMessage
+	}
+	size, err = strconv.Atoi(sizeString)
+	if err != nil || size <= 0 {
+		return "", 0, ErrNotAFileMessage
+	}
+	// Never allow the header to point outside the current directory.
+	name = filepath.Base(strings.TrimSpace(name))
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", 0, ErrNotAFileMessage
+	}
+	if len(m.Binary) != size {
+		return name, size, fmt.Errorf("%w (%d!=%d)", ErrFileSizeMismatch, len(m.Binary), size)
+	}
+	return name, size, nil
+}
+
+// WriteFile writes the Binary field of a file message to filename. If filename
+// is empty, the base name from the file header is used (written to the current
+// directory). The file will not be overwritten if it exists unless overwrite
+// is true. Returns the name of the written file or error on failure.
+func (m *Message) WriteFile(filename string, overwrite bool) (string, error) {
+	name, _, err := m.FileHeader()
+	if err != nil {
+		return "", err
+	}
+	if len(filename) == 0 {
+		filename = name
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(filename, flags, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	_, err = f.Write(m.Binary)
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// Traffic returns the message as a radiogram ready to be transmitted (the
+// =TRAFFIC=EXAMPLE= section of Message.String()). The key id is the first
+// group. By default the width is 80, but can be changed with the optional
+// width variadic (first item in slice is used for column width). Returns an
+// empty string if the message has no CipherText.
+func (m *Message) Traffic(width ...int) string {
+	w := 80
+	if len(width) > 0 && width[0] > 0 {
+		w = width[0]
+	}
+	g, _ := m.Groups()
+	if g == nil || len(*g) == 0 {
+		return ""
+	}
+	defer Wipe(g)
+	groupsPrependedWithKey := string(m.KeyId) + " " + string(*g)
+	groupCount := len(strings.Fields(groupsPrependedWithKey))
+	collective := ""
+	if m.Collective {
+		collective = " C"
+	}
+	return blox.WrapString(strings.TrimSpace(fmt.Sprintf("%s DE %s %s%s %d = %s = K", m.JoinRecipients(" "), string(m.From), m.DTG.String(), collective, groupCount, groupsPrependedWithKey)), uint(w))
+}
+
+// CipherTextFile writes the message as a radiogram (see Traffic()) to
+// filename. The file can be read back with
+// Krypto431.NewFileMessageFromCipherTextFile().
+func (m *Message) CipherTextFile(filename string) error {
+	traffic := m.Traffic()
+	if len(traffic) == 0 {
+		return errors.New("message has no cipher text to write to file")
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(traffic + LineBreak)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package krypto431
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileMessage(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	filename := filepath.Join(dir, "track.gpx")
+	err := os.WriteFile(filename, content, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := New(WithCallSign("SA6MWA"))
+	err = sender.GenerateKeys(20, nil, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sender.NewFileMessage(filename, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherTextFile := filepath.Join(dir, "track.gpx.txt")
+	err = msg.CipherTextFile(cipherTextFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Receiving station has the same keys.
+	receiver := New(WithCallSign("QJ"))
+	for i := range sender.Keys {
+		key := sender.Keys[i]
+		key.Used = false
+		key.instance = &receiver
+		receiver.Keys = append(receiver.Keys, key)
+	}
+	incoming, err := receiver.NewFileMessageFromCipherTextFile(cipherTextFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, size, err := incoming.FileHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "track.gpx" || size != len(content) {
+		t.Errorf("Got file header %s (%d bytes), wanted track.gpx (%d bytes)", name, size, len(content))
+	}
+	written, err := incoming.WriteFile(filepath.Join(dir, "received.gpx"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Error("Deciphered file differs from the original")
+	}
+	_, err = incoming.WriteFile(written, false)
+	if err == nil {
+		t.Error("WriteFile overwrote an existing file")
+	}
+	// A received header must never name a file outside the current directory.
+	for _, header := range []string{"FILE 1 ..", "FILE 1 ../..", "FILE 1 /", "FILE 1 .", `FILE 1 ..\..`, `FILE 1 a\b`} {
+		msg := &Message{PlainText: []rune(header), Binary: []byte{1}}
+		if name, _, err := msg.FileHeader(); !errors.Is(err, ErrNotAFileMessage) {
+			t.Errorf("Expected %v for header %q, got %q and %v", ErrNotAFileMessage, header, name, err)
+		}
+	}
+}
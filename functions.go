@@ -287,8 +287,8 @@ func ColumnSizes(rs [][][]rune, headerFields ...string) (columnSizes []int) {
 // Function internal to SummaryOfKeys(), faster than previous method (digest and
 // ColumnSizes). Assumes the following header:
 //
-// []string{"ID", "KEEPERS", "CREATED", "EXPIRES", "USED", "COMPROMISED", "COMMENT"}
-func predictColumnSizesOfKeys(keys []*Key) (columnSizes [7]int) {
+// []string{"ID", "KEEPERS", "CREATED", "EXPIRES", "USED", "COMPROMISED", "BOOK", "COMMENT"}
+func predictColumnSizesOfKeys(keys []*Key) (columnSizes [8]int) {
 	if len(keys) > 0 {
 		if keys[0] == nil {
 			return
@@ -304,6 +304,7 @@ func predictColumnSizesOfKeys(keys []*Key) (columnSizes [7]int) {
 		// Used and Compromised are normally the length of their headers
 		columnSizes[4] = HighestInt(len(Words["No"]), len(Words["Yes"]), len("USED"))
 		columnSizes[5] = HighestInt(len(Words["No"]), len(Words["Yes"]), len("COMPROMISED"))
+		columnSizes[6] = len("BOOK")
 		for i := range keys {
 			if keys[i] == nil {
 				continue
@@ -322,9 +323,15 @@ func predictColumnSizesOfKeys(keys []*Key) (columnSizes [7]int) {
 					columnSizes[1] = keepersLength - 1
 				}
 			}
+			if ulen := len(keys[i].UsedString()); columnSizes[4] < ulen {
+				columnSizes[4] = ulen
+			}
+			if blen := len([]rune(keys[i].SheetString())); columnSizes[6] < blen {
+				columnSizes[6] = blen
+			}
 			clen := len(keys[i].Comment)
-			if columnSizes[6] < clen {
-				columnSizes[6] = clen
+			if columnSizes[7] < clen {
+				columnSizes[7] = clen
 			}
 		}
 	}
@@ -0,0 +1,282 @@
+package krypto431
+
+// A persistence file starts with a small plaintext header followed by the
+// encrypted stream (see Save() and Load()). The header holds a magic string,
+// the file format version, how the persistence file key (PFK) was derived (KDF
+// id, iterations and salt) and a key check value (a truncated HMAC-SHA256 of
+// the header keyed by the PFK). The key check tells a wrong password apart from
+// a file that is not a krypto431 persistence file, and the KDF parameters make
+// the file self-describing (the salt no longer has to be given to open the
+// file). Files written before the header existed are format version 0. Load()
+// runs the migrations from the version of the file up to FormatVersion,
+// Save() always writes the current version.
+//
+// Header layout (integers are big endian):
+//
+//	magic      9 bytes  "KRYPTO431"
+//	version    uint16
+//	kdf        uint8    KDFNone, KDFPBKDF2 or KDFArgon2id
+//	iterations uint32   (Argon2id time)
+//	memory     uint32   Argon2id memory in KiB (version 2 and later)
+//	threads    uint8    Argon2id threads (version 2 and later)
+//	salt       uint8 length followed by the salt
+//	check      16 bytes HMAC-SHA256(PFK, all of the above)
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// FormatVersion is the persistence file format version written by Save().
+	FormatVersion int = 2
+	// KDFNone means the PFK is used as is (not derived from a password).
+	KDFNone byte = 0
+	// KDFPBKDF2 means the PFK is derived from a password with
+	// PBKDF2-HMAC-SHA256.
+	KDFPBKDF2 byte = 1
+	// KDFArgon2id means the PFK is derived from a password with Argon2id.
+	KDFArgon2id byte = 2
+	// keyCheckLength is the length of the truncated HMAC in the header.
+	keyCheckLength int = 16
+	// Chunks of the encrypted stream are at most 64 KiB plus nonce and
+	// overhead (see github.com/nknorg/encrypted-stream).
+	maxEncryptedChunkLength int = 65535 + 24 + 16
+	minEncryptedChunkLength int = 24 + 16
+)
+
+var (
+	ErrNotPersistenceFile     = errors.New("not a krypto431 persistence file")
+	ErrWrongPFK               = errors.New("wrong password or persistence file key")
+	ErrWrongPFKOrNotKrypto431 = errors.New("wrong password or persistence file key, or not a krypto431 persistence file")
+	ErrUnsupportedFormat      = fmt.Errorf("persistence file format is newer than supported version %d, upgrade krypto431", FormatVersion)
+	ErrCorruptPersistence     = errors.New("persistence file is corrupt")
+	ErrUnknownKDF             = errors.New("unknown key derivation function")
+)
+
+// persistenceMagic identifies a krypto431 persistence file with a header.
+var persistenceMagic []byte = []byte("KRYPTO431")
+
+// migrations upgrade a loaded instance from the format version (index) to the
+// next version. Add a migration (and bump FormatVersion) when a change to the
+// persisted structs needs existing data converted.
+var migrations = []func(k *Krypto431) error{
+	// 0 to 1: the header was added, the encrypted data is the same. The
+	// header is written on the next Save().
+	func(k *Krypto431) error { return nil },
+	// 1 to 2: Argon2id parameters were added to the header.
+	func(k *Krypto431) error { return nil },
+}
+
+// kdfParameters describes how a PFK is derived from a password. For Argon2id,
+// iterations is the time parameter, memory (in KiB) and threads are only used
+// by Argon2id.
+type kdfParameters struct {
+	kdf        byte
+	iterations int
+	memory     uint32
+	threads    uint8
+	salt       []byte
+}
+
+func (p *kdfParameters) equal(o *kdfParameters) bool {
+	return p.kdf == o.kdf && p.iterations == o.iterations && p.memory == o.memory &&
+		p.threads == o.threads && bytes.Equal(p.salt, o.salt)
+}
+
+// copyParameters returns a copy of p (as the salt may be wiped).
+func (p *kdfParameters) copyParameters() kdfParameters {
+	c := *p
+	c.salt = ByteCopy(&p.salt)
+	return c
+}
+
+// derive returns the PFK derived from password.
+func (p *kdfParameters) derive(password []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	switch p.kdf {
+	case KDFPBKDF2:
+		return pbkdf2.Key(password, p.salt, p.iterations, 32, sha256.New), nil
+	case KDFArgon2id:
+		return argon2.IDKey(password, p.salt, uint32(p.iterations), p.memory, p.threads, 32), nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnknownKDF, p.kdf)
+}
+
+// PersistenceHeader is the plaintext header of a persistence file.
+type PersistenceHeader struct {
+	Version    int
+	KDF        byte
+	Iterations int
+	Memory     uint32
+	Threads    uint8
+	Salt       []byte
+	keyCheck   []byte
+}
+
+// parameters returns the KDF parameters of the header.
+func (h *PersistenceHeader) parameters() kdfParameters {
+	return kdfParameters{kdf: h.KDF, iterations: h.Iterations, memory: h.Memory, threads: h.Threads, salt: ByteCopy(&h.Salt)}
+}
+
+// KDFString returns the name and parameters of the KDF of the header.
+func (h *PersistenceHeader) KDFString() string {
+	p := h.parameters()
+	return p.String()
+}
+
+// fields returns the header without the key check.
+func (h *PersistenceHeader) fields() []byte {
+	var b bytes.Buffer
+	b.Write(persistenceMagic)
+	binary.Write(&b, binary.BigEndian, uint16(h.Version))
+	b.WriteByte(h.KDF)
+	binary.Write(&b, binary.BigEndian, uint32(h.Iterations))
+	if h.Version >= 2 {
+		binary.Write(&b, binary.BigEndian, h.Memory)
+		b.WriteByte(h.Threads)
+	}
+	b.WriteByte(byte(len(h.Salt)))
+	b.Write(h.Salt)
+	return b.Bytes()
+}
+
+// keyCheckOf returns the key check value of the header with key pfk.
+func (h *PersistenceHeader) keyCheckOf(pfk []byte) []byte {
+	mac := hmac.New(sha256.New, pfk)
+	mac.Write(h.fields())
+	return mac.Sum(nil)[:keyCheckLength]
+}
+
+// CheckPFK returns true if pfk is the key of the file (always true for files
+// without a header as they have no key check).
+func (h *PersistenceHeader) CheckPFK(pfk []byte) bool {
+	if h.Version == 0 {
+		return true
+	}
+	return hmac.Equal(h.keyCheck, h.keyCheckOf(pfk))
+}
+
+// bytes returns the header with a key check value of pfk.
+func (h *PersistenceHeader) bytes(pfk []byte) []byte {
+	return append(h.fields(), h.keyCheckOf(pfk)...)
+}
+
+// persistenceHeader returns the header of the instance's persistence file.
+func (k *Krypto431) persistenceHeader() *PersistenceHeader {
+	h := &PersistenceHeader{Version: FormatVersion, KDF: k.pfkParameters.kdf}
+	if h.KDF != KDFNone {
+		h.Iterations = k.pfkParameters.iterations
+		h.Memory = k.pfkParameters.memory
+		h.Threads = k.pfkParameters.threads
+		h.Salt = ByteCopy(&k.pfkParameters.salt)
+	}
+	return h
+}
+
+// readPersistenceHeader reads the header from r. A file without the magic is
+// format version 0 if it looks like an encrypted stream (r is rewound to the
+// start), ErrNotPersistenceFile otherwise.
+func readPersistenceHeader(r io.ReadSeeker) (*PersistenceHeader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(persistenceMagic))
+	if err != nil || !bytes.Equal(magic, persistenceMagic) {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		// Format version 0, the first chunk of the stream is prefixed by a
+		// little endian length.
+		var length uint32
+		if err := binary.Read(r, binary.LittleEndian, &length); err != nil ||
+			int(length) < minEncryptedChunkLength || int(length) > maxEncryptedChunkLength {
+			return nil, ErrNotPersistenceFile
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return &PersistenceHeader{Version: 0}, nil
+	}
+	br.Discard(len(persistenceMagic))
+	var version uint16
+	var iterations uint32
+	h := &PersistenceHeader{}
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return nil, ErrCorruptPersistence
+	}
+	h.Version = int(version)
+	if h.Version > FormatVersion {
+		return nil, fmt.Errorf("%w (file is version %d)", ErrUnsupportedFormat, h.Version)
+	}
+	if h.KDF, err = br.ReadByte(); err != nil {
+		return nil, ErrCorruptPersistence
+	}
+	if err := binary.Read(br, binary.BigEndian, &iterations); err != nil {
+		return nil, ErrCorruptPersistence
+	}
+	h.Iterations = int(iterations)
+	if h.Version >= 2 {
+		if err := binary.Read(br, binary.BigEndian, &h.Memory); err != nil {
+			return nil, ErrCorruptPersistence
+		}
+		if h.Threads, err = br.ReadByte(); err != nil {
+			return nil, ErrCorruptPersistence
+		}
+	}
+	saltLength, err := br.ReadByte()
+	if err != nil {
+		return nil, ErrCorruptPersistence
+	}
+	h.Salt = make([]byte, saltLength)
+	if _, err := io.ReadFull(br, h.Salt); err != nil {
+		return nil, ErrCorruptPersistence
+	}
+	h.keyCheck = make([]byte, keyCheckLength)
+	if _, err := io.ReadFull(br, h.keyCheck); err != nil {
+		return nil, ErrCorruptPersistence
+	}
+	// Out of range KDF parameters are refused before anything is derived.
+	p := h.parameters()
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptPersistence, err)
+	}
+	// Position r after the header (bufio may have read ahead).
+	if _, err := r.Seek(int64(len(h.fields())+keyCheckLength), io.SeekStart); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ReadPersistenceHeader returns the header of persistence file filename
+// (Version 0 for files written before the header existed) or error if the
+// file is not a krypto431 persistence file.
+func ReadPersistenceHeader(filename string) (*PersistenceHeader, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readPersistenceHeader(f)
+}
+
+// migrate upgrades the instance loaded from a file of format version from to
+// FormatVersion.
+func (k *Krypto431) migrate(from int) error {
+	for v := from; v < FormatVersion; v++ {
+		if err := migrations[v](k); err != nil {
+			return fmt.Errorf("migrating %s from format version %d to %d: %w", k.persistence, v, v+1, err)
+		}
+	}
+	return nil
+}
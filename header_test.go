@@ -0,0 +1,163 @@
+package krypto431
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKrypto431_PersistenceHeader(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.krypto431")
+	k := New(WithPersistence(file), WithCallSign("SA6MWA"), WithSaltString(GenerateSalt()))
+	if err := k.SetPFKFromPassword("correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.GenerateKeys(2, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadPersistenceHeader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != FormatVersion || header.KDF != KDFArgon2id || header.Iterations != DefaultArgon2idTime ||
+		header.Memory != DefaultArgon2idMemory || header.Threads != DefaultArgon2idThreads || len(header.Salt) != MinimumSaltLength {
+		t.Fatalf("Unexpected header %+v", header)
+	}
+
+	// The salt is read from the header, the default salt is not used.
+	l := New(WithPersistence(file))
+	if err := l.SetPFKFromPassword("correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Keys) != 2 || l.CallSignString() != "SA6MWA" {
+		t.Errorf("Unexpected instance after Load: %d keys, call-sign %s", len(l.Keys), l.CallSignString())
+	}
+	wrong := New(WithPersistence(file))
+	if err := wrong.SetPFKFromPassword("incorrect horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrong.Load(); !errors.Is(err, ErrWrongPFK) {
+		t.Errorf("Expected %v, got %v", ErrWrongPFK, err)
+	}
+
+	// Files without a header (format version 0) used PBKDF2, they are loaded
+	// and migrated.
+	pbkdf2File := filepath.Join(dir, "pbkdf2.krypto431")
+	p := New(WithPersistence(pbkdf2File), WithPBKDF2(DefaultPBKDF2Iteration), WithSalt(BytePtr(ByteCopy(&header.Salt))))
+	if err := p.SetPFKFromPassword("correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(); err != nil {
+		t.Fatal(err)
+	}
+	pbkdf2Header, err := ReadPersistenceHeader(pbkdf2File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(pbkdf2File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLength := len(pbkdf2Header.fields()) + keyCheckLength
+	legacy := filepath.Join(dir, "legacy.krypto431")
+	if err := os.WriteFile(legacy, data[headerLength:], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if header, err := ReadPersistenceHeader(legacy); err != nil || header.Version != 0 {
+		t.Fatalf("Expected format version 0, got %+v, %v", header, err)
+	}
+	old := New(WithPersistence(legacy), WithSalt(BytePtr(ByteCopy(&header.Salt))))
+	if err := old.SetPFKFromPassword("correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if header, err := ReadPersistenceHeader(legacy); err != nil || header.Version != FormatVersion {
+		t.Errorf("Expected format version %d after Save, got %+v, %v", FormatVersion, header, err)
+	}
+	if err := os.WriteFile(legacy, data[headerLength:], 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldWrong := New(WithPersistence(legacy))
+	if err := oldWrong.SetPFKFromPassword("correct horse battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if err := oldWrong.Load(); !errors.Is(err, ErrWrongPFKOrNotKrypto431) {
+		t.Errorf("Expected %v, got %v", ErrWrongPFKOrNotKrypto431, err)
+	}
+
+	notOurs := filepath.Join(dir, "README.md")
+	if err := os.WriteFile(notOurs, []byte("# This is not a krypto431 file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	n := New(WithPersistence(notOurs), WithPFKString(GeneratePFK()))
+	if err := n.Load(); !errors.Is(err, ErrNotPersistenceFile) {
+		t.Errorf("Expected %v, got %v", ErrNotPersistenceFile, err)
+	}
+
+	newer := filepath.Join(dir, "newer.krypto431")
+	future := append([]byte{}, data...)
+	binary.BigEndian.PutUint16(future[len(persistenceMagic):], uint16(FormatVersion+1))
+	if err := os.WriteFile(newer, future, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPersistenceHeader(newer); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected %v, got %v", ErrUnsupportedFormat, err)
+	}
+
+	// The KDF parameters are read before the key check can be verified, an
+	// oversized header is refused before deriving anything.
+	argon2Data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdfOffset := len(persistenceMagic) + 2 + 1
+	oversized := filepath.Join(dir, "oversized.krypto431")
+	for _, tamper := range []struct {
+		data   []byte
+		offset int
+	}{
+		{data, kdfOffset},           // PBKDF2 iterations
+		{argon2Data, kdfOffset},     // Argon2id time
+		{argon2Data, kdfOffset + 4}, // Argon2id memory
+	} {
+		tampered := append([]byte{}, tamper.data...)
+		binary.BigEndian.PutUint32(tampered[tamper.offset:], 0xffffffff)
+		if err := os.WriteFile(oversized, tampered, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadPersistenceHeader(oversized); !errors.Is(err, ErrCorruptPersistence) {
+			t.Errorf("Expected %v, got %v", ErrCorruptPersistence, err)
+		}
+		o := New(WithPersistence(oversized))
+		o.SetPassword("correct horse battery staple")
+		if err := o.Load(); !errors.Is(err, ErrCorruptPersistence) {
+			t.Errorf("Expected %v from Load, got %v", ErrCorruptPersistence, err)
+		}
+	}
+
+	// A PFK used as is has no KDF parameters in the header.
+	pfk := New(WithPersistence(filepath.Join(dir, "pfk.krypto431")))
+	if err := pfk.SetPFKFromString(GeneratePFK()); err != nil {
+		t.Fatal(err)
+	}
+	if err := pfk.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if header, err := ReadPersistenceHeader(pfk.GetPersistence()); err != nil || header.KDF != KDFNone || len(header.Salt) != 0 {
+		t.Errorf("Expected header without KDF, got %+v, %v", header, err)
+	}
+}
@@ -0,0 +1,175 @@
+package krypto431
+
+// A one-time pad provides confidentiality, but no integrity: a single
+// miscopied group silently turns into wrong plain text. When the instance has
+// IntegrityCheck enabled, Encipher() appends check groups to the CipherText
+// and Decipher() verifies them before deciphering. The cipher text is divided
+// into chunks of CheckChunkGroups groups and each chunk has one check
+// character. Check characters are written in full groups (padded with the
+// check characters of empty chunks).
+//
+// The check characters are computed over the cipher text as transmitted (not
+// the plain text), a failed check means the cipher text was miscopied and the
+// operator should ask for a repeat of the groups in the failed chunk. If the
+// check passes but the plain text is garbled, the error is in the keying (e.g
+// wrong key or a misprinted key). The check is not a MAC and does not protect
+// against deliberate tampering.
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// CheckChunkGroups is the number of cipher text groups covered by each
+	// check character.
+	CheckChunkGroups int = 5
+)
+
+var (
+	ErrIntegrityCheck       = errors.New("integrity check failed")
+	ErrMissingCheckGroups   = errors.New("cipher text is too short to contain check groups")
+	ErrInvalidCheckAlphabet = errors.New("empty check character alphabet")
+)
+
+// IntegrityError is returned by Decipher() when one or more chunks of the
+// cipher text do not match their check character. Chunks are counted from 1.
+// Group numbers are counted as transmitted where the key id is group 1 (the
+// first cipher text group is group 2). errors.Is(err, ErrIntegrityCheck) is
+// true for an IntegrityError.
+type IntegrityError struct {
+	Chunks    []int
+	GroupSize int
+	// Groups is the number of cipher text groups (excluding the key id and
+	// check groups).
+	Groups int
+}
+
+// GroupRange returns the first and last group (as transmitted, where the key
+// id is group 1) covered by chunk (counted from 1).
+func (e *IntegrityError) GroupRange(chunk int) (first int, last int) {
+	first = (chunk-1)*CheckChunkGroups + 2
+	last = first + CheckChunkGroups - 1
+	if last > e.Groups+1 {
+		last = e.Groups + 1
+	}
+	return first, last
+}
+
+func (e *IntegrityError) Error() string {
+	failed := make([]string, 0, len(e.Chunks))
+	for _, chunk := range e.Chunks {
+		first, last := e.GroupRange(chunk)
+		failed = append(failed, fmt.Sprintf("chunk %d (groups %d-%d)", chunk, first, last))
+	}
+	return fmt.Sprintf("%v in %s, ask for a repeat", ErrIntegrityCheck, strings.Join(failed, ", "))
+}
+
+func (e *IntegrityError) Unwrap() error {
+	return ErrIntegrityCheck
+}
+
+// checkGroupCount returns the number of check groups needed for a cipher text
+// of groups groups.
+func checkGroupCount(groups int, groupSize int) int {
+	chunks := (groups + CheckChunkGroups - 1) / CheckChunkGroups
+	return (chunks + groupSize - 1) / groupSize
+}
+
+// checkCharacter returns the check character of chunk (counted from 0).
+func checkCharacter(chunk int, cipherText []rune, alphabet []rune) rune {
+	h := sha256.New()
+	var index [8]byte
+	binary.BigEndian.PutUint64(index[:], uint64(chunk))
+	h.Write(index[:])
+	h.Write([]byte(string(cipherText)))
+	sum := h.Sum(nil)
+	return alphabet[binary.BigEndian.Uint64(sum[:8])%uint64(len(alphabet))]
+}
+
+// CheckGroups returns the check groups (without spaces) of cipherText. The
+// cipherText must be a multiple of groupSize long and not include the key id.
+// The alphabet is the set of characters the check characters are drawn from,
+// normally the alphabet of the key's combiner (A-Z or 0-9).
+func CheckGroups(cipherText []rune, groupSize int, alphabet []rune) ([]rune, error) {
+	if groupSize < 1 {
+		return nil, ErrInvalidGroupSize
+	}
+	if len(alphabet) == 0 {
+		return nil, ErrInvalidCheckAlphabet
+	}
+	if len(cipherText)%groupSize != 0 {
+		return nil, fmt.Errorf("cipher text is %d characters long, not a multiple of group size %d", len(cipherText), groupSize)
+	}
+	chunkLength := CheckChunkGroups * groupSize
+	check := make([]rune, checkGroupCount(len(cipherText)/groupSize, groupSize)*groupSize)
+	for i := range check {
+		start, end := i*chunkLength, (i+1)*chunkLength
+		if start > len(cipherText) {
+			start = len(cipherText)
+		}
+		if end > len(cipherText) {
+			end = len(cipherText)
+		}
+		check[i] = checkCharacter(i, cipherText[start:end], alphabet)
+	}
+	return check, nil
+}
+
+// splitCheckGroups returns the length of the cipher text part of cipherText
+// that ends in check groups (as produced by Encipher() with IntegrityCheck).
+func splitCheckGroups(cipherText []rune, groupSize int) (int, error) {
+	if groupSize < 1 {
+		return 0, ErrInvalidGroupSize
+	}
+	if len(cipherText)%groupSize != 0 {
+		return 0, ErrMissingCheckGroups
+	}
+	total := len(cipherText) / groupSize
+	// Number of check groups grows with the number of cipher groups, find the
+	// number of cipher groups that adds up to the total.
+	for groups := total - 1; groups > 0; groups-- {
+		n := groups + checkGroupCount(groups, groupSize)
+		if n == total {
+			return groups * groupSize, nil
+		}
+		if n < total {
+			break
+		}
+	}
+	return 0, ErrMissingCheckGroups
+}
+
+// VerifyCheckGroups verifies the check groups at the end of cipherText (as
+// produced by Encipher() with IntegrityCheck enabled). Returns the length of
+// the cipher text without the check groups and nil if all chunks are intact,
+// an *IntegrityError listing the failed chunks or another error if cipherText
+// can not contain check groups.
+func VerifyCheckGroups(cipherText []rune, groupSize int, alphabet []rune) (int, error) {
+	n, err := splitCheckGroups(cipherText, groupSize)
+	if err != nil {
+		return 0, err
+	}
+	expected, err := CheckGroups(cipherText[:n], groupSize, alphabet)
+	if err != nil {
+		return 0, err
+	}
+	defer Wipe(&expected)
+	received := cipherText[n:]
+	var failed []int
+	chunks := (n/groupSize + CheckChunkGroups - 1) / CheckChunkGroups
+	// Check characters after the last chunk are padding and do not cover any
+	// cipher text, a miscopied padding character is harmless.
+	for i := 0; i < chunks; i++ {
+		if expected[i] != received[i] {
+			failed = append(failed, i+1)
+		}
+	}
+	if len(failed) > 0 {
+		return n, &IntegrityError{Chunks: failed, GroupSize: groupSize, Groups: n / groupSize}
+	}
+	return n, nil
+}
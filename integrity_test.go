@@ -0,0 +1,93 @@
+package krypto431
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckGroups(t *testing.T) {
+	for groupSize := 1; groupSize <= 7; groupSize++ {
+		for groups := 1; groups < 200; groups++ {
+			total := groups + checkGroupCount(groups, groupSize)
+			n, err := splitCheckGroups(make([]rune, total*groupSize), groupSize)
+			if err != nil {
+				t.Fatalf("group size %d, %d groups: %v", groupSize, groups, err)
+			}
+			if n != groups*groupSize {
+				t.Fatalf("group size %d, %d groups: split at %d, expected %d", groupSize, groups, n, groups*groupSize)
+			}
+		}
+	}
+	alphabet := []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	// 12 groups, 3 chunks, 1 check group.
+	cipherText := []rune("UHWOVSZOMRLRHRGBWXVJFGAKHUBCFLMKTHYLNBNRNWOEQFMIXQNUXRPRZICM")
+	check, err := CheckGroups(cipherText, 5, alphabet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(check) != 5 {
+		t.Fatalf("Expected one check group, got %s", string(check))
+	}
+	received := append(RuneCopy(&cipherText), check...)
+	n, err := VerifyCheckGroups(received, 5, alphabet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(cipherText) {
+		t.Errorf("Expected cipher text length %d, got %d", len(cipherText), n)
+	}
+	// Miscopy group 8 (as transmitted, the key id is group 1) which is in the
+	// second chunk.
+	received[6*5+2] = 'A'
+	_, err = VerifyCheckGroups(received, 5, alphabet)
+	var integrityError *IntegrityError
+	if !errors.As(err, &integrityError) || !errors.Is(err, ErrIntegrityCheck) {
+		t.Fatalf("Expected an IntegrityError, got %v", err)
+	}
+	if len(integrityError.Chunks) != 1 || integrityError.Chunks[0] != 2 {
+		t.Fatalf("Expected chunk 2 to fail, got %v", integrityError.Chunks)
+	}
+	if first, last := integrityError.GroupRange(2); first != 7 || last != 11 {
+		t.Errorf("Expected groups 7-11, got %d-%d", first, last)
+	}
+	if first, last := integrityError.GroupRange(3); first != 12 || last != 13 {
+		t.Errorf("Expected groups 12-13, got %d-%d", first, last)
+	}
+	if _, err := VerifyCheckGroups(cipherText[:5], 5, alphabet); !errors.Is(err, ErrMissingCheckGroups) {
+		t.Errorf("Expected %v, got %v", ErrMissingCheckGroups, err)
+	}
+}
+
+func TestMessage_EncipherWithIntegrityCheck(t *testing.T) {
+	for _, scheme := range []string{DefaultCodingSchemeId, "CT37"} {
+		k := New(WithCallSign("SA6MWA"), WithKeyLength(MinimumSupportedKeyLength*2), WithCodingScheme(scheme), WithIntegrityCheck(true))
+		err := k.GenerateKeys(20, nil, "QJ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := []rune("This message is longer than one key and has check groups appended.")
+		msg := &Message{instance: &k, Recipients: VettedRecipients("QJ"), PlainText: RuneCopy(&text)}
+		err = msg.Encipher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range k.Keys {
+			k.Keys[i].Used = false
+		}
+		estimate, err := (&Message{instance: &k, Recipients: VettedRecipients("QJ"), PlainText: RuneCopy(&text)}).Estimate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if estimate.Groups != len(msg.CipherText)/k.GroupSize+1 {
+			t.Errorf("%s: estimated %d groups, message has %d", scheme, estimate.Groups, len(msg.CipherText)/k.GroupSize+1)
+		}
+		received := &Message{instance: &k, KeyId: RuneCopy(&msg.KeyId), CipherText: RuneCopy(&msg.CipherText)}
+		err = received.Decipher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(received.PlainText) != string(text) {
+			t.Errorf("%s: got \"%s\", wanted \"%s\"", scheme, string(received.PlainText), string(text))
+		}
+	}
+}
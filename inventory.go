@@ -0,0 +1,154 @@
+package krypto431
+
+// The key inventory is a per station (or per net) view of the keys for
+// planning redistribution of keys. Keys are grouped by keeper set (all keepers
+// of a key) or, optionally, by each individual keeper where a key kept by
+// several stations is counted for every one of them. Remaining messages are
+// estimated from the average length of the messages in the instance: with
+// partial keys from the characters left, otherwise one message consumes at
+// least one whole key.
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultAverageMessageLength is the message length (in characters) used to
+// estimate remaining messages when the instance has no messages.
+const DefaultAverageMessageLength int = 100
+
+// KeeperInventory is the key inventory of a keeper set (or a single keeper).
+// Nil Keepers are the anonymous keys. Used, Compromised and Expired are
+// exclusive in that order, Unused are the keys that can still be used and
+// ExpiringSoon is the number of them expiring within the requested period.
+// Characters is the number of key characters left in the unused keys and
+// Messages the estimated number of messages they can encipher.
+type KeeperInventory struct {
+	Keepers      [][]rune
+	Keys         int
+	Unused       int
+	Used         int
+	Expired      int
+	Compromised  int
+	ExpiringSoon int
+	Characters   int
+	Messages     int
+}
+
+// JoinKeepers returns the keepers joined by separator or Anonymous if there
+// are no keepers.
+func (i *KeeperInventory) JoinKeepers(separator string) string {
+	if len(i.Keepers) == 0 {
+		return "Anonymous"
+	}
+	return JoinRunesToString(&i.Keepers, separator)
+}
+
+// AverageMessageLength returns the average cipher text length of the
+// instance's messages or DefaultAverageMessageLength if there are none.
+func (k *Krypto431) AverageMessageLength() int {
+	total, count := 0, 0
+	for i := range k.Messages {
+		if len(k.Messages[i].CipherText) > 0 {
+			total += len(k.Messages[i].CipherText)
+			count++
+		}
+	}
+	if count == 0 {
+		return DefaultAverageMessageLength
+	}
+	return (total + count - 1) / count
+}
+
+// KeyInventory returns the inventory of keys where filter returns true grouped
+// by keeper set (or by keeper if byKeeper is true) sorted by keepers. Unused
+// keys expiring within the period are counted as ExpiringSoon. Messages are
+// estimated with averageLength characters per message (0 for
+// AverageMessageLength()).
+func (k *Krypto431) KeyInventory(filter func(key *Key) bool, byKeeper bool, within time.Duration, averageLength int) []KeeperInventory {
+	if averageLength <= 0 {
+		averageLength = k.AverageMessageLength()
+	}
+	var inventory []KeeperInventory
+	index := make(map[string]int)
+	entry := func(keepers [][]rune) int {
+		id := keeperSetId(keepers)
+		x, ok := index[id]
+		if !ok {
+			i := KeeperInventory{}
+			for y := range keepers {
+				i.Keepers = append(i.Keepers, RuneCopy(&keepers[y]))
+			}
+			inventory = append(inventory, i)
+			x = len(inventory) - 1
+			index[id] = x
+		}
+		return x
+	}
+	for i := range k.Keys {
+		key := &k.Keys[i]
+		if !filter(key) {
+			continue
+		}
+		// Indexes, as the inventory slice grows.
+		var entries []int
+		if byKeeper && len(key.Keepers) > 0 {
+			for x := range key.Keepers {
+				entries = append(entries, entry(key.Keepers[x:x+1]))
+			}
+		} else {
+			entries = append(entries, entry(key.Keepers))
+		}
+		for _, x := range entries {
+			e := &inventory[x]
+			e.Keys++
+			switch {
+			case key.Used:
+				e.Used++
+			case key.Compromised:
+				e.Compromised++
+			case key.IsExpired():
+				e.Expired++
+			default:
+				e.Unused++
+				if !key.IsValid(within) {
+					e.ExpiringSoon++
+				}
+				e.Characters += key.Remaining()
+			}
+		}
+	}
+	// A message longer than a key is chained over several keys.
+	keysPerMessage := 1
+	if k.KeyLength > 0 {
+		keysPerMessage = (averageLength + k.KeyLength - 1) / k.KeyLength
+	}
+	for i := range inventory {
+		if k.PartialKeys {
+			inventory[i].Messages = inventory[i].Characters / averageLength
+		} else {
+			inventory[i].Messages = inventory[i].Unused / keysPerMessage
+		}
+	}
+	sort.SliceStable(inventory, func(i, j int) bool {
+		return keeperSetId(inventory[i].Keepers) < keeperSetId(inventory[j].Keepers)
+	})
+	return inventory
+}
+
+// SummaryOfInventory returns a formatted header and one line per entry of a
+// KeyInventory().
+func SummaryOfInventory(inventory []KeeperInventory) (header []rune, lines [][]rune) {
+	columnHeader := []string{"KEEPERS", "KEYS", "UNUSED", "USED", "EXPIRED", "COMPROMISED", "EXPIRING", "CHARACTERS", "MESSAGES"}
+	var rows [][][]rune
+	for i := range inventory {
+		e := &inventory[i]
+		row := [][]rune{[]rune(e.JoinKeepers(","))}
+		for _, n := range []int{e.Keys, e.Unused, e.Used, e.Expired, e.Compromised, e.ExpiringSoon, e.Characters, e.Messages} {
+			row = append(row, []rune(fmt.Sprintf("%d", n)))
+		}
+		rows = append(rows, row)
+	}
+	return formatTable(columnHeader, rows)
+}
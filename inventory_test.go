@@ -0,0 +1,55 @@
+package krypto431
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKrypto431_KeyInventory(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"), WithKeyLength(100))
+	if err := k.GenerateKeys(4, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.GenerateKeys(2, nil, "QJ,SM5ABC"); err != nil {
+		t.Fatal(err)
+	}
+	k.Keys[0].Used = true
+	k.Keys[1].Compromised = true
+	k.Keys[2].Expires.Time = time.Now().Add(-time.Hour)
+	k.Keys[3].Expires.Time = time.Now().Add(24 * time.Hour)
+	all := func(*Key) bool { return true }
+	within := 7 * 24 * time.Hour
+
+	inventory := k.KeyInventory(all, false, within, 50)
+	if len(inventory) != 2 {
+		t.Fatalf("Expected 2 keeper sets, got %d", len(inventory))
+	}
+	qj := inventory[0]
+	if qj.JoinKeepers(",") != "QJ" || qj.Keys != 4 || qj.Used != 1 || qj.Compromised != 1 || qj.Expired != 1 || qj.Unused != 1 || qj.ExpiringSoon != 1 {
+		t.Errorf("Unexpected inventory %+v", qj)
+	}
+	if qj.Characters != 100 || qj.Messages != 1 {
+		t.Errorf("Expected 100 characters for 1 message, got %d and %d", qj.Characters, qj.Messages)
+	}
+	// Messages longer than a key consume several keys.
+	if net := k.KeyInventory(all, false, within, 150)[1]; net.Unused != 2 || net.Messages != 1 {
+		t.Errorf("Expected 1 message from 2 keys, got %d from %d", net.Messages, net.Unused)
+	}
+
+	byKeeper := k.KeyInventory(all, true, within, 50)
+	if len(byKeeper) != 2 || byKeeper[0].JoinKeepers(",") != "QJ" || byKeeper[0].Keys != 6 || byKeeper[1].Keys != 2 {
+		t.Errorf("Unexpected inventory per keeper %+v", byKeeper)
+	}
+
+	k.PartialKeys = true
+	k.Keys[4].Offset = 40
+	if net := k.KeyInventory(all, false, within, 50)[1]; net.Characters != 160 || net.Messages != 3 {
+		t.Errorf("Expected 160 characters for 3 messages, got %d and %d", net.Characters, net.Messages)
+	}
+
+	header, lines := SummaryOfInventory(inventory)
+	if !strings.HasPrefix(string(header), "KEEPERS ") || len(lines) != 2 || !strings.HasPrefix(string(lines[1]), "QJ,SM5ABC ") {
+		t.Errorf("Unexpected summary %q %q", header, lines)
+	}
+}
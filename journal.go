@@ -0,0 +1,539 @@
+package krypto431
+
+// Every change of the state of a key (generated, imported, exported, used for
+// a message, compromised, deleted or edited by the operator) is recorded in an
+// append-only journal next to the persistence file (persistence file name
+// with suffix .journal). Entries are collected in the instance and appended
+// by Save() (or SaveJournal()) before the persistence file is written. Each
+// entry is encrypted (NaCl secretbox) with a random journal key kept in the
+// persistence file and holds the SHA-256 hash of the previous entry, so
+// entries can not be read, changed, removed or reordered without it being
+// detected. The persistence file records the sequence number and hash of the
+// last entry it has seen, a journal truncated or replaced is detected as well.
+//
+// Load() replays the journal: a key used or compromised according to the
+// journal but not in the persistence file (a persistence file rolled back to
+// an older copy, for example from a backup) is marked used or compromised
+// again and a warning is printed. A one-time pad key is never used twice.
+// Load() fails if the journal can not be replayed (it is broken, truncated or
+// encrypted with another key, e.g the persistence file is a backup saved
+// before the journal was started) unless the journal is disabled with
+// WithJournal(false). ResetJournal() starts a new journal.
+//
+// Journal file layout: the magic "KRYPTO431JOURNAL" followed by records of a
+// uint32 (big endian) length, a 24 byte nonce and the sealed entry.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sa6mwa/dtg"
+	"github.com/sa6mwa/krypto431/crand"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	JournalGenerated     string = "GENERATED"
+	JournalImported      string = "IMPORTED"
+	JournalExported      string = "EXPORTED"
+	JournalUsed          string = "USED"
+	JournalUnused        string = "UNUSED"
+	JournalCompromised   string = "COMPROMISED"
+	JournalUncompromised string = "UNCOMPROMISED"
+	JournalDeleted       string = "DELETED"
+	// maxJournalRecordLength is the longest accepted sealed entry.
+	maxJournalRecordLength int = 64 * 1024
+)
+
+var (
+	ErrJournalKey       = errors.New("journal is not encrypted with the journal key of the persistence file")
+	ErrJournalBroken    = errors.New("journal has been tampered with or is corrupt")
+	ErrJournalTruncated = errors.New("journal is shorter than recorded in the persistence file (truncated or replaced)")
+)
+
+// journalMagic identifies a krypto431 journal file.
+var journalMagic []byte = []byte("KRYPTO431JOURNAL")
+
+// JournalEntry is an entry of the journal. Offset is the position a partially
+// used key is used up to (see PartialKeys), zero when the whole key is used.
+// Previous is the hash of the previous entry (zeroes for the first entry).
+type JournalEntry struct {
+	Sequence  uint64
+	DTG       dtg.DTG
+	CallSign  []rune
+	Action    string
+	KeyId     []rune
+	MessageId []rune
+	Offset    int
+	Comment   []rune
+	Previous  []byte
+}
+
+// JournalReport is the result of comparing the journal with the persistence
+// file. Conflicts are the last journal entries of the keys that are not used,
+// compromised or deleted in the persistence file although they are according
+// to the journal.
+type JournalReport struct {
+	Entries   int
+	Sequence  uint64
+	Conflicts []JournalEntry
+	head      []byte
+}
+
+// WithJournal enables (default) or disables the journal. A disabled journal
+// is neither written by Save() nor replayed by Load().
+func WithJournal(enabled bool) Option {
+	return func(k *Krypto431) {
+		k.journal = enabled
+	}
+}
+
+// JournalFile returns the file name of the journal of the persistence file.
+func (k *Krypto431) JournalFile() string {
+	return k.persistence + ".journal"
+}
+
+// String returns the entry as a line for listing.
+func (e *JournalEntry) String() string {
+	s := fmt.Sprintf("%-6d %s %-10s %-13s %-5s", e.Sequence, e.DTG.String(), string(e.CallSign), e.Action, string(e.KeyId))
+	if len(e.MessageId) > 0 {
+		s += " MSG " + string(e.MessageId)
+	}
+	if e.Offset > 0 {
+		s += fmt.Sprintf(" OFFSET %d", e.Offset)
+	}
+	if len(e.Comment) > 0 {
+		s += " " + string(e.Comment)
+	}
+	return strings.TrimSpace(s)
+}
+
+// hash returns the SHA-256 hash of the entry.
+func (e *JournalEntry) hash() []byte {
+	h := sha256.Sum256(e.bytes())
+	return h[:]
+}
+
+// bytes returns the entry serialized (integers are big endian, strings are
+// prefixed by a uint16 length).
+func (e *JournalEntry) bytes() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, e.Sequence)
+	binary.Write(&b, binary.BigEndian, e.DTG.Time.UnixNano())
+	for _, s := range []string{string(e.CallSign), e.Action, string(e.KeyId), string(e.MessageId), string(e.Comment)} {
+		binary.Write(&b, binary.BigEndian, uint16(len(s)))
+		b.WriteString(s)
+	}
+	binary.Write(&b, binary.BigEndian, uint32(e.Offset))
+	b.Write(e.Previous)
+	return b.Bytes()
+}
+
+// parseJournalEntry returns the entry serialized by JournalEntry.bytes().
+func parseJournalEntry(data []byte) (*JournalEntry, error) {
+	r := bytes.NewReader(data)
+	e := &JournalEntry{}
+	var nanoseconds int64
+	if err := binary.Read(r, binary.BigEndian, &e.Sequence); err != nil {
+		return nil, ErrJournalBroken
+	}
+	if err := binary.Read(r, binary.BigEndian, &nanoseconds); err != nil {
+		return nil, ErrJournalBroken
+	}
+	e.DTG.Time = time.Unix(0, nanoseconds)
+	fields := make([]string, 5)
+	for i := range fields {
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return nil, ErrJournalBroken
+		}
+		s := make([]byte, length)
+		if _, err := io.ReadFull(r, s); err != nil {
+			return nil, ErrJournalBroken
+		}
+		fields[i] = string(s)
+	}
+	e.CallSign, e.Action, e.KeyId, e.MessageId, e.Comment = []rune(fields[0]), fields[1], []rune(fields[2]), []rune(fields[3]), []rune(fields[4])
+	var offset uint32
+	if err := binary.Read(r, binary.BigEndian, &offset); err != nil {
+		return nil, ErrJournalBroken
+	}
+	e.Offset = int(offset)
+	e.Previous = make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, e.Previous); err != nil || r.Len() != 0 {
+		return nil, ErrJournalBroken
+	}
+	return e, nil
+}
+
+// record adds an entry for key to the entries appended to the journal on the
+// next Save() (call with the instance mutex held).
+func (k *Krypto431) record(action string, key *Key, messageId []rune, comment string) {
+	if !k.journal {
+		return
+	}
+	e := JournalEntry{
+		DTG:       dtg.DTG{Time: time.Now()},
+		Action:    action,
+		KeyId:     RuneCopy(&key.Id),
+		MessageId: RuneCopy(&messageId),
+		Comment:   []rune(comment),
+	}
+	// A partially used key is recorded with how far it is used.
+	if action == JournalUsed && !key.Used {
+		e.Offset = key.Offset
+	}
+	k.pending = append(k.pending, e)
+}
+
+// journalKey returns the journal key, generating it if the persistence file
+// has none.
+func (k *Krypto431) journalKey() (*[32]byte, error) {
+	if len(k.JournalKey) != 32 {
+		k.JournalKey = make([]byte, 32)
+		if _, err := crand.Read(k.JournalKey); err != nil {
+			return nil, err
+		}
+	}
+	return (*[32]byte)(k.JournalKey), nil
+}
+
+// ReadJournal returns the entries of the journal after verifying that they
+// are encrypted with the journal key of the instance and that the hash chain
+// is intact. Returns no entries if there is no journal.
+func (k *Krypto431) ReadJournal() ([]JournalEntry, error) {
+	if err := k.expandPersistence(); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(k.JournalFile())
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return k.readJournal(f)
+}
+
+// readJournal returns the verified entries of the journal read from r (see
+// ReadJournal()).
+func (k *Krypto431) readJournal(rd io.Reader) ([]JournalEntry, error) {
+	r := bufio.NewReader(rd)
+	magic := make([]byte, len(journalMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, journalMagic) {
+		return nil, fmt.Errorf("%s: %w: not a krypto431 journal", k.JournalFile(), ErrJournalBroken)
+	}
+	if len(k.JournalKey) != 32 {
+		return nil, fmt.Errorf("%s: %w", k.JournalFile(), ErrJournalKey)
+	}
+	key := (*[32]byte)(k.JournalKey)
+	var entries []JournalEntry
+	previous := make([]byte, sha256.Size)
+	for {
+		var length uint32
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("%s: %w: incomplete entry %d", k.JournalFile(), ErrJournalBroken, len(entries)+1)
+		}
+		if int(length) < 24+secretbox.Overhead || int(length) > maxJournalRecordLength {
+			return nil, fmt.Errorf("%s: %w: invalid entry %d", k.JournalFile(), ErrJournalBroken, len(entries)+1)
+		}
+		record := make([]byte, length)
+		if _, err := io.ReadFull(r, record); err != nil {
+			return nil, fmt.Errorf("%s: %w: incomplete entry %d", k.JournalFile(), ErrJournalBroken, len(entries)+1)
+		}
+		var nonce [24]byte
+		copy(nonce[:], record[:24])
+		data, ok := secretbox.Open(nil, record[24:], &nonce, key)
+		if !ok {
+			if len(entries) == 0 {
+				return nil, fmt.Errorf("%s: %w", k.JournalFile(), ErrJournalKey)
+			}
+			return nil, fmt.Errorf("%s: %w: entry %d can not be decrypted", k.JournalFile(), ErrJournalBroken, len(entries)+1)
+		}
+		e, err := parseJournalEntry(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w: entry %d", k.JournalFile(), err, len(entries)+1)
+		}
+		if e.Sequence != uint64(len(entries)+1) || !bytes.Equal(e.Previous, previous) {
+			return nil, fmt.Errorf("%s: %w: entry %d is out of sequence", k.JournalFile(), ErrJournalBroken, len(entries)+1)
+		}
+		previous = e.hash()
+		entries = append(entries, *e)
+	}
+	return entries, nil
+}
+
+// SaveJournal appends the recorded entries to the journal without saving the
+// persistence file (for example after ExportKeys()), Save() does this as well.
+func (k *Krypto431) SaveJournal() error {
+	k.lock()
+	defer k.unlock()
+	if err := k.expandPersistence(); err != nil {
+		return err
+	}
+	unlock, err := k.lockPersistence(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return k.writeJournal()
+}
+
+// writeJournal appends the recorded entries to the journal (call with the
+// persistence file locked).
+func (k *Krypto431) writeJournal() error {
+	if !k.journal || len(k.pending) == 0 || len(k.persistence) == 0 {
+		return nil
+	}
+	key, err := k.journalKey()
+	if err != nil {
+		return err
+	}
+	entries, err := k.ReadJournal()
+	if err != nil {
+		return err
+	}
+	// Never append to a journal with fewer entries than this instance has seen.
+	if err := k.checkTruncated(entries); err != nil {
+		return err
+	}
+	sequence := uint64(len(entries))
+	previous := make([]byte, sha256.Size)
+	if len(entries) > 0 {
+		previous = entries[len(entries)-1].hash()
+	}
+	var b bytes.Buffer
+	if len(entries) == 0 {
+		b.Write(journalMagic)
+	}
+	for i := range k.pending {
+		e := &k.pending[i]
+		sequence++
+		e.Sequence = sequence
+		e.CallSign = RuneCopy(&k.CallSign)
+		e.Previous = previous
+		var nonce [24]byte
+		if _, err := crand.Read(nonce[:]); err != nil {
+			return err
+		}
+		sealed := secretbox.Seal(nonce[:], e.bytes(), &nonce, key)
+		binary.Write(&b, binary.BigEndian, uint32(len(sealed)))
+		b.Write(sealed)
+		previous = e.hash()
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if len(entries) == 0 {
+		// A journal without entries (only the magic) is started over.
+		flags |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(k.JournalFile(), flags, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	k.JournalSequence = sequence
+	k.JournalHead = previous
+	k.pending = nil
+	return nil
+}
+
+// checkTruncated returns ErrJournalTruncated if entries (the journal) does not
+// contain the last entry seen by the instance.
+func (k *Krypto431) checkTruncated(entries []JournalEntry) error {
+	if k.JournalSequence == 0 {
+		return nil
+	}
+	if uint64(len(entries)) < k.JournalSequence || !bytes.Equal(entries[k.JournalSequence-1].hash(), k.JournalHead) {
+		return fmt.Errorf("%s: %w (%d entries, expected at least %d)", k.JournalFile(), ErrJournalTruncated, len(entries), k.JournalSequence)
+	}
+	return nil
+}
+
+// ResetJournal starts a new journal with a new journal key, the current
+// journal is kept with suffix .old. Use it when the persistence file can not
+// be loaded with its journal (see Load()) after checking that no key is used
+// according to the old journal (load with WithJournal(false) and see
+// VerifyJournal()). Call Save() to persist the reset.
+func (k *Krypto431) ResetJournal() error {
+	k.lock()
+	defer k.unlock()
+	if err := k.expandPersistence(); err != nil {
+		return err
+	}
+	unlock, err := k.lockPersistence(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := k.moveJournalAside(); err != nil {
+		return err
+	}
+	ZeroWipeBytes(&k.JournalKey)
+	k.JournalKey = nil
+	k.JournalSequence = 0
+	k.JournalHead = nil
+	k.pending = nil
+	return nil
+}
+
+// moveJournalAside renames the journal with suffix .old (call with the
+// persistence file locked).
+func (k *Krypto431) moveJournalAside() error {
+	err := os.Rename(k.JournalFile(), k.JournalFile()+".old")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// moveStaleJournalAside moves the journal aside (see ResetJournal()) if it is
+// left by another persistence file at the same path, i.e Save() creates a new
+// file or overwrites a file the instance has not loaded and the journal is not
+// encrypted with the instance's journal key (call with the persistence file
+// locked).
+func (k *Krypto431) moveStaleJournalAside() error {
+	if k.checksum != nil {
+		return nil
+	}
+	if _, err := k.ReadJournal(); !errors.Is(err, ErrJournalKey) {
+		return nil
+	}
+	return k.moveJournalAside()
+}
+
+// journalState is the state of a key according to the journal.
+type journalState struct {
+	used        bool
+	offset      int
+	compromised bool
+	deleted     bool
+	last        *JournalEntry
+}
+
+// VerifyJournal reads the journal (see ReadJournal()) and compares it with
+// the instance. Returns ErrJournalTruncated if the journal has fewer entries
+// than the persistence file has seen (or the last of them is not the same
+// entry). The instance is not changed, see Load() for replaying the journal.
+func (k *Krypto431) VerifyJournal() (*JournalReport, error) {
+	entries, err := k.ReadJournal()
+	if err != nil {
+		return nil, err
+	}
+	report, _, err := k.verifyJournal(entries)
+	return report, err
+}
+
+// verifyJournal is VerifyJournal() of the journal entries also returning the
+// state of the keys according to the journal.
+func (k *Krypto431) verifyJournal(entries []JournalEntry) (*JournalReport, map[string]*journalState, error) {
+	report := &JournalReport{Entries: len(entries), Sequence: k.JournalSequence}
+	if len(entries) > 0 {
+		report.head = entries[len(entries)-1].hash()
+	}
+	if err := k.checkTruncated(entries); err != nil {
+		return report, nil, err
+	}
+	states := make(map[string]*journalState)
+	order := make([]string, 0)
+	for i := range entries {
+		e := &entries[i]
+		id := string(e.KeyId)
+		s, ok := states[id]
+		if !ok {
+			s = &journalState{}
+			states[id] = s
+			order = append(order, id)
+		}
+		switch e.Action {
+		case JournalGenerated, JournalImported:
+			*s = journalState{}
+		case JournalUsed:
+			if e.Offset > 0 {
+				if e.Offset > s.offset {
+					s.offset = e.Offset
+				}
+			} else {
+				s.used = true
+			}
+		case JournalUnused:
+			s.used = false
+		case JournalCompromised:
+			s.compromised = true
+		case JournalUncompromised:
+			s.compromised = false
+		case JournalDeleted:
+			s.deleted = true
+		default:
+			continue
+		}
+		s.last = e
+	}
+	for _, id := range order {
+		s := states[id]
+		if s.last == nil {
+			continue
+		}
+		key, err := k.GetKey([]rune(id))
+		if err != nil {
+			continue
+		}
+		if (s.used && !key.Used) || (s.offset > key.Offset && !key.Used) ||
+			(s.compromised && !key.Compromised) || (s.deleted && !key.Used) {
+			report.Conflicts = append(report.Conflicts, *s.last)
+		}
+	}
+	return report, states, nil
+}
+
+// replayJournal marks the keys in conflict with the journal entries (see
+// VerifyJournal()) used or compromised again (call with the instance mutex
+// held). Keys deleted according to the journal are marked used.
+func (k *Krypto431) replayJournal(entries []JournalEntry) (*JournalReport, error) {
+	report, states, err := k.verifyJournal(entries)
+	if err != nil {
+		return report, err
+	}
+	for i := range report.Conflicts {
+		key, err := k.GetKey(report.Conflicts[i].KeyId)
+		if err != nil {
+			continue
+		}
+		s := states[string(report.Conflicts[i].KeyId)]
+		if s.used || s.deleted {
+			key.Used = true
+		}
+		if s.offset > key.Offset {
+			key.Offset = s.offset
+		}
+		if s.compromised {
+			key.Compromised = true
+		}
+	}
+	// The instance has now seen all entries.
+	k.JournalSequence = uint64(report.Entries)
+	k.JournalHead = report.head
+	return report, nil
+}
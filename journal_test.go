@@ -0,0 +1,231 @@
+package krypto431
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKrypto431_Journal(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.krypto431")
+	pfk := GeneratePFK()
+	k := New(WithPersistence(file), WithPFKString(pfk), WithCallSign("SA6MWA"), WithOverwritePersistenceIfExists(true))
+	if err := k.GenerateKeys(4, nil, "SA4LGZ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := k.NewTextMessage("SA4LGZ DE SA6MWA 012345 C = HELLO = K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := RuneCopy(&msg.KeyId)
+	var compromised []rune
+	k.CompromiseKeys(func(key *Key) bool {
+		if key.Used || compromised != nil {
+			return false
+		}
+		compromised = RuneCopy(&key.Id)
+		return true
+	}, "TEST")
+	deleted := RuneCopy(&k.Keys[len(k.Keys)-1].Id)
+	if _, err := k.DeleteKey(RuneCopy(&deleted)); err != nil {
+		t.Fatal(err)
+	}
+	exported := k.ExportKeys(func(key *Key) bool { return true })
+	defer exported.Wipe()
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := k.ReadJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{JournalGenerated, JournalGenerated, JournalGenerated, JournalGenerated, JournalUsed, JournalCompromised, JournalDeleted, JournalExported, JournalExported, JournalExported}
+	if len(entries) != len(actions) {
+		t.Fatalf("Expected %d journal entries, got %d", len(actions), len(entries))
+	}
+	for i := range entries {
+		if entries[i].Action != actions[i] || entries[i].Sequence != uint64(i+1) || string(entries[i].CallSign) != "SA6MWA" {
+			t.Errorf("Unexpected entry %d: %s", i+1, entries[i].String())
+		}
+	}
+	if !EqualRunes(&entries[4].KeyId, &used) || !EqualRunes(&entries[4].MessageId, &msg.Id) || !EqualRunes(&entries[5].KeyId, &compromised) {
+		t.Errorf("Unexpected entries %s and %s", entries[4].String(), entries[5].String())
+	}
+
+	// Roll back to the file saved before the message, the key is marked used
+	// again when loaded.
+	if err := k.RestoreBackup(1); err != nil {
+		t.Fatal(err)
+	}
+	old := New(WithPersistence(file), WithPFKString(pfk), WithJournal(false))
+	if err := old.Load(); err != nil {
+		t.Fatal(err)
+	}
+	report, err := old.VerifyJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Entries != len(actions) || report.Sequence != 4 || len(report.Conflicts) != 3 {
+		t.Fatalf("Expected 3 conflicts, got %+v", report)
+	}
+	l := New(WithPersistence(file), WithPFKString(pfk))
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range [][]rune{used, deleted} {
+		if key, err := l.GetKey(id); err != nil || !key.Used {
+			t.Errorf("Expected key %s to be marked used again", string(id))
+		}
+	}
+	if key, err := l.GetKey(compromised); err != nil || !key.Compromised {
+		t.Errorf("Expected key %s to be marked compromised again", string(compromised))
+	}
+	if report, err := l.VerifyJournal(); err != nil || len(report.Conflicts) != 0 {
+		t.Errorf("Expected no conflicts after replay, got %+v, %v", report, err)
+	}
+
+	// Tampering, truncating and another journal key are detected.
+	data, err := os.ReadFile(l.JournalFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 1
+	if err := os.WriteFile(l.JournalFile(), tampered, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.ReadJournal(); !errors.Is(err, ErrJournalBroken) {
+		t.Errorf("Expected %v, got %v", ErrJournalBroken, err)
+	}
+	if err := os.WriteFile(l.JournalFile(), data[:len(journalMagic)], 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.VerifyJournal(); !errors.Is(err, ErrJournalTruncated) {
+		t.Errorf("Expected %v, got %v", ErrJournalTruncated, err)
+	}
+	// A truncated journal is neither replayed nor appended to.
+	truncated := New(WithPersistence(file), WithPFKString(pfk))
+	if err := truncated.Load(); !errors.Is(err, ErrJournalTruncated) {
+		t.Errorf("Expected Load() to return %v, got %v", ErrJournalTruncated, err)
+	}
+	l.NewKey(time.Time{})
+	if err := l.Save(); !errors.Is(err, ErrJournalTruncated) {
+		t.Errorf("Expected Save() to return %v, got %v", ErrJournalTruncated, err)
+	}
+	if err := os.WriteFile(l.JournalFile(), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	other := New(WithPersistence(file), WithPFKString(pfk))
+	other.JournalKey = make([]byte, 32)
+	if _, err := other.ReadJournal(); !errors.Is(err, ErrJournalKey) {
+		t.Errorf("Expected %v, got %v", ErrJournalKey, err)
+	}
+}
+
+func TestKrypto431_JournalOlderBackup(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.krypto431")
+	pfk := GeneratePFK()
+	k := New(WithPersistence(file), WithPFKString(pfk), WithCallSign("SA6MWA"), WithOverwritePersistenceIfExists(true))
+	// Nothing is journaled yet, backup 1 predates the journal.
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.GenerateKeys(2, nil, "SA4LGZ"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := k.NewTextMessage("SA4LGZ DE SA6MWA 012345 C = HELLO = K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.RestoreBackup(1); err != nil {
+		t.Fatal(err)
+	}
+	l := New(WithPersistence(file), WithPFKString(pfk))
+	if err := l.Load(); !errors.Is(err, ErrJournalKey) {
+		t.Fatalf("Expected Load() of a backup older than the journal to return %v, got %v", ErrJournalKey, err)
+	}
+
+	// The journal can only be started over explicitly.
+	m := New(WithPersistence(file), WithPFKString(pfk), WithJournal(false))
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ResetJournal(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(m.JournalFile() + ".old"); err != nil {
+		t.Errorf("Expected the previous journal to be kept: %v", err)
+	}
+	n := New(WithPersistence(file), WithPFKString(pfk))
+	if err := n.Load(); err != nil {
+		t.Fatal(err)
+	}
+	key := n.NewKey(time.Time{}, "SA4LGZ")
+	if err := n.Save(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := n.ReadJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || !EqualRunes(&entries[0].KeyId, &key.Id) || EqualRunes(&entries[0].KeyId, &msg.KeyId) {
+		t.Errorf("Expected a new journal with 1 entry, got %d entries", len(entries))
+	}
+}
+
+func TestKrypto431_JournalInitTwice(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "test.krypto431")
+	// As init --yes, each run a fresh instance overwriting the file.
+	initialize := func() {
+		t.Helper()
+		k := New(WithPersistence(file), WithCallSign("SA6MWA"), WithOverwritePersistenceIfExists(true))
+		defer k.Wipe()
+		if err := k.SetSaltFromString(GenerateSalt()); err != nil {
+			t.Fatal(err)
+		}
+		k.SetPassword("correct horse battery staple")
+		if err := k.GenerateKeys(2, nil, "SA4LGZ"); err != nil {
+			t.Fatal(err)
+		}
+		if err := k.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	initialize()
+	initialize()
+	if _, err := os.Stat(file + ".journal.old"); err != nil {
+		t.Errorf("Expected the journal of the first file to be moved aside: %v", err)
+	}
+	// The file is removed, the journal is left behind.
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	initialize()
+	l := New(WithPersistence(file))
+	l.SetPassword("correct horse battery staple")
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := l.ReadJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || len(l.Keys) != 2 {
+		t.Errorf("Expected 2 keys and 2 journal entries, got %d and %d", len(l.Keys), len(entries))
+	}
+}
@@ -0,0 +1,177 @@
+package krypto431
+
+// The persistence file key (PFK) is derived from a password with Argon2id
+// (default) or PBKDF2-HMAC-SHA256 (files from earlier versions). The KDF and
+// its parameters used for new keys are instance settings (WithArgon2id(),
+// WithPBKDF2()), the parameters a file was saved with are read from its header
+// (see header.go) so files open regardless of the settings. New files get a
+// random salt (see GenerateSalt()) as the published DefaultSalt shared by all
+// installations makes precomputed attacks feasible, Save() replaces the
+// DefaultSalt when it creates a file (unless the salt was set explicitly).
+// RederivePFK() upgrades a file to the current settings and a new salt.
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	// DefaultArgon2idTime is the default number of Argon2id passes.
+	DefaultArgon2idTime int = 3
+	// DefaultArgon2idMemory is the default Argon2id memory in KiB (64 MiB).
+	DefaultArgon2idMemory uint32 = 64 * 1024
+	// DefaultArgon2idThreads is the default Argon2id parallelism.
+	DefaultArgon2idThreads uint8 = 4
+	// MaxArgon2idTime, MaxArgon2idMemory (KiB, 4 GiB) and MaxPBKDF2Iterations
+	// are the largest parameters accepted. The parameters in the header are
+	// not authenticated before the key is derived, a tampered header must not
+	// make Load() exhaust memory or run for days.
+	MaxArgon2idTime     int    = 64
+	MaxArgon2idMemory   uint32 = 4 * 1024 * 1024
+	MaxPBKDF2Iterations int    = 10000000
+)
+
+var (
+	ErrInvalidKDFParameters = errors.New("invalid key derivation parameters")
+	ErrNoPassword           = errors.New("persistence file key was not derived from a password")
+)
+
+// defaultKDF returns the KDF parameters (without salt) of new instances.
+func defaultKDF() kdfParameters {
+	return kdfParameters{
+		kdf:        KDFArgon2id,
+		iterations: DefaultArgon2idTime,
+		memory:     DefaultArgon2idMemory,
+		threads:    DefaultArgon2idThreads,
+	}
+}
+
+// validate returns error if the parameters are out of range.
+func (p *kdfParameters) validate() error {
+	switch p.kdf {
+	case KDFNone:
+		return nil
+	case KDFPBKDF2:
+		if p.iterations < 1 {
+			return fmt.Errorf("%w: PBKDF2 needs at least 1 iteration", ErrInvalidKDFParameters)
+		}
+		if p.iterations > MaxPBKDF2Iterations {
+			return fmt.Errorf("%w: PBKDF2 iterations above %d", ErrInvalidKDFParameters, MaxPBKDF2Iterations)
+		}
+		return nil
+	case KDFArgon2id:
+		if p.iterations < 1 || p.threads < 1 || p.memory < 8*uint32(p.threads) {
+			return fmt.Errorf("%w: Argon2id needs time and threads of at least 1 and at least 8 KiB memory per thread", ErrInvalidKDFParameters)
+		}
+		if p.iterations > MaxArgon2idTime || p.memory > MaxArgon2idMemory {
+			return fmt.Errorf("%w: Argon2id time above %d or memory above %d MiB", ErrInvalidKDFParameters, MaxArgon2idTime, MaxArgon2idMemory/1024)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %d", ErrUnknownKDF, p.kdf)
+}
+
+func (p *kdfParameters) String() string {
+	switch p.kdf {
+	case KDFNone:
+		return "NONE"
+	case KDFPBKDF2:
+		return fmt.Sprintf("PBKDF2 ITERATIONS=%d", p.iterations)
+	case KDFArgon2id:
+		return fmt.Sprintf("ARGON2ID TIME=%d MEMORY=%dMiB THREADS=%d", p.iterations, p.memory/1024, p.threads)
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", p.kdf)
+}
+
+// WithArgon2id derives new persistence file keys from passwords with Argon2id
+// using time passes, memory KiB and threads (default).
+func WithArgon2id(time int, memory uint32, threads uint8) Option {
+	return func(k *Krypto431) {
+		k.kdf = kdfParameters{kdf: KDFArgon2id, iterations: time, memory: memory, threads: threads}
+	}
+}
+
+// WithPBKDF2 derives new persistence file keys from passwords with
+// PBKDF2-HMAC-SHA256 using iterations (DefaultPBKDF2Iteration in earlier
+// versions).
+func WithPBKDF2(iterations int) Option {
+	return func(k *Krypto431) {
+		k.kdf = kdfParameters{kdf: KDFPBKDF2, iterations: iterations}
+	}
+}
+
+// KDFString returns the KDF and parameters new persistence file keys are
+// derived with.
+func (k *Krypto431) KDFString() string {
+	return k.kdf.String()
+}
+
+// PFKString returns the KDF and parameters the current persistence file key
+// was derived with (NONE if it was not derived from a password).
+func (k *Krypto431) PFKString() string {
+	return k.pfkParameters.String()
+}
+
+// RederivePFK derives the persistence file key again from password, with the
+// instance's KDF settings (see WithArgon2id()) and a new random salt. The
+// password must be the one the current key was derived from, the password is
+// wiped. Call Save() to write the file with the new key. Returns ErrNoPassword
+// if the key was set directly (e.g SetPFKFromString()).
+func (k *Krypto431) RederivePFK(password *[]byte) error {
+	if password == nil {
+		return ErrNilPointer
+	}
+	defer WipeBytes(password)
+	if k.persistenceKey == nil || k.pfkParameters.kdf == KDFNone {
+		return ErrNoPassword
+	}
+	if err := k.kdf.validate(); err != nil {
+		return err
+	}
+	current, err := k.pfkParameters.derive(*password)
+	if err != nil {
+		return err
+	}
+	defer WipeBytes(&current)
+	if !bytes.Equal(current, *k.persistenceKey) {
+		return ErrWrongPFK
+	}
+	if err := k.SetSaltFromString(GenerateSalt()); err != nil {
+		return err
+	}
+	parameters := k.kdf.copyParameters()
+	parameters.salt = ByteCopy(k.salt)
+	return k.derivePFK(*password, parameters)
+}
+
+// randomizeDefaultSalt replaces the published DefaultSalt with a new random
+// salt before the persistence file key is derived, unless the salt was set
+// explicitly. A key already derived from the DefaultSalt is dropped to be
+// derived again from the password kept until Save() (see keepPassword()). Used
+// by Save() when creating a new file.
+func (k *Krypto431) randomizeDefaultSalt() error {
+	if k.saltSet {
+		return nil
+	}
+	defaultSalt, err := hex.DecodeString(DefaultSalt)
+	if err != nil {
+		return err
+	}
+	if k.persistenceKey != nil {
+		if k.password == nil || !bytes.Equal(k.pfkParameters.salt, defaultSalt) {
+			return nil
+		}
+	} else if k.salt == nil || !bytes.Equal(*k.salt, defaultSalt) {
+		return nil
+	}
+	if err := k.SetSaltFromString(GenerateSalt()); err != nil {
+		return err
+	}
+	if k.persistenceKey != nil {
+		WipeBytes(k.persistenceKey)
+		k.persistenceKey = nil
+	}
+	return nil
+}
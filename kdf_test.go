@@ -0,0 +1,112 @@
+package krypto431
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKrypto431_RederivePFK(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "pbkdf2.krypto431")
+	password := "correct horse battery staple"
+	k := New(WithPersistence(file), WithCallSign("SA6MWA"), WithPBKDF2(1000))
+	if err := k.SetPFKFromPassword(password); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.GenerateKeys(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if k.password != nil {
+		t.Error("Expected the password to be wiped after Save")
+	}
+
+	// An explicit salt is kept, even the DefaultSalt.
+	explicit := New(WithPersistence(filepath.Join(dir, "explicit.krypto431")), WithPBKDF2(1000), WithSaltString(DefaultSalt))
+	explicit.SetPassword(password)
+	if err := explicit.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if header, err := ReadPersistenceHeader(explicit.GetPersistence()); err != nil || hex.EncodeToString(header.Salt) != DefaultSalt {
+		t.Errorf("Expected the explicit DefaultSalt to be kept, got %+v, %v", header, err)
+	}
+
+	// A format version 1 header (without Argon2id parameters) is still read.
+	header, err := ReadPersistenceHeader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The published default salt is replaced when the file is created.
+	if hex.EncodeToString(header.Salt) == DefaultSalt {
+		t.Error("Expected a new file to get a random salt")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := data[len(header.fields())+keyCheckLength:]
+	v1 := *header
+	v1.Version = 1
+	if err := os.WriteFile(file, append(v1.bytes(*k.GetPFK()), encrypted...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if header, err := ReadPersistenceHeader(file); err != nil || header.Version != 1 || header.KDFString() != "PBKDF2 ITERATIONS=1000" {
+		t.Fatalf("Unexpected version 1 header %+v, %v", header, err)
+	}
+
+	// Upgrade to Argon2id with a new salt.
+	l := New(WithPersistence(file), WithArgon2id(1, 1024, 1))
+	if err := l.SetPFKFromPassword(password); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if l.PFKString() != "PBKDF2 ITERATIONS=1000" {
+		t.Errorf("Expected the key to be derived with the parameters of the file, got %s", l.PFKString())
+	}
+	if l.password != nil {
+		t.Error("Expected the password to be wiped after Load")
+	}
+	wrongPassword := []byte("incorrect horse battery staple")
+	if err := l.RederivePFK(&wrongPassword); !errors.Is(err, ErrWrongPFK) {
+		t.Errorf("Expected %v, got %v", ErrWrongPFK, err)
+	}
+	if err := l.RederivePFK(BytePtr([]byte(password))); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Save(); err != nil {
+		t.Fatal(err)
+	}
+	upgraded, err := ReadPersistenceHeader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded.Version != FormatVersion || upgraded.KDFString() != "ARGON2ID TIME=1 MEMORY=1MiB THREADS=1" || bytes.Equal(upgraded.Salt, header.Salt) {
+		t.Errorf("Unexpected header after RederivePFK: %+v", upgraded)
+	}
+	// The key is derived when the parameters of the file are known.
+	m := New(WithPersistence(file), WithPBKDF2(1000))
+	m.SetPassword(password)
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Keys) != 1 || m.PFKString() != "ARGON2ID TIME=1 MEMORY=1MiB THREADS=1" {
+		t.Errorf("Expected 1 key with an Argon2id derived PFK, got %d keys and %s", len(m.Keys), m.PFKString())
+	}
+
+	pfk := New(WithPFKString(GeneratePFK()))
+	if err := pfk.RederivePFK(BytePtr([]byte(password))); !errors.Is(err, ErrNoPassword) {
+		t.Errorf("Expected %v, got %v", ErrNoPassword, err)
+	}
+	invalid := New(WithArgon2id(0, 1024, 1))
+	if err := invalid.SetPFKFromPassword(password); !errors.Is(err, ErrInvalidKDFParameters) {
+		t.Errorf("Expected %v, got %v", ErrInvalidKDFParameters, err)
+	}
+}
@@ -9,11 +9,12 @@ import (
 
 	"github.com/sa6mwa/dtg"
 	"github.com/sa6mwa/krypto431/crand"
+	"github.com/sa6mwa/krypto431/diana"
 )
 
 func (k Key) GoString() string {
-	return fmt.Sprintf("Key{Id:%s Runes:\"%s\" Keepers:[%s] Created:%s Expires:%s Used:%t Compromised:%t Comment:\"%s\" instance:%p}",
-		k.IdString(), string(k.Runes), k.JoinKeepers(","), k.Created, k.Expires, k.Used, k.Compromised, k.CommentString(), k.instance)
+	return fmt.Sprintf("Key{Id:%s Runes:\"%s\" Keepers:[%s] Created:%s Expires:%s Used:%t Offset:%d Compromised:%t Comment:\"%s\" CodingScheme:%s Combiner:%s Book:%s Sheet:%d instance:%p}",
+		k.IdString(), string(k.Runes), k.JoinKeepers(","), k.Created, k.Expires, k.Used, k.Offset, k.Compromised, k.CommentString(), k.CodingScheme, k.Combiner, string(k.Book), k.Sheet, k.instance)
 }
 
 // ContainsKeyId checks if the Krypto431.Keys slice already contains Id and
@@ -42,23 +43,43 @@ func (k *Key) ContainsKeeper(keepers ...[]rune) bool {
 
 // NewKey generates a new key. The current implementation generates a random
 // group not yet in the Krypto431 construct. Keepers can be one call-sign per
-// variadic, comma-separated call-signs or a combination of both.
+// variadic, comma-separated call-signs or a combination of both. If the
+// instance has a master seed, the key is derived from it (see derive.go).
 func (k *Krypto431) NewKey(expire time.Time, keepers ...string) *Key {
+	k.lock()
+	defer k.unlock()
 	key := Key{
-		Id:          make([]rune, k.GroupSize),
-		Runes:       make([]rune, int(int(math.Ceil(float64(k.KeyLength)/float64(k.GroupSize)))*k.GroupSize)),
-		Used:        false,
-		Compromised: false,
-		instance:    k,
+		Id:           make([]rune, k.GroupSize),
+		Runes:        make([]rune, int(int(math.Ceil(float64(k.KeyLength)/float64(k.GroupSize)))*k.GroupSize)),
+		Used:         false,
+		Compromised:  false,
+		CodingScheme: k.CodingScheme,
+		instance:     k,
+	}
+	if key.CodingScheme == "" {
+		key.CodingScheme = DefaultCodingSchemeId
+	}
+	if combiner, err := k.GetCombiner(); err == nil {
+		key.Combiner = combiner.Name()
 	}
 	key.Created.Time = time.Now()
 	key.Expires.Time = expire
-	key.Keepers = VettedKeepers(keepers...)
+	// Nets are expanded to their members.
+	key.Keepers = k.ExpandRecipients(VettedKeepers(keepers...)...)
 	// If the instance's call-sign is among the keepers, remove it.
 	key.RemoveKeeper(k.CallSign)
+	// Keys of numeric coding schemes are digits, otherwise letters.
+	base, first := 26, rune('A')
+	if scheme, err := key.Scheme(); err == nil && scheme.IsNumeric() {
+		base, first = 10, rune('0')
+	}
+	intn := crand.Intn
 	for { // if we already have 26*26*26*26*26 keys, this is an infinite loop :)
+		if k.HasMasterSeed() {
+			intn = k.derivedIdIntn()
+		}
 		for i := range key.Id {
-			key.Id[i] = rune(crand.Intn(26)) + rune('A')
+			key.Id[i] = rune(intn(base)) + first
 		}
 		if !k.ContainsKeyId(&key.Id) {
 			break
@@ -70,9 +91,15 @@ func (k *Krypto431) NewKey(expire time.Time, keepers ...string) *Key {
 					fmt.Printf("key exists looping (%s line %d)\n", fn, line)
 		*/
 	}
+	if k.HasMasterSeed() {
+		// Derived keys are numbered by the seed counter of their id.
+		intn = k.derivedKeyIntn(k.SeedCounter-1, key.Id)
+		key.Comment = []rune(fmt.Sprintf("%s %d", DerivedKeyComment, k.SeedCounter-1))
+	}
 	for i := range key.Runes {
-		key.Runes[i] = rune(crand.Intn(26)) + rune('A')
+		key.Runes[i] = rune(intn(base)) + first
 	}
+	k.record(JournalGenerated, &key, nil, "")
 	k.Keys = append(k.Keys, key)
 	return &key
 }
@@ -80,6 +107,13 @@ func (k *Krypto431) NewKey(expire time.Time, keepers ...string) *Key {
 // DeleteKey removes one or more keys from the instance's Keys slice wiping the
 // key before deleting it. Returns number of keys deleted or error on failure.
 func (k *Krypto431) DeleteKey(keyIds ...[]rune) (int, error) {
+	k.lock()
+	defer k.unlock()
+	return k.deleteKey(keyIds...)
+}
+
+// deleteKey is DeleteKey() without locking the instance.
+func (k *Krypto431) deleteKey(keyIds ...[]rune) (int, error) {
 	// TODO: error-handling is a future improvement.
 	deleted := 0
 	if len(keyIds) == 0 {
@@ -88,6 +122,7 @@ func (k *Krypto431) DeleteKey(keyIds ...[]rune) (int, error) {
 	for x := range keyIds {
 		for i := range k.Keys {
 			if EqualRunesFold(&k.Keys[i].Id, &keyIds[x]) {
+				k.record(JournalDeleted, &k.Keys[i], nil, "")
 				k.Keys[i].Wipe()
 				k.Keys[i] = k.Keys[len(k.Keys)-1]
 				k.Keys = k.Keys[:len(k.Keys)-1]
@@ -118,20 +153,120 @@ func (k *Krypto431) DeleteKeysBySummaryString(summaryStrings ...string) (int, er
 	return deleted, nil
 }
 
+// KeyEdit describes changes to one or more keys, see EditKeys(). Nil fields
+// are left unchanged. Keepers are vetted as in NewKey() (one or more
+// comma-separated call-signs per string, the instance's call-sign is removed)
+// where a non-nil empty slice makes the key anonymous. Expires is a Date-Time
+// Group (DDHHMMZmmmYY).
+type KeyEdit struct {
+	Keepers     []string
+	Expires     *string
+	Comment     *string
+	Used        *bool
+	Compromised *bool
+}
+
+// vet validates the edit and returns the vetted keepers and expiry DTG.
+func (e *KeyEdit) vet() (keepers [][]rune, expires *dtg.DTG, err error) {
+	if e.Keepers != nil {
+		keepers = VettedKeepers(e.Keepers...)
+		for i := range keepers {
+			if len(keepers[i]) < MinimumCallSignLength {
+				return nil, nil, fmt.Errorf("%w: %s", ErrInvalidCallSign, string(keepers[i]))
+			}
+		}
+	}
+	if e.Expires != nil {
+		d, err := dtg.Parse(strings.TrimSpace(*e.Expires))
+		if err != nil {
+			return nil, nil, err
+		}
+		expires = &d
+	}
+	return keepers, expires, nil
+}
+
+// EditKeys applies edit to all keys where filter returns true. The edit is
+// validated before any key is changed. Returns the number of keys edited or
+// error if the edit is invalid. Call Save() to persist the changes.
+func (k *Krypto431) EditKeys(filter func(key *Key) bool, edit KeyEdit) (int, error) {
+	k.lock()
+	defer k.unlock()
+	keepers, expires, err := edit.vet()
+	if err != nil {
+		return 0, err
+	}
+	edited := 0
+	for i := range k.Keys {
+		if !filter(&k.Keys[i]) {
+			continue
+		}
+		key := &k.Keys[i]
+		if edit.Keepers != nil {
+			for x := range key.Keepers {
+				Wipe(&key.Keepers[x])
+			}
+			key.Keepers = make([][]rune, 0, len(keepers))
+			for x := range keepers {
+				key.Keepers = append(key.Keepers, RuneCopy(&keepers[x]))
+			}
+			key.RemoveKeeper(k.CallSign)
+		}
+		if expires != nil {
+			key.Expires = *expires
+		}
+		if edit.Comment != nil {
+			Wipe(&key.Comment)
+			key.Comment = []rune(strings.TrimSpace(*edit.Comment))
+		}
+		if edit.Used != nil && key.Used != *edit.Used {
+			key.Used = *edit.Used
+			if key.Used {
+				k.record(JournalUsed, key, nil, "EDITED")
+			} else {
+				k.record(JournalUnused, key, nil, "EDITED")
+			}
+		}
+		if edit.Compromised != nil && key.Compromised != *edit.Compromised {
+			key.Compromised = *edit.Compromised
+			if key.Compromised {
+				k.record(JournalCompromised, key, nil, "EDITED")
+			} else {
+				k.record(JournalUncompromised, key, nil, "EDITED")
+			}
+		}
+		edited++
+	}
+	for i := range keepers {
+		Wipe(&keepers[i])
+	}
+	return edited, nil
+}
+
+// EditKey applies edit to the key with keyId, see EditKeys(). Returns error if
+// the key is not found or the edit is invalid.
+func (k *Krypto431) EditKey(keyId []rune, edit KeyEdit) error {
+	id := []rune(strings.ToUpper(strings.TrimSpace(string(keyId))))
+	edited, err := k.EditKeys(func(key *Key) bool {
+		return EqualRunes(&key.Id, &id)
+	}, edit)
+	if err != nil {
+		return err
+	}
+	if edited == 0 {
+		return fmt.Errorf("%w: %s", ErrKeyNotFound, string(id))
+	}
+	return nil
+}
+
 // GenerateKeys creates n amount of keys. The expire argument is a Date-Time
 // Group when the key(s) is/are to expire (DDHHMMZmmmYY). If expire is nil, keys
 // will expire one year from current time. If no keepers are provided, keys will
-// be considered anonymous.
+// be considered anonymous. To generate a numbered key book, use GenerateBook().
 func (k *Krypto431) GenerateKeys(n int, expire *string, keepers ...string) error {
-	var expiryTime time.Time
-	if expire == nil {
-		expiryTime = time.Now().Add(365 * 24 * time.Hour)
-	} else {
-		d, err := dtg.Parse(*expire)
-		if err != nil {
-			return err
-		}
-		expiryTime = d.Time
+	expiryTime, err := expiryTimeOf(expire)
+	if err != nil {
+		return err
 	}
 	for i := 0; i < n; i++ {
 		k.NewKey(expiryTime, keepers...)
@@ -139,6 +274,19 @@ func (k *Krypto431) GenerateKeys(n int, expire *string, keepers ...string) error
 	return nil
 }
 
+// expiryTimeOf returns the time of the expire DTG or one year from current
+// time if expire is nil.
+func expiryTimeOf(expire *string) (time.Time, error) {
+	if expire == nil {
+		return time.Now().Add(365 * 24 * time.Hour), nil
+	}
+	d, err := dtg.Parse(*expire)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return d.Time, nil
+}
+
 // AddKeeper adds keeper(s) to the Keepers slice if not already there. Can be
 // chained.
 func (k *Key) AddKeeper(keepers ...[]rune) *Key {
@@ -180,6 +328,38 @@ func (k *Key) GetCallSign() []rune {
 	return k.instance.CallSign
 }
 
+// Scheme returns the coding scheme of the key. Keys without a CodingScheme
+// were created before coding schemes were introduced and use the default
+// scheme.
+func (k *Key) Scheme() (*CodingScheme, error) {
+	return GetCodingScheme(k.CodingScheme)
+}
+
+// GetCombiner returns the combiner (cipher table) of the key. Keys without a
+// Combiner use DIANA (or MOD10 if the key is numeric).
+func (k *Key) GetCombiner() (diana.Combiner, error) {
+	scheme, err := k.Scheme()
+	if err != nil {
+		return nil, err
+	}
+	combiner, err := getCombiner(k.Combiner, scheme)
+	if err != nil {
+		return nil, err
+	}
+	err = checkCombiner(scheme, combiner)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", k.IdString(), err)
+	}
+	return combiner, nil
+}
+
+// IsNumeric returns true if the key is a numeric key (digits 0-9) of a numeric
+// coding scheme.
+func (k *Key) IsNumeric() bool {
+	scheme, err := k.Scheme()
+	return err == nil && scheme.IsNumeric()
+}
+
 // KeyLength() returns the length of this key instance.
 func (k *Key) KeyLength() int {
 	return len(k.Runes)
@@ -234,12 +414,16 @@ func (k *Key) IsValid(d time.Duration) bool {
 	return time.Now().Add(d).Before(k.Expires.Time)
 }
 
-// Returns Yes if key is marked used, No if not. Optional rightSpacing pads the
+// Returns Yes if key is marked used, No if not or the offset and key length
+// (e.g 40/350) if the key is partially consumed. Optional rightSpacing pads the
 // output string with trailing spaces.
 func (k *Key) UsedString(rightSpacing ...int) string {
 	if k.Used {
 		return Words["Yes"]
 	}
+	if k.Offset > 0 {
+		return fmt.Sprintf("%d/%d", k.Offset, k.KeyLength())
+	}
 	return Words["No"]
 }
 
@@ -287,7 +471,7 @@ func (k *Krypto431) SummaryOfKeys(filter func(key *Key) bool) (header []rune, li
 
 	predictedColumnSizes := predictColumnSizesOfKeys(kp)
 
-	columnHeader := []string{"ID", "KEEPERS", "CREATED", "EXPIRES", "USED", "COMPROMISED", "COMMENT"}
+	columnHeader := []string{"ID", "KEEPERS", "CREATED", "EXPIRES", "USED", "COMPROMISED", "BOOK", "COMMENT"}
 	// Guard rail...
 	if len(predictedColumnSizes) != len(columnHeader) {
 		panic("wrong number of columns")
@@ -321,7 +505,8 @@ func (k *Krypto431) SummaryOfKeys(filter func(key *Key) bool) (header []rune, li
 			withPadding([]rune(kp[i].Expires.String()), predictedColumnSizes[3]+addSpace),
 			withPadding([]rune(kp[i].UsedString()), predictedColumnSizes[4]+addSpace),
 			withPadding([]rune(kp[i].CompromisedString()), predictedColumnSizes[5]+addSpace),
-			withPadding([]rune(kp[i].Comment), predictedColumnSizes[6]+addSpace))
+			withPadding([]rune(kp[i].SheetString()), predictedColumnSizes[6]+addSpace),
+			withPadding([]rune(kp[i].Comment), predictedColumnSizes[7]+addSpace))
 		var totalLineLength int
 		for x := range columns {
 			totalLineLength += len(columns[x])
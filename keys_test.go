@@ -1,6 +1,7 @@
 package krypto431
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -36,3 +37,60 @@ func TestGenerateKeys(t *testing.T) {
 		t.Logf("OK, here is key id %s: %s", string(key.Id), string(key.Runes))
 	}
 }
+
+func TestKrypto431_EditKeys(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"))
+	err := k.GenerateKeys(3, nil, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := "011200ZJAN30"
+	comment := "  Keeper typo fixed "
+	compromised := true
+	err = k.EditKey(k.Keys[1].Id, KeyEdit{
+		Keepers:     []string{"qj, sm5abc sa6mwa"},
+		Expires:     &expires,
+		Comment:     &comment,
+		Compromised: &compromised,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := k.Keys[1]
+	if key.JoinKeepers(",") != "QJ,SM5ABC" {
+		t.Errorf("Expected keepers QJ,SM5ABC, got %s", key.JoinKeepers(","))
+	}
+	if key.Expires.String() != expires {
+		t.Errorf("Expected expiry %s, got %s", expires, key.Expires)
+	}
+	if key.CommentString() != "Keeper typo fixed" || !key.Compromised || key.Used {
+		t.Errorf("Unexpected comment, compromised or used state: %#v", key)
+	}
+	if k.Keys[0].Compromised || k.Keys[2].Compromised {
+		t.Error("Expected only the edited key to change")
+	}
+	// Invalid input must not change any key.
+	invalid := "garbage"
+	if _, err := k.EditKeys(func(*Key) bool { return true }, KeyEdit{Expires: &invalid, Comment: &invalid}); err == nil {
+		t.Error("Expected invalid DTG to fail")
+	}
+	if _, err := k.EditKeys(func(*Key) bool { return true }, KeyEdit{Keepers: []string{"Q"}}); !errors.Is(err, ErrInvalidCallSign) {
+		t.Errorf("Expected %v, got %v", ErrInvalidCallSign, err)
+	}
+	for i := range k.Keys {
+		if k.Keys[i].CommentString() == invalid {
+			t.Fatal("Invalid edit changed a key")
+		}
+	}
+	// Empty keepers make the keys anonymous.
+	edited, err := k.EditKeys(func(*Key) bool { return true }, KeyEdit{Keepers: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited != 3 || len(k.Keys[0].Keepers) != 0 {
+		t.Errorf("Expected 3 anonymous keys, edited %d", edited)
+	}
+	if err := k.EditKey([]rune("XXXXX"), KeyEdit{}); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected %v, got %v", ErrKeyNotFound, err)
+	}
+}
@@ -0,0 +1,309 @@
+package krypto431
+
+// Keys printed with Key.String() (KeysAsText, KeysTextFile or KeysPDF) can be
+// read back from text, for example a paper pad typed in by hand or run
+// through OCR. Each key starts with the header line...
+//
+//	ABCDE      / KEEPERS: QJ / EXPIRES: ... / CREATED: ... / SCHEME: SV1/DIANA / USED: [ ]
+//
+// ...optionally preceded by the book line (BOOK QJ01 SHEET 1 OF 20) and
+// followed by the key groups until the coding legend or the next key. Groups
+// are the words at the start of each line, the cipher table printed to the
+// right of the groups (separated by three or more spaces) and lines starting
+// with a space are ignored. Every group is validated against the group size
+// and the alphabet of the key (A-Z or 0-9 for numeric keys), each problem is
+// reported with its line and group number and keys with problems are not
+// imported.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sa6mwa/dtg"
+)
+
+var (
+	ErrKeyText             = errors.New("invalid key printout")
+	ErrInvalidKeyCharacter = errors.New("invalid character")
+	ErrInvalidGroupLength  = errors.New("wrong group length")
+	ErrKeyExists           = errors.New("key already exists")
+)
+
+var (
+	keyTextBookRegexp     *regexp.Regexp = regexp.MustCompile(`^\s*BOOK\s+([A-Z0-9]+)\s+SHEET\s+(\d+)(?:\s+OF\s+(\d+))?`)
+	keyTextKeepersRegexp  *regexp.Regexp = regexp.MustCompile(`KEEPERS:\s*([^/]*)`)
+	keyTextExpiresRegexp  *regexp.Regexp = regexp.MustCompile(`EXPIRES:\s*(\S+)`)
+	keyTextCreatedRegexp  *regexp.Regexp = regexp.MustCompile(`CREATED:\s*(\S+)`)
+	keyTextSchemeRegexp   *regexp.Regexp = regexp.MustCompile(`SCHEME:\s*([A-Z0-9]+)(?:/([A-Z0-9]+))?`)
+	keyTextUsedRegexp     *regexp.Regexp = regexp.MustCompile(`USED:\s*\[(.?)\]`)
+	keyTextTableGapRegexp *regexp.Regexp = regexp.MustCompile(`\s{3,}|\t`)
+)
+
+// KeyTextError describes a problem in a key printout. Line is counted from 1,
+// Group is the group of the key counted from 1 (0 if the problem is not in a
+// group). errors.Is(err, ErrKeyText) is true for a KeyTextError.
+type KeyTextError struct {
+	Line  int
+	KeyId string
+	Group int
+	Text  string
+	Err   error
+}
+
+func (e *KeyTextError) Error() string {
+	var where []string
+	if e.Line > 0 {
+		where = append(where, fmt.Sprintf("line %d", e.Line))
+	}
+	if e.KeyId != "" {
+		where = append(where, "key "+e.KeyId)
+	}
+	if e.Group > 0 {
+		where = append(where, fmt.Sprintf("group %d (%s)", e.Group, e.Text))
+	}
+	if len(where) == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", strings.Join(where, ", "), e.Err)
+}
+
+func (e *KeyTextError) Unwrap() error {
+	return e.Err
+}
+
+func (e *KeyTextError) Is(target error) bool {
+	return target == ErrKeyText
+}
+
+// parsedKey is a key being read from a printout.
+type parsedKey struct {
+	key    Key
+	line   int
+	sheets int
+	errs   []*KeyTextError
+	done   bool
+}
+
+func (p *parsedKey) fail(line int, group int, text string, err error) {
+	p.errs = append(p.errs, &KeyTextError{Line: line, KeyId: string(p.key.Id), Group: group, Text: text, Err: err})
+}
+
+// parseHeader populates the key from the header line of a printed key.
+func (p *parsedKey) parseHeader(line string) {
+	fields := strings.Fields(line)
+	if len(fields) > 0 && !strings.HasPrefix(fields[0], "/") {
+		p.key.Id = []rune(fields[0])
+	}
+	if m := keyTextKeepersRegexp.FindStringSubmatch(line); m != nil {
+		keepers := strings.TrimSpace(m[1])
+		if keepers != string(NilRunes) {
+			p.key.Keepers = VettedKeepers(keepers)
+		}
+	} else {
+		p.fail(p.line, 0, "", fmt.Errorf("%w: missing KEEPERS in header", ErrKeyText))
+	}
+	for _, f := range []struct {
+		r    *regexp.Regexp
+		name string
+		d    *dtg.DTG
+	}{
+		{keyTextExpiresRegexp, "EXPIRES", &p.key.Expires},
+		{keyTextCreatedRegexp, "CREATED", &p.key.Created},
+	} {
+		m := f.r.FindStringSubmatch(line)
+		if m == nil {
+			p.fail(p.line, 0, "", fmt.Errorf("%w: missing %s in header", ErrKeyText, f.name))
+			continue
+		}
+		d, err := dtg.Parse(m[1])
+		if err != nil {
+			p.fail(p.line, 0, "", fmt.Errorf("%w: %s %s: %v", ErrKeyText, f.name, m[1], err))
+			continue
+		}
+		*f.d = d
+	}
+	if m := keyTextSchemeRegexp.FindStringSubmatch(line); m != nil {
+		p.key.CodingScheme = m[1]
+		p.key.Combiner = m[2]
+	}
+	if m := keyTextUsedRegexp.FindStringSubmatch(line); m != nil {
+		p.key.Used = strings.TrimSpace(m[1]) != ""
+	}
+}
+
+// parseGroups validates and appends the groups of a line to the key.
+func (p *parsedKey) parseGroups(lineNumber int, line string, groupSize int, alphabet string) {
+	if loc := keyTextTableGapRegexp.FindStringIndex(line); loc != nil {
+		line = line[:loc[0]]
+	}
+	for _, group := range strings.Fields(line) {
+		number := len(p.key.Runes)/groupSize + 1
+		runes := []rune(group)
+		valid := true
+		if len(runes) != groupSize {
+			p.fail(lineNumber, number, group, fmt.Errorf("%w %d, expected %d", ErrInvalidGroupLength, len(runes), groupSize))
+			valid = false
+		}
+		for _, r := range runes {
+			if !strings.ContainsRune(alphabet, r) {
+				p.fail(lineNumber, number, group, fmt.Errorf("%w %q, expected %c-%c", ErrInvalidKeyCharacter, r, alphabet[0], alphabet[len(alphabet)-1]))
+				valid = false
+			}
+		}
+		if !valid {
+			// Keep the group count right, the key will not be imported anyway.
+			runes = []rune(strings.Repeat("?", groupSize))
+		}
+		p.key.Runes = append(p.key.Runes, runes...)
+	}
+}
+
+// alphabet returns the characters of the key (and key id) according to the
+// scheme in the header.
+func (p *parsedKey) alphabet() string {
+	if scheme, err := GetCodingScheme(p.key.CodingScheme); err == nil && scheme.IsNumeric() {
+		return "0123456789"
+	}
+	return "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+}
+
+// ParseKeysText parses keys in the printout format of Key.String(). Returns
+// all keys found (with the instance's group size), the books of the keys and a
+// KeyTextError for every problem. Keys with problems are not returned. Nothing
+// is added to the instance, see ImportKeysText().
+func (k *Krypto431) ParseKeysText(text string) ([]Key, []Book, []*KeyTextError) {
+	var keys []Key
+	var books []Book
+	var errs []*KeyTextError
+	var current *parsedKey
+	var book []string
+	seen := make(map[string]bool)
+	flush := func() {
+		if current == nil {
+			return
+		}
+		p := current
+		current = nil
+		id := string(p.key.Id)
+		if len(p.key.Id) != k.GroupSize {
+			p.fail(p.line, 0, "", fmt.Errorf("%w: key id %q is not %d characters long", ErrKeyText, id, k.GroupSize))
+		} else if strings.Trim(id, p.alphabet()) != "" {
+			p.fail(p.line, 0, "", fmt.Errorf("%w in key id %q", ErrInvalidKeyCharacter, id))
+		}
+		if len(p.key.Runes) == 0 {
+			p.fail(p.line, 0, "", fmt.Errorf("%w: key has no groups", ErrKeyText))
+		}
+		if seen[id] {
+			p.fail(p.line, 0, "", fmt.Errorf("%w: key %s appears more than once", ErrKeyText, id))
+		}
+		seen[id] = true
+		if _, err := p.key.GetCombiner(); err != nil {
+			p.fail(p.line, 0, "", err)
+		}
+		if len(p.errs) > 0 {
+			errs = append(errs, p.errs...)
+			return
+		}
+		keys = append(keys, p.key)
+		if len(p.key.Book) > 0 {
+			for i := range books {
+				if EqualRunes(&books[i].Id, &p.key.Book) {
+					return
+				}
+			}
+			book := Book{
+				Id:      RuneCopy(&p.key.Book),
+				Sheets:  p.sheets,
+				Created: p.key.Created,
+				Expires: p.key.Expires,
+			}
+			for i := range p.key.Keepers {
+				book.Keepers = append(book.Keepers, RuneCopy(&p.key.Keepers[i]))
+			}
+			books = append(books, book)
+		}
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	for i, line := range strings.Split(text, "\n") {
+		lineNumber := i + 1
+		upper := strings.ToUpper(line)
+		trimmed := strings.TrimSpace(upper)
+		switch {
+		case keyTextBookRegexp.MatchString(upper):
+			flush()
+			book = keyTextBookRegexp.FindStringSubmatch(upper)
+		case strings.Contains(upper, "CREATED:") && strings.Contains(upper, "USED:"):
+			flush()
+			current = &parsedKey{line: lineNumber}
+			current.key.instance = k
+			current.parseHeader(upper)
+			if book != nil {
+				current.key.Book = []rune(book[1])
+				current.key.Sheet, _ = strconv.Atoi(book[2])
+				current.sheets, _ = strconv.Atoi(book[3])
+				book = nil
+			}
+		case current == nil || current.done:
+			continue
+		case strings.HasPrefix(trimmed, "CODING LEGEND"):
+			current.done = true
+		case trimmed == "" || strings.Trim(trimmed, "-_") == "":
+			continue
+		case strings.HasPrefix(line, " "), strings.HasPrefix(line, "\t"):
+			// Cipher table only.
+			continue
+		default:
+			current.parseGroups(lineNumber, upper, k.GroupSize, current.alphabet())
+		}
+	}
+	flush()
+	return keys, books, errs
+}
+
+// ImportKeysText reads keys in the printout format of Key.String() from r
+// (until EOF) and adds the keys without problems to the instance. The
+// instance's call-sign is removed from the keepers and keepers (e.g the
+// station that issued the keys) are added to each key. Books of the keys are
+// created if they do not exist. Returns the number of keys imported and a
+// KeyTextError for every problem (keys with problems or keys that already
+// exist are not imported). Call Save() to persist the keys.
+func (k *Krypto431) ImportKeysText(r io.Reader, keepers ...string) (int, []*KeyTextError, error) {
+	k.lock()
+	defer k.unlock()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	keys, books, errs := k.ParseKeysText(string(b))
+	additionalKeepers := VettedKeepers(keepers...)
+	imported := 0
+	for i := range keys {
+		key := keys[i]
+		if k.ContainsKeyId(&key.Id) {
+			errs = append(errs, &KeyTextError{KeyId: key.IdString(), Err: ErrKeyExists})
+			continue
+		}
+		for x := range additionalKeepers {
+			key.AddKeeper(RuneCopy(&additionalKeepers[x]))
+		}
+		key.RemoveKeeper(k.CallSign).SetInstance(k)
+		k.Keys = append(k.Keys, key)
+		k.record(JournalImported, &key, nil, "TEXT")
+		imported++
+		for x := range books {
+			if !EqualRunes(&books[x].Id, &key.Book) {
+				continue
+			}
+			if _, err := k.GetBook(key.Book); err != nil {
+				book := copyBook(&books[x])
+				book.AddKeeper(additionalKeepers...).RemoveKeeper(k.CallSign)
+				k.Books = append(k.Books, book)
+			}
+		}
+	}
+	return imported, errs, nil
+}
@@ -0,0 +1,66 @@
+package krypto431
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKrypto431_ParseKeysText(t *testing.T) {
+	issuer := New(WithCallSign("SA6MWA"), WithKeyLength(50))
+	if err := issuer.GenerateKeys(1, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.GenerateBook("QJ01", 2, nil, "QJ,SM5ABC"); err != nil {
+		t.Fatal(err)
+	}
+	issuer.Keys[0].Used = true
+	text := issuer.KeysAsText(func(*Key) bool { return true })
+
+	receiver := New(WithCallSign("QJ"))
+	n, errs, err := receiver.ImportKeysText(strings.NewReader(text), "SA6MWA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || len(errs) != 0 {
+		t.Fatalf("Expected 3 keys without errors, got %d keys and %v", n, errs)
+	}
+	for i := range issuer.Keys {
+		want, got := issuer.Keys[i], receiver.Keys[i]
+		if string(want.Id) != string(got.Id) || string(want.Runes) != string(got.Runes) {
+			t.Errorf("Key %s was not read back correctly", want.IdString())
+		}
+		if want.Expires.String() != got.Expires.String() || want.Used != got.Used || got.GetInstance() != &receiver {
+			t.Errorf("Unexpected key %#v", got)
+		}
+		if got.CodingScheme != DefaultCodingSchemeId || got.Combiner != "DIANA" {
+			t.Errorf("Unexpected scheme %s/%s", got.CodingScheme, got.Combiner)
+		}
+	}
+	if len(receiver.Keys[1].Keepers) != 2 || !receiver.Keys[1].ContainsKeeper([]rune("SM5ABC"), []rune("SA6MWA")) {
+		t.Errorf("Expected keepers SM5ABC and SA6MWA, got %s", receiver.Keys[1].JoinKeepers(","))
+	}
+	if s := receiver.Keys[2].SheetString(); s != "QJ01 2/2" {
+		t.Errorf("Expected QJ01 2/2, got %s", s)
+	}
+	// Importing again does not duplicate keys.
+	if n, errs, _ := receiver.ImportKeysText(strings.NewReader(text)); n != 0 || len(errs) != 3 || !errors.Is(errs[0], ErrKeyExists) {
+		t.Errorf("Expected 3 %v errors, got %d keys and %v", ErrKeyExists, n, errs)
+	}
+
+	// Typos are reported with line and group, the key is not imported.
+	lines := strings.Split(issuer.Keys[0].String(), LineBreak)
+	groups := strings.Fields(lines[4])
+	lines[4] = strings.Replace(lines[4], groups[1], "0"+groups[1][1:], 1)
+	lines[4] = strings.Replace(lines[4], groups[2], groups[2][1:], 1)
+	_, _, errs = receiver.ParseKeysText(strings.Join(lines, "\n"))
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %v", errs)
+	}
+	if errs[0].Line != 5 || errs[0].Group != 2 || !errors.Is(errs[0], ErrInvalidKeyCharacter) || !errors.Is(errs[0], ErrKeyText) {
+		t.Errorf("Unexpected error %v", errs[0])
+	}
+	if errs[1].Line != 5 || errs[1].Group != 3 || !errors.Is(errs[1], ErrInvalidGroupLength) {
+		t.Errorf("Unexpected error %v", errs[1])
+	}
+}
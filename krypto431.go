@@ -12,6 +12,7 @@ import (
 
 	"github.com/sa6mwa/dtg"
 	"github.com/sa6mwa/krypto431/crand"
+	"github.com/sa6mwa/krypto431/diana"
 )
 
 //go:embed VERSION
@@ -48,24 +49,28 @@ var (
 )
 
 var (
-	ErrNilPointer         = errors.New("received a nil pointer")
-	ErrCipherTextTooShort = errors.New("message cipher text is too short to decipher")
-	ErrNoKey              = errors.New("message has an invalid or no key")
-	ErrKeyNotFound        = errors.New("key not found")
-	ErrInvalidCoding      = errors.New("invalid character in encoded text (must be between A-Z)")
-	ErrInvalidControlChar = errors.New("invalid control character")
-	ErrTableTooShort      = errors.New("out-of-bounds, character table is too short")
-	ErrUnsupportedTable   = errors.New("character table not supported")
-	ErrOutOfKeys          = errors.New("can not encipher multi-key message, unable to find additional key(s)")
-	ErrNoCallSign         = errors.New("need to specify your call-sign")
-	ErrInvalidCallSign    = fmt.Errorf("invalid call-sign, should be at least %d characters long", MinimumCallSignLength)
-	ErrNoPersistence      = errors.New("missing file name for persisting keys, messages and settings")
-	ErrInvalidGroupSize   = errors.New("group size must be 1 or longer")
-	ErrKeyTooShort        = fmt.Errorf("key length must be %d characters or longer", MinimumSupportedKeyLength)
-	ErrTooNarrow          = fmt.Errorf("column width must be at least %d characters wide", MinimumColumnWidth)
-	ErrKeyColumnsTooShort = errors.New("key column width less than group size")
-	ErrFormatting         = errors.New("formatting error")
-	ErrNotCipherText      = errors.New("plaintext not identified as ciphertext")
+	ErrNilPointer          = errors.New("received a nil pointer")
+	ErrCipherTextTooShort  = errors.New("message cipher text is too short to decipher")
+	ErrNoKey               = errors.New("message has an invalid or no key")
+	ErrKeyNotFound         = errors.New("key not found")
+	ErrInvalidCoding       = errors.New("invalid character in encoded text (must be between A-Z, or 0-9 if numeric)")
+	ErrInvalidControlChar  = errors.New("invalid control character")
+	ErrTableTooShort       = errors.New("out-of-bounds, character table is too short")
+	ErrUnsupportedTable    = errors.New("character table not supported")
+	ErrInvalidRune         = errors.New("invalid rune, can not be encoded as UTF-8")
+	ErrIncompleteByte      = errors.New("binary mode ended in the middle of a byte")
+	ErrIncompleteKeyChange = errors.New("encoded text ended in the middle of a key change")
+	ErrOutOfKeys           = errors.New("can not encipher multi-key message, unable to find additional key(s)")
+	ErrNoCallSign          = errors.New("need to specify your call-sign")
+	ErrInvalidCallSign     = fmt.Errorf("invalid call-sign, should be at least %d characters long", MinimumCallSignLength)
+	ErrNoPersistence       = errors.New("missing file name for persisting keys, messages and settings")
+	ErrInvalidGroupSize    = errors.New("group size must be 1 or longer")
+	ErrKeyTooShort         = fmt.Errorf("key length must be %d characters or longer", MinimumSupportedKeyLength)
+	ErrTooNarrow           = fmt.Errorf("column width must be at least %d characters wide", MinimumColumnWidth)
+	ErrKeyColumnsTooShort  = errors.New("key column width less than group size")
+	ErrFormatting          = errors.New("formatting error")
+	ErrNotCipherText       = errors.New("plaintext not identified as ciphertext")
+	ErrCombinerMismatch    = errors.New("combiner does not match coding scheme")
 )
 
 var (
@@ -93,20 +98,45 @@ type GroupFormatter interface {
 // configuration items. CallSign is mandatory (something identifying yourself in
 // message handling). It will be converted to upper case. Mutex and persistance
 // file (persistence) are not exported meaning values will not be persisted to
-// disk.
+// disk. The mutex guards changes to keys, books, nets and messages as well as
+// Load() and Save() (see lock.go).
 type Krypto431 struct {
 	mx                            *sync.Mutex
 	persistence                   string
 	persistenceKey                *[]byte
 	salt                          *[]byte
+	saltSet                       bool
+	password                      *[]byte
+	kdf                           kdfParameters
+	pfkParameters                 kdfParameters
 	overwritePersistenceIfExists  bool
+	backups                       int
+	journal                       bool
+	pending                       []JournalEntry
+	lockFile                      *os.File
+	checksum                      []byte
 	interactive                   bool
 	overwriteExistingKeysOnImport bool
+	tolerantDecipher              bool
 	GroupSize                     int
 	KeyLength                     int
 	Columns                       int
 	KeyColumns                    int
+	CodingScheme                  string
+	Combiner                      string
+	IntegrityCheck                bool
+	PartialKeys                   bool
+	AllowExpiredKeys              bool
+	KeyReserve                    int
+	RolloverDays                  int
+	MasterSeed                    []rune
+	SeedCounter                   int
+	JournalKey                    []byte
+	JournalSequence               uint64
+	JournalHead                   []byte
 	Keys                          []Key
+	Books                         []Book
+	Nets                          []Net
 	Messages                      []Message
 	CallSign                      []rune
 }
@@ -114,16 +144,28 @@ type Krypto431 struct {
 // Key struct holds a key. Keepers is a list of call-signs or other identifiers
 // that have access to this key (and can use it for encryption/decryption). The
 // proper procedure is to share the key with it's respective keeper(s).
+// CodingScheme is the Id of the coding scheme (character tables) messages
+// enciphered with this key are coded with, empty means the default scheme.
+// Combiner is the name of the diana.Combiner (cipher table) used with this
+// key, empty means DIANA (or MOD10 for numeric keys). Offset is the first
+// un-used position of a partially consumed key (see partial.go). Book is the
+// id of the key book the key is sheet number Sheet of (see book.go), empty for
+// loose keys.
 type Key struct {
-	Id          []rune
-	Runes       []rune
-	Keepers     [][]rune
-	Created     dtg.DTG
-	Expires     dtg.DTG
-	Used        bool
-	Compromised bool
-	Comment     []rune
-	instance    *Krypto431
+	Id           []rune
+	Runes        []rune
+	Keepers      [][]rune
+	Created      dtg.DTG
+	Expires      dtg.DTG
+	Used         bool
+	Offset       int
+	Compromised  bool
+	Comment      []rune
+	CodingScheme string
+	Combiner     string
+	Book         []rune
+	Sheet        int
+	instance     *Krypto431
 }
 
 // Message holds plaintext and ciphertext. To encipher, you need to populate the
@@ -149,23 +191,25 @@ type Message struct {
 	Binary     []byte
 	CipherText []rune
 	Radiogram  []rune // Raw radiogram
+	Collective bool   // Sent to the collective (C), see net.go
 	instance   *Krypto431
 }
 
-// A chunk is internal to the Encipher function and is either the complete
-// PlainText encoded or - if the message is too long for the key - part of the
-// PlainText where all but the last chunk ends in a key change. Each chunk is to
-// be enciphered with a key allowing to chain multiple keys for longer messages.
-type chunk struct {
-	encodedText []rune
-	key         *Key
+// A Segment is either the complete PlainText (and Binary) encoded or - if the
+// message is too long for the key - part of the encoded message where all but
+// the last segment ends in a key change. Each segment is to be enciphered with
+// the key in KeyId allowing to chain multiple keys for longer messages.
+// Segments are produced by Encode().
+type Segment struct {
+	KeyId       []rune
+	EncodedText []rune
 }
 
-// Returns an initialized chunk (groupSize is usually msg.instance.GroupSize).
-func newChunk(groupSize int) chunk {
-	return chunk{
-		encodedText: make([]rune, 0, DefaultEncodedTextCapacity),
-		key:         nil,
+// Returns an initialized segment.
+func newSegment(keyId []rune) Segment {
+	return Segment{
+		KeyId:       keyId,
+		EncodedText: make([]rune, 0, DefaultEncodedTextCapacity),
 	}
 }
 
@@ -339,7 +383,7 @@ func (m *Message) Wipe() {
 	}
 }
 
-// RandomWipe assigned method for Text wipes PlainText, CipherText
+// RandomWipe assigned method for Text wipes PlainText, Binary, CipherText
 // and KeyId fields.
 func (m *Message) RandomWipe() {
 	// wipe PlainText
@@ -350,6 +394,8 @@ func (m *Message) RandomWipe() {
 		}
 	}
 	m.PlainText = nil
+	// wipe Binary
+	RandomWipeBytes(&m.Binary)
 	// wipe CipherText
 	written, err = crand.ReadRunes(m.CipherText)
 	if err != nil || written != len(m.CipherText) {
@@ -368,14 +414,16 @@ func (m *Message) RandomWipe() {
 	m.KeyId = nil
 }
 
-// ZeroWipe assigned method for PlainText writes zeroes to Text and EncodedText
-// fields.
+// ZeroWipe assigned method for PlainText writes zeroes to Text, Binary and
+// EncodedText fields.
 func (m *Message) ZeroWipe() {
 	// wipe PlainText
 	for i := 0; i < len(m.PlainText); i++ {
 		m.PlainText[i] = 0
 	}
 	m.PlainText = nil
+	// wipe Binary
+	ZeroWipeBytes(&m.Binary)
 	// wipe CipherText
 	for i := 0; i < len(m.CipherText); i++ {
 		m.CipherText[i] = 0
@@ -388,15 +436,15 @@ func (m *Message) ZeroWipe() {
 	m.KeyId = nil
 }
 
-// Wipe overwrites a chunk with either random runes or zeroes.
-func (c *chunk) Wipe() error {
+// Wipe overwrites a segment with either random runes or zeroes.
+func (s *Segment) Wipe() error {
 	if useCrandWipe {
-		err := c.RandomWipe()
+		err := s.RandomWipe()
 		if err != nil {
 			return err
 		}
 	} else {
-		err := c.ZeroWipe()
+		err := s.ZeroWipe()
 		if err != nil {
 			return err
 		}
@@ -404,10 +452,9 @@ func (c *chunk) Wipe() error {
 	return nil
 }
 
-// Chunk RandomWipe overwrites chunk with random runes.
-func (c *chunk) RandomWipe() error {
-	c.key = nil
-	runeSlices := []*[]rune{&c.encodedText}
+// Segment RandomWipe overwrites segment with random runes.
+func (s *Segment) RandomWipe() error {
+	runeSlices := []*[]rune{&s.EncodedText, &s.KeyId}
 	for i := range runeSlices {
 		written, err := crand.ReadRunes(*runeSlices[i])
 		if err != nil || written != len(*runeSlices[i]) {
@@ -425,16 +472,26 @@ func (c *chunk) RandomWipe() error {
 	return nil
 }
 
-// Chunk ZeroWipe zeroes a chunk
-func (c *chunk) ZeroWipe() error {
-	for i := 0; i < len(c.encodedText); i++ {
-		c.encodedText[i] = 0
+// Segment ZeroWipe zeroes a segment
+func (s *Segment) ZeroWipe() error {
+	for i := 0; i < len(s.EncodedText); i++ {
+		s.EncodedText[i] = 0
 	}
-	c.encodedText = nil
-	c.key = nil
+	s.EncodedText = nil
+	for i := 0; i < len(s.KeyId); i++ {
+		s.KeyId[i] = 0
+	}
+	s.KeyId = nil
 	return nil
 }
 
+// WipeSegments wipes all segments in a slice (e.g returned from Encode()).
+func WipeSegments(segments []Segment) {
+	for i := range segments {
+		segments[i].Wipe()
+	}
+}
+
 // New creates a new Krypto431 instance.
 func New(opts ...Option) Krypto431 {
 	instance := Krypto431{
@@ -442,12 +499,16 @@ func New(opts ...Option) Krypto431 {
 		persistenceKey:                nil,
 		salt:                          nil,
 		overwritePersistenceIfExists:  false,
+		backups:                       DefaultBackups,
+		journal:                       true,
 		interactive:                   false,
 		overwriteExistingKeysOnImport: false,
 		GroupSize:                     DefaultGroupSize,
 		KeyLength:                     DefaultKeyLength,
 		Columns:                       DefaultColumns,
 		KeyColumns:                    DefaultKeyColumns,
+		CodingScheme:                  DefaultCodingSchemeId,
+		kdf:                           defaultKDF(),
 		Keys:                          make([]Key, 0, DefaultKeyCapacity),
 		Messages:                      make([]Message, 0, DefaultMessageCapacity),
 	}
@@ -514,10 +575,12 @@ func WithSalt(salt *[]byte) Option {
 	if salt == nil || len(*salt) < 32 {
 		return func(k *Krypto431) {
 			k.salt = nil
+			k.saltSet = true
 		}
 	}
 	return func(k *Krypto431) {
 		k.salt = salt
+		k.saltSet = true
 	}
 }
 
@@ -531,10 +594,12 @@ func WithSaltString(salt string) Option {
 	if err != nil || len(bsalt) < MinimumSaltLength {
 		return func(k *Krypto431) {
 			k.salt = nil
+			k.saltSet = true
 		}
 	}
 	return func(k *Krypto431) {
 		k.salt = &bsalt
+		k.saltSet = true
 	}
 }
 
@@ -544,6 +609,8 @@ func WithCallSign(cs string) Option {
 	}
 }
 
+// WithMutex sets the mutex guarding the instance (see lock.go), for example to
+// share one mutex between instances.
 func WithMutex(mu *sync.Mutex) Option {
 	return func(k *Krypto431) {
 		k.mx = mu
@@ -569,6 +636,49 @@ func WithKeyColumns(n int) Option {
 		k.KeyColumns = n
 	}
 }
+
+// WithCodingScheme sets the Id of the coding scheme (see CodingScheme) new
+// keys are generated with. Use Assert() to validate that the scheme exists.
+func WithCodingScheme(id string) Option {
+	return func(k *Krypto431) {
+		k.CodingScheme = strings.ToUpper(strings.TrimSpace(id))
+	}
+}
+
+// WithCombiner sets the name of the diana.Combiner (e.g DIANA, VIGENERE,
+// BEAUFORT or VBEAUFORT) new keys are generated with. An empty name means the
+// default combiner of the coding scheme (DIANA or MOD10 for numeric schemes).
+// Use Assert() to validate the combiner.
+func WithCombiner(name string) Option {
+	return func(k *Krypto431) {
+		k.Combiner = strings.ToUpper(strings.TrimSpace(name))
+	}
+}
+
+// WithIntegrityCheck enables or disables check groups in enciphered messages
+// (see integrity.go). All stations on a circuit must use the same setting.
+func WithIntegrityCheck(enabled bool) Option {
+	return func(k *Krypto431) {
+		k.IntegrityCheck = enabled
+	}
+}
+
+// WithPartialKeys enables or disables partial key consumption, see
+// partial.go.
+func WithPartialKeys(enabled bool) Option {
+	return func(k *Krypto431) {
+		k.PartialKeys = enabled
+	}
+}
+
+// WithTolerantDecipher makes TryDecipherPlainText() decipher with
+// DecipherTolerant() instead of Decipher(), suspect groups are marked in the
+// plain text and reported as a warning on stderr.
+func WithTolerantDecipher(b bool) Option {
+	return func(k *Krypto431) {
+		k.tolerantDecipher = b
+	}
+}
 func WithPersistence(savefile string) Option {
 	return func(k *Krypto431) {
 		k.persistence = savefile
@@ -610,9 +720,56 @@ func (k *Krypto431) Assert() error {
 	if k.KeyColumns < k.GroupSize {
 		return ErrKeyColumnsTooShort
 	}
+	if err := k.assertMasterSeed(); err != nil {
+		return err
+	}
+	scheme, err := k.Scheme()
+	if err != nil {
+		return err
+	}
+	combiner, err := k.GetCombiner()
+	if err != nil {
+		return err
+	}
+	return checkCombiner(scheme, combiner)
+}
+
+// GetCombiner returns the instance's combiner (used for new keys), see
+// WithCombiner().
+func (k *Krypto431) GetCombiner() (diana.Combiner, error) {
+	scheme, err := k.Scheme()
+	if err != nil {
+		return nil, err
+	}
+	return getCombiner(k.Combiner, scheme)
+}
+
+// getCombiner returns the named combiner or the default combiner of the
+// coding scheme if name is empty.
+func getCombiner(name string, scheme *CodingScheme) (diana.Combiner, error) {
+	if name == "" && scheme.IsNumeric() {
+		name = diana.Mod10
+	}
+	return diana.GetCombiner(name)
+}
+
+// checkCombiner returns an error if the combiner can not be used with the
+// coding scheme (numeric schemes need a numeric combiner and vice versa).
+func checkCombiner(scheme *CodingScheme, combiner diana.Combiner) error {
+	numeric := combiner.Alphabet() == "0123456789"
+	if numeric != scheme.IsNumeric() {
+		return fmt.Errorf("%w: %s can not be used with coding scheme %s", ErrCombinerMismatch, combiner.Name(), scheme.Id)
+	}
 	return nil
 }
 
+// Scheme returns the instance's coding scheme (used for new keys). An empty
+// CodingScheme field (e.g a persistence file saved before coding schemes were
+// introduced) returns the default scheme.
+func (k *Krypto431) Scheme() (*CodingScheme, error) {
+	return GetCodingScheme(k.CodingScheme)
+}
+
 // SetInteractive is provided to set the interactive non-exported field in an
 // instance (true=on, false=off).
 func (k *Krypto431) SetInteractive(state bool) *Krypto431 {
@@ -654,8 +811,10 @@ func (k *Krypto431) CallSignString() string {
 	return string(k.CallSign)
 }
 
-// Close is an alias for Krypto431.Wipe()
+// Close releases the lock on the persistence file (see Lock()) and wipes the
+// instance (see Krypto431.Wipe()).
 func (k *Krypto431) Close() {
+	k.Unlock()
 	k.Wipe()
 }
 
@@ -667,16 +826,30 @@ func (k *Krypto431) Close() {
 // when using the methods to read keys and ciphertext from database, file or
 // stdin when done processing them.
 func (k *Krypto431) Wipe() {
+	k.lock()
+	defer k.unlock()
 	for i := range k.Keys {
 		k.Keys[i].Wipe()
 	}
 	k.Keys = nil
+	for i := range k.Books {
+		k.Books[i].Wipe()
+	}
+	k.Books = nil
+	for i := range k.Nets {
+		k.Nets[i].Wipe()
+	}
+	k.Nets = nil
 	for i := range k.Messages {
 		k.Messages[i].Wipe()
 	}
 	k.Messages = nil
+	Wipe(&k.MasterSeed)
+	WipeBytes(&k.JournalKey)
+	k.pending = nil
 	// wipe persistenceKey
 	WipeBytes(k.persistenceKey)
 	// wipe salt
 	WipeBytes(k.salt)
+	k.forgetPassword()
 }
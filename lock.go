@@ -0,0 +1,149 @@
+package krypto431
+
+// Several processes (or instances) may use the same persistence file. Load()
+// and Save() take an advisory lock on a lock file next to the persistence file
+// (persistence file name with suffix .lock), shared while reading and
+// exclusive while writing. Lock() holds the exclusive lock from Load() until
+// Unlock() for a whole load, modify and save cycle. Without Lock(), changes
+// are detected optimistically: Load() and Save() remember a checksum of the
+// file and Save() refuses to overwrite a file that has changed since, so a key
+// marked used by one process is never marked unused by another saving an
+// older state. The instance mutex (see WithMutex()) guards changes to the
+// instance from several goroutines.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+var (
+	ErrLocked             = errors.New("persistence file is locked by another process")
+	ErrPersistenceChanged = errors.New("persistence file has changed since it was loaded, load it again")
+)
+
+// LockTimeout is how long Load(), Save() and Lock() wait for the lock held by
+// another process before returning ErrLocked.
+var LockTimeout time.Duration = 10 * time.Second
+
+// errWouldBlock is returned by lockFile() when the lock is held elsewhere.
+var errWouldBlock = errors.New("lock is held elsewhere")
+
+// Lock places an exclusive lock on the persistence file until Unlock() (or
+// Close()), Load() and Save() do not take their own locks while it is held.
+func (k *Krypto431) Lock() error {
+	if k.lockFile != nil {
+		return nil
+	}
+	if err := k.expandPersistence(); err != nil {
+		return err
+	}
+	f, err := k.acquireLock(true)
+	if err != nil {
+		return err
+	}
+	k.lockFile = f
+	return nil
+}
+
+// Unlock releases the lock placed by Lock().
+func (k *Krypto431) Unlock() error {
+	if k.lockFile == nil {
+		return nil
+	}
+	f := k.lockFile
+	k.lockFile = nil
+	if err := unlockFile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// lockPersistence locks the persistence file unless the instance holds the
+// lock (see Lock()). The returned function releases the lock.
+func (k *Krypto431) lockPersistence(exclusive bool) (func(), error) {
+	if k.lockFile != nil {
+		return func() {}, nil
+	}
+	f, err := k.acquireLock(exclusive)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// acquireLock opens (or creates) the lock file and locks it, retrying until
+// LockTimeout.
+func (k *Krypto431) acquireLock(exclusive bool) (*os.File, error) {
+	name := k.persistence + ".lock"
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		err := lockFile(f, exclusive)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, errWouldBlock) || time.Now().After(deadline) {
+			f.Close()
+			if errors.Is(err, errWouldBlock) {
+				return nil, fmt.Errorf("%w: %s", ErrLocked, k.persistence)
+			}
+			return nil, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// checkUnchanged returns ErrPersistenceChanged if the persistence file is not
+// the file loaded or saved by the instance (call with the lock held).
+func (k *Krypto431) checkUnchanged() error {
+	if k.checksum == nil {
+		return nil
+	}
+	checksum, err := fileChecksum(k.persistence)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s was removed", ErrPersistenceChanged, k.persistence)
+		}
+		return err
+	}
+	if !bytes.Equal(checksum, k.checksum) {
+		return fmt.Errorf("%w: %s", ErrPersistenceChanged, k.persistence)
+	}
+	return nil
+}
+
+// fileChecksum returns the SHA-256 checksum of file.
+func fileChecksum(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	checksum := sha256.Sum256(data)
+	return checksum[:], nil
+}
+
+// lock locks the instance mutex (if any).
+func (k *Krypto431) lock() {
+	if k.mx != nil {
+		k.mx.Lock()
+	}
+}
+
+// unlock unlocks the instance mutex (if any).
+func (k *Krypto431) unlock() {
+	if k.mx != nil {
+		k.mx.Unlock()
+	}
+}
@@ -0,0 +1,110 @@
+package krypto431
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKrypto431_PersistenceChanged(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.krypto431")
+	pfk := GeneratePFK()
+	k := New(WithPersistence(file), WithPFKString(pfk), WithCallSign("SA6MWA"), WithOverwritePersistenceIfExists(true))
+	if err := k.GenerateKeys(2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	a := New(WithPersistence(file), WithPFKString(pfk))
+	b := New(WithPersistence(file), WithPFKString(pfk))
+	for _, i := range []*Krypto431{&a, &b} {
+		if err := i.Load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Keys[0].Used = true
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// b loaded the file before a saved, saving would mark the key unused again.
+	b.NewKey(time.Now().AddDate(0, 0, 1))
+	if err := b.Save(); !errors.Is(err, ErrPersistenceChanged) {
+		t.Fatalf("Expected %v, got %v", ErrPersistenceChanged, err)
+	}
+	if err := b.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Keys[0].Used || len(b.Keys) != 2 {
+		t.Errorf("Expected the changes of a after loading again")
+	}
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// The first Save() (k) also remembers the file.
+	if err := k.Save(); !errors.Is(err, ErrPersistenceChanged) {
+		t.Errorf("Expected %v, got %v", ErrPersistenceChanged, err)
+	}
+}
+
+func TestKrypto431_Lock(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.krypto431")
+	pfk := GeneratePFK()
+	k := New(WithPersistence(file), WithPFKString(pfk))
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	// Load() and Save() do not lock again while the instance holds the lock.
+	if err := k.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+	timeout := LockTimeout
+	defer func() { LockTimeout = timeout }()
+	LockTimeout = 200 * time.Millisecond
+	l := New(WithPersistence(file), WithPFKString(pfk))
+	if err := l.Load(); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected %v, got %v", ErrLocked, err)
+	}
+	if err := k.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Load(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKrypto431_Mutex(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"), WithPFKString(GeneratePFK()))
+	if err := k.GenerateKeys(40, nil, "SA4LGZ"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := k.NewTextMessage("SA4LGZ DE SA6MWA 012345 C = HELLO = K"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(k.Messages) != 20 {
+		t.Fatalf("Expected 20 messages, got %d", len(k.Messages))
+	}
+	for i := range k.Messages {
+		for j := i + 1; j < len(k.Messages); j++ {
+			if EqualRunes(&k.Messages[i].KeyId, &k.Messages[j].KeyId) {
+				t.Errorf("Key %s used for messages %d and %d", string(k.Messages[i].KeyId), i, j)
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package krypto431
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile places an advisory lock on f (flock), exclusive or shared, without
+// waiting.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errWouldBlock
+	}
+	return err
+}
+
+// unlockFile releases the lock on f.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
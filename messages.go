@@ -95,8 +95,8 @@ var (
 )
 
 func (m Message) GoString() string {
-	return fmt.Sprintf("Message{Recipients:[%s] From:%s DTG:%s KeyId:%s PlainText:\"%s\" Binary:%q CipherText:\"%s\" Radiogram:\"%s\" instance:%p}",
-		m.JoinRecipients(","), string(m.From), m.DTG, string(m.KeyId), string(m.PlainText), m.Binary, string(m.CipherText), string(m.Radiogram), m.instance)
+	return fmt.Sprintf("Message{Recipients:[%s] From:%s DTG:%s KeyId:%s PlainText:\"%s\" Binary:%q CipherText:\"%s\" Radiogram:\"%s\" Collective:%t instance:%p}",
+		m.JoinRecipients(","), string(m.From), m.DTG, string(m.KeyId), string(m.PlainText), m.Binary, string(m.CipherText), string(m.Radiogram), m.Collective, m.instance)
 }
 
 // NewTextMessage creates a new message from a radiogram (Swedish Armed Forces telegraphy
@@ -158,7 +158,9 @@ func (k *Krypto431) NewTextMessage(msg ...string) (*Message, error) {
 			fmt.Fprintf(os.Stderr, "Warning: %v"+LineBreak, err)
 		}
 	}
+	k.lock()
 	k.Messages = append(k.Messages, *message)
+	k.unlock()
 	reset = false
 	return message, nil
 }
@@ -167,6 +169,17 @@ func (k *Krypto431) NewTextMessage(msg ...string) (*Message, error) {
 // radiogram. If os.Stdin is not a terminal, radiogram is read from stdin
 // without prompt. Returns a pointer to the new message or error on failure.
 func (k *Krypto431) PromptNewTextMessage() (*Message, error) {
+	radiogram, err := k.PromptRadiogram()
+	if err != nil {
+		return nil, err
+	}
+	return k.NewTextMessage(radiogram)
+}
+
+// PromptRadiogram prompts the user to enter a radiogram. If os.Stdin is not a
+// terminal, radiogram is read from stdin (until EOF) without prompt. Returns
+// the radiogram as entered.
+func (k *Krypto431) PromptRadiogram() (string, error) {
 	if IsTerminal() {
 		fmt.Print(HelpTextRadiogram)
 		var radiogram string
@@ -176,11 +189,15 @@ func (k *Krypto431) PromptNewTextMessage() (*Message, error) {
 		}
 		err := survey.AskOne(prompt, &radiogram)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
-		return k.NewTextMessage(radiogram)
+		return radiogram, nil
+	}
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
 	}
-	return k.NewTextMessageFromReader(os.Stdin)
+	return string(b), nil
 }
 
 // NewTextMessageFromReader is similar to PromptNewTextMessage except radiogram
@@ -242,6 +259,9 @@ func (k *Krypto431) ParseRadiogram(radiogram string) (*Message, error) {
 				return unicode.IsSpace(r) || r == '+' || r == '='
 			})
 			m.Radiogram = []rune(radiogram)
+			if loc := r.FindStringSubmatchIndex(radiogram); loc != nil && loc[8] >= 0 {
+				m.Collective = isCollective(radiogram[loc[0]:loc[8]])
+			}
 			return m, nil
 		}
 	}
@@ -277,6 +297,8 @@ func (m *Message) ContainsRecipient(recipients ...[]rune) bool {
 // NewUniqueMessageId generates an alpha-numeric ID that is unique among the
 // instance's messages. Returns a rune slice of length 4.
 func (k *Krypto431) NewUniqueMessageId() []rune {
+	k.lock()
+	defer k.unlock()
 	idLen := 4
 	id := make([]rune, idLen)
 	for { // If you already have 62*62*62*62 (14776336) messages, this is an infinite loop :)
@@ -318,6 +340,8 @@ func (m *Message) RemoveRecipient(recipients ...[]rune) *Message {
 // wiping the message before deleting it. Returns number of messages deleted or
 // error on failure.
 func (k *Krypto431) DeleteMessage(messageIds ...[]rune) (int, error) {
+	k.lock()
+	defer k.unlock()
 	// TODO: error-handling is a future improvement.
 	deleted := 0
 	if len(messageIds) == 0 {
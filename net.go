@@ -0,0 +1,297 @@
+package krypto431
+
+// A net is a named group of stations (e.g NET1 = SA6MWA, SA4LGZ, QJ) sharing
+// the same keys. The name of a net can be used as a recipient (or keeper of new
+// keys) and is expanded to the members of the net when matching keys, our own
+// call-sign is never a keeper of our keys and is left out. A radiogram sent to
+// the collective (a C after the DTG, e.g "SA4LGZ QJ DE SA6MWA 012345 C = ...")
+// is enciphered with a key held by the whole (smallest) net all recipients are
+// members of. Nets are persisted and exported with the keys they keep.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// MaxNetNameLength is the longest accepted net name.
+const MaxNetNameLength int = 16
+
+var (
+	ErrNetNotFound    = errors.New("net not found")
+	ErrInvalidNetName = fmt.Errorf("net name must be 1 to %d characters of A-Z, 0-9 and /", MaxNetNameLength)
+	ErrNoNetMembers   = errors.New("a net must have at least one member")
+	ErrNetNameInUse   = errors.New("net name is the call-sign of a station")
+)
+
+// Net is a named group of stations. Members include all stations of the net
+// (ourselves as well).
+type Net struct {
+	Name    []rune
+	Members [][]rune
+}
+
+func (n Net) GoString() string {
+	return fmt.Sprintf("Net{Name:%s Members:[%s]}", n.NameString(), n.JoinMembers(","))
+}
+
+func (n *Net) NameString() string {
+	return string(n.Name)
+}
+
+func (n *Net) JoinMembers(separator string) string {
+	return JoinRunesToString(&n.Members, separator)
+}
+
+// ContainsMember returns true if all members are members of the net (case
+// insensitive).
+func (n *Net) ContainsMember(members ...[]rune) bool {
+	return AllNeedlesInHaystack(&members, &n.Members, true)
+}
+
+// Wipe overwrites the net name and members with zeroes.
+func (n *Net) Wipe() {
+	Wipe(&n.Name)
+	for i := range n.Members {
+		Wipe(&n.Members[i])
+	}
+	n.Members = nil
+}
+
+// copyNet returns a copy of the net (as the original may be wiped).
+func copyNet(n *Net) Net {
+	c := Net{Name: RuneCopy(&n.Name)}
+	for i := range n.Members {
+		c.Members = append(c.Members, RuneCopy(&n.Members[i]))
+	}
+	return c
+}
+
+// vettedNetName returns the upper case net name or error if it is invalid.
+func vettedNetName(name string) ([]rune, error) {
+	netName := []rune(strings.ToUpper(strings.TrimSpace(name)))
+	if len(netName) == 0 || len(netName) > MaxNetNameLength {
+		return nil, ErrInvalidNetName
+	}
+	for _, c := range netName {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '/' {
+			return nil, ErrInvalidNetName
+		}
+	}
+	return netName, nil
+}
+
+// GetNet returns the net named name or error if not found.
+func (k *Krypto431) GetNet(name []rune) (*Net, error) {
+	for i := range k.Nets {
+		if EqualRunesFold(&k.Nets[i].Name, &name) {
+			return &k.Nets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNetNotFound, strings.ToUpper(string(name)))
+}
+
+// SetNet adds a net or replaces the members of an existing net. Members can be
+// one call-sign per variadic, comma-separated call-signs or a combination of
+// both. Returns a pointer to the net or error if the name is invalid, there are
+// no members or the name is the call-sign of a station (ours, a keeper of any
+// key or a member of any net) as it would be expanded instead of the station.
+func (k *Krypto431) SetNet(name string, members ...string) (*Net, error) {
+	netName, err := vettedNetName(name)
+	if err != nil {
+		return nil, err
+	}
+	var vettedMembers [][]rune
+	for _, member := range VettedCallSigns(members...) {
+		if !AnyOfThem(&vettedMembers, &member) {
+			vettedMembers = append(vettedMembers, member)
+		}
+	}
+	if len(vettedMembers) == 0 {
+		return nil, ErrNoNetMembers
+	}
+	k.lock()
+	defer k.unlock()
+	if k.isStation(netName, vettedMembers) {
+		return nil, fmt.Errorf("%w: %s", ErrNetNameInUse, string(netName))
+	}
+	if net, err := k.GetNet(netName); err == nil {
+		for i := range net.Members {
+			Wipe(&net.Members[i])
+		}
+		net.Members = vettedMembers
+		return net, nil
+	}
+	k.Nets = append(k.Nets, Net{Name: netName, Members: vettedMembers})
+	return &k.Nets[len(k.Nets)-1], nil
+}
+
+// isStation returns true if name is our call-sign, a keeper of any key, a
+// member of any net or one of members (case insensitive).
+func (k *Krypto431) isStation(name []rune, members [][]rune) bool {
+	if EqualRunesFold(&name, &k.CallSign) {
+		return true
+	}
+	for i := range members {
+		if EqualRunesFold(&name, &members[i]) {
+			return true
+		}
+	}
+	for i := range k.Keys {
+		for j := range k.Keys[i].Keepers {
+			if EqualRunesFold(&name, &k.Keys[i].Keepers[j]) {
+				return true
+			}
+		}
+	}
+	for i := range k.Nets {
+		for j := range k.Nets[i].Members {
+			if EqualRunesFold(&name, &k.Nets[i].Members[j]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DeleteNet deletes nets by name. Returns the number of nets deleted. Keys of
+// a deleted net are left as is.
+func (k *Krypto431) DeleteNet(names ...[]rune) int {
+	k.lock()
+	defer k.unlock()
+	deleted := 0
+	for _, name := range names {
+		for i := range k.Nets {
+			if EqualRunesFold(&k.Nets[i].Name, &name) {
+				k.Nets[i].Wipe()
+				k.Nets = append(k.Nets[:i], k.Nets[i+1:]...)
+				deleted++
+				break
+			}
+		}
+	}
+	return deleted
+}
+
+// ExpandRecipients returns recipients where the names of nets are replaced by
+// the members of the net (except our own call-sign). Duplicates are removed.
+func (k *Krypto431) ExpandRecipients(recipients ...[]rune) [][]rune {
+	var expanded [][]rune
+	add := func(r []rune) {
+		for i := range expanded {
+			if EqualRunesFold(&expanded[i], &r) {
+				return
+			}
+		}
+		expanded = append(expanded, r)
+	}
+	for _, recipient := range recipients {
+		net, err := k.GetNet(recipient)
+		if err != nil {
+			add(recipient)
+			continue
+		}
+		for i := range net.Members {
+			if !EqualRunesFold(&net.Members[i], &k.CallSign) {
+				add(RuneCopy(&net.Members[i]))
+			}
+		}
+	}
+	return expanded
+}
+
+// CollectiveRecipients returns the recipients of a message to the collective.
+// If a recipient is a net, the expanded recipients are returned (see
+// ExpandRecipients()), otherwise the members (except our own call-sign) of the
+// smallest net where all recipients are members. Recipients are returned as is
+// if they are not all members of a net.
+func (k *Krypto431) CollectiveRecipients(recipients ...[]rune) [][]rune {
+	if len(recipients) == 0 {
+		return recipients
+	}
+	for i := range recipients {
+		if _, err := k.GetNet(recipients[i]); err == nil {
+			return k.ExpandRecipients(recipients...)
+		}
+	}
+	var smallest *Net
+	for i := range k.Nets {
+		if k.Nets[i].ContainsMember(recipients...) && (smallest == nil || len(k.Nets[i].Members) < len(smallest.Members)) {
+			smallest = &k.Nets[i]
+		}
+	}
+	if smallest == nil {
+		return recipients
+	}
+	return k.ExpandRecipients(smallest.Name)
+}
+
+// netsOfKeys returns a copy of the nets where all keepers of any of the keys
+// are members.
+func (k *Krypto431) netsOfKeys(keys []Key) []Net {
+	var nets []Net
+	for i := range k.Nets {
+		for x := range keys {
+			if len(keys[x].Keepers) > 0 && k.Nets[i].ContainsMember(keys[x].Keepers...) {
+				nets = append(nets, copyNet(&k.Nets[i]))
+				break
+			}
+		}
+	}
+	return nets
+}
+
+// SummaryOfNets returns a formatted header and one line per net sorted by
+// name.
+func (k *Krypto431) SummaryOfNets() (header []rune, lines [][]rune) {
+	var rows [][][]rune
+	for i := range k.Nets {
+		net := &k.Nets[i]
+		keys := 0
+		members := k.ExpandRecipients(net.Name)
+		for x := range k.Keys {
+			if len(k.Keys[x].Keepers) > 0 && AllNeedlesInHaystack(&members, &k.Keys[x].Keepers, true) {
+				keys++
+			}
+		}
+		rows = append(rows, [][]rune{
+			RuneCopy(&net.Name),
+			[]rune(net.JoinMembers(",")),
+			[]rune(fmt.Sprintf("%d", keys)),
+		})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return string(rows[i][0]) < string(rows[j][0])
+	})
+	return formatTable([]string{"NET", "MEMBERS", "KEYS"}, rows)
+}
+
+// isCollective returns true if the header of a radiogram (everything before
+// the text) has the collective indicator C after DE FROM.
+func isCollective(header string) bool {
+	fields := strings.FieldsFunc(strings.ToUpper(header), func(r rune) bool {
+		return unicode.IsSpace(r) || r == '='
+	})
+	for i := range fields {
+		if fields[i] == "DE" {
+			for x := i + 2; x < len(fields); x++ {
+				if fields[x] == "C" {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// keyRecipients returns the recipients to find keys for, the
+// CollectiveRecipients() if the message is sent to the collective.
+func (m *Message) keyRecipients() [][]rune {
+	if m.Collective {
+		return m.instance.CollectiveRecipients(m.Recipients...)
+	}
+	return m.Recipients
+}
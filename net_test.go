@@ -0,0 +1,89 @@
+package krypto431
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKrypto431_Nets(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"))
+	net, err := k.SetNet("net1", "SA6MWA,SA4LGZ", "qj", "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if net.NameString() != "NET1" || net.JoinMembers(",") != "SA6MWA,SA4LGZ,QJ" {
+		t.Errorf("Unexpected net %#v", *net)
+	}
+	if _, err := k.SetNet("NET 2", "QJ"); !errors.Is(err, ErrInvalidNetName) {
+		t.Errorf("Expected %v, got %v", ErrInvalidNetName, err)
+	}
+	if _, err := k.SetNet("NET2"); !errors.Is(err, ErrNoNetMembers) {
+		t.Errorf("Expected %v, got %v", ErrNoNetMembers, err)
+	}
+	if _, err := k.SetNet("PAIR", "SA6MWA,QJ"); err != nil {
+		t.Fatal(err)
+	}
+	// A net can not be named as a station, it would replace the station.
+	for _, name := range []string{"sa6mwa", "QJ", "SM5ABC"} {
+		if _, err := k.SetNet(name, "SM5ABC"); !errors.Is(err, ErrNetNameInUse) {
+			t.Errorf("Expected %v for net %s, got %v", ErrNetNameInUse, name, err)
+		}
+	}
+
+	expanded := k.ExpandRecipients([]rune("NET1"), []rune("QJ"), []rune("SM5ABC"))
+	if got := JoinRunesToString(&expanded, ","); got != "SA4LGZ,QJ,SM5ABC" {
+		t.Errorf("Expected SA4LGZ,QJ,SM5ABC, got %s", got)
+	}
+	collective := k.CollectiveRecipients([]rune("QJ"))
+	if got := JoinRunesToString(&collective, ","); got != "QJ" {
+		t.Errorf("Expected smallest net PAIR (QJ), got %s", got)
+	}
+	collective = k.CollectiveRecipients([]rune("SA4LGZ"), []rune("QJ"))
+	if got := JoinRunesToString(&collective, ","); got != "SA4LGZ,QJ" {
+		t.Errorf("Expected NET1 (SA4LGZ,QJ), got %s", got)
+	}
+
+	if err := k.GenerateKeys(1, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.GenerateKeys(1, nil, "NET1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := k.Keys[1].JoinKeepers(","); got != "SA4LGZ,QJ" {
+		t.Errorf("Expected NET1 expanded to SA4LGZ,QJ, got %s", got)
+	}
+	if key := k.FindKey([]rune("NET1")); key != &k.Keys[1] {
+		t.Error("Expected FindKey to return the key of NET1")
+	}
+
+	msg, err := k.NewTextMessage("SA4LGZ DE SA6MWA 012345 C = HELLO NET = K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.Collective || !EqualRunes(&msg.KeyId, &k.Keys[1].Id) {
+		t.Errorf("Expected collective message enciphered with the key of NET1, got %#v", *msg)
+	}
+	if traffic := msg.Traffic(); !strings.Contains(traffic, " C 3 = ") {
+		t.Errorf("Expected collective indicator in %q", traffic)
+	}
+	if m, err := k.ParseRadiogram("QJ DE SA6MWA 012345 = HELLO = K"); err != nil || m.Collective {
+		t.Errorf("Expected a message that is not collective (%v)", err)
+	}
+
+	exported := k.ExportKeys(func(key *Key) bool { return key.ContainsKeeper([]rune("SA4LGZ")) })
+	if len(exported.Nets) != 1 || exported.Nets[0].NameString() != "NET1" {
+		t.Errorf("Expected NET1 to be exported, got %#v", exported.Nets)
+	}
+
+	if n := k.DeleteNet([]rune("net1"), []rune("NOPE")); n != 1 || len(k.Nets) != 1 {
+		t.Errorf("Expected 1 net deleted and 1 left, got %d and %d", n, len(k.Nets))
+	}
+	if _, err := k.GetNet([]rune("NET1")); !errors.Is(err, ErrNetNotFound) {
+		t.Errorf("Expected %v, got %v", ErrNetNotFound, err)
+	}
+	// SA4LGZ is no longer a member of a net, but still keeps a key.
+	if _, err := k.SetNet("SA4LGZ", "QJ"); !errors.Is(err, ErrNetNameInUse) {
+		t.Errorf("Expected %v, got %v", ErrNetNameInUse, err)
+	}
+}
@@ -0,0 +1,103 @@
+package krypto431
+
+// By default a key is burnt as a whole, a short message uses a complete key.
+// With PartialKeys enabled on the instance, a key is consumed from its Offset
+// (the first un-used position) and the rest of the key is left for the next
+// message. The start offset is sent in the clear as the second group of the
+// radiogram (the indicator group, after the key id) where the offset is
+// written in base 26 as A-Z (A=0) or in base 10 as digits for numeric keys,
+// e.g AAABC is offset 28 and 00028 in a numeric key. Keys chained by a key
+// change are always used from the beginning, only un-touched keys (Offset 0)
+// are chained. All stations on a circuit must use the same setting.
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrOffsetOutOfRange = errors.New("key offset does not fit in the indicator group")
+	ErrInvalidIndicator = errors.New("invalid indicator group")
+)
+
+// Remaining returns the number of un-used key characters.
+func (k *Key) Remaining() int {
+	if k.Used {
+		return 0
+	}
+	remaining := k.KeyLength() - k.Offset
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// consume records that n characters of the key from offset start have been
+// used. Offset is rounded up to a whole group (so consumed groups can be
+// crossed out on the printed key) and the key is marked used when what is
+// left is shorter than MinimumSupportedKeyLength.
+func (k *Key) consume(start int, n int, groupSize int) {
+	end := start + n
+	if groupSize > 0 && end%groupSize != 0 {
+		end += groupSize - end%groupSize
+	}
+	if end > k.Offset {
+		k.Offset = end
+	}
+	if k.KeyLength()-k.Offset < MinimumSupportedKeyLength {
+		k.Used = true
+	}
+}
+
+// EncodeIndicator returns the indicator group of a key offset, groupSize
+// characters long in alphabet (A-Z or 0-9), most significant character first.
+func EncodeIndicator(offset int, groupSize int, alphabet []rune) ([]rune, error) {
+	if groupSize < 1 {
+		return nil, ErrInvalidGroupSize
+	}
+	if offset < 0 || len(alphabet) < 2 {
+		return nil, ErrOffsetOutOfRange
+	}
+	base := len(alphabet)
+	indicator := make([]rune, groupSize)
+	for i := groupSize - 1; i >= 0; i-- {
+		indicator[i] = alphabet[offset%base]
+		offset /= base
+	}
+	if offset > 0 {
+		return nil, ErrOffsetOutOfRange
+	}
+	return indicator, nil
+}
+
+// DecodeIndicator returns the key offset of an indicator group, see
+// EncodeIndicator().
+func DecodeIndicator(indicator []rune, alphabet []rune) (int, error) {
+	offset := 0
+	for _, r := range indicator {
+		digit := -1
+		for i := range alphabet {
+			if alphabet[i] == r {
+				digit = i
+				break
+			}
+		}
+		if digit < 0 {
+			return 0, fmt.Errorf("%w: %c is not in %s", ErrInvalidIndicator, r, string(alphabet))
+		}
+		offset = offset*len(alphabet) + digit
+	}
+	return offset, nil
+}
+
+// findUntouchedKey is findKey() for keys that have not been partially
+// consumed (Offset 0), used when chaining keys.
+func (r *Krypto431) findUntouchedKey(scheme *CodingScheme, recipients ...[]rune) *Key {
+	recipients = r.ExpandRecipients(recipients...)
+	for i := range r.Keys {
+		if r.Keys[i].Offset == 0 && r.keyMatchesRecipients(&r.Keys[i], scheme, recipients...) {
+			return &r.Keys[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package krypto431
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEncodeIndicator(t *testing.T) {
+	letters := []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+	digits := []rune("0123456789")
+	testTable := []struct {
+		offset    int
+		alphabet  []rune
+		indicator string
+	}{
+		{0, letters, "AAAAA"},
+		{28, letters, "AAABC"},
+		{350, letters, "AAANM"},
+		{28, digits, "00028"},
+		{99999, digits, "99999"},
+	}
+	for _, test := range testTable {
+		indicator, err := EncodeIndicator(test.offset, 5, test.alphabet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(indicator) != test.indicator {
+			t.Errorf("Offset %d: got %s, expected %s", test.offset, string(indicator), test.indicator)
+		}
+		offset, err := DecodeIndicator(indicator, test.alphabet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != test.offset {
+			t.Errorf("Indicator %s: got %d, expected %d", test.indicator, offset, test.offset)
+		}
+	}
+	if _, err := EncodeIndicator(100000, 5, digits); !errors.Is(err, ErrOffsetOutOfRange) {
+		t.Errorf("Expected %v, got %v", ErrOffsetOutOfRange, err)
+	}
+	if _, err := DecodeIndicator([]rune("AA1AA"), letters); !errors.Is(err, ErrInvalidIndicator) {
+		t.Errorf("Expected %v, got %v", ErrInvalidIndicator, err)
+	}
+}
+
+func TestMessage_EncipherPartialKeys(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"), WithPartialKeys(true))
+	err := k.GenerateKeys(5, nil, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Receiving station has a copy of the keys.
+	r := New(WithCallSign("QJ"), WithPartialKeys(true))
+	for i := range k.Keys {
+		key := k.Keys[i]
+		key.instance = &r
+		r.Keys = append(r.Keys, key)
+	}
+	texts := []string{"HELLO", "SHORT MESSAGE NUMBER TWO", "AND A THIRD ONE"}
+	previousOffset := 0
+	for _, text := range texts {
+		plainText := []rune(text)
+		msg := &Message{instance: &k, Recipients: VettedRecipients("QJ"), PlainText: plainText}
+		err := msg.Encipher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.KeyId) != string(k.Keys[0].Id) {
+			t.Fatalf("Expected all messages to use the first key, got %s", string(msg.KeyId))
+		}
+		if k.Keys[0].Used || k.Keys[0].Offset <= previousOffset || k.Keys[0].Offset%k.GroupSize != 0 {
+			t.Fatalf("Unexpected offset %d (previous %d) or used %t", k.Keys[0].Offset, previousOffset, k.Keys[0].Used)
+		}
+		indicator, _ := EncodeIndicator(previousOffset, k.GroupSize, []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+		if string(msg.CipherText[:k.GroupSize]) != string(indicator) {
+			t.Errorf("Expected indicator group %s, got %s", string(indicator), string(msg.CipherText[:k.GroupSize]))
+		}
+		previousOffset = k.Keys[0].Offset
+		received := &Message{instance: &r, KeyId: RuneCopy(&msg.KeyId), CipherText: RuneCopy(&msg.CipherText)}
+		err = received.Decipher()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(received.PlainText) != text {
+			t.Errorf("Got \"%s\", wanted \"%s\"", string(received.PlainText), text)
+		}
+		if r.Keys[0].Offset != k.Keys[0].Offset {
+			t.Errorf("Receiver offset %d, sender offset %d", r.Keys[0].Offset, k.Keys[0].Offset)
+		}
+	}
+	// A long message uses the rest of the first key and chains an untouched key.
+	long := make([]rune, DefaultKeyLength)
+	for i := range long {
+		long[i] = 'A'
+	}
+	msg := &Message{instance: &k, Recipients: VettedRecipients("QJ"), PlainText: long}
+	if err := msg.Encipher(); err != nil {
+		t.Fatal(err)
+	}
+	if !k.Keys[0].Used || k.Keys[1].Used || k.Keys[1].Offset == 0 {
+		t.Errorf("Expected first key used and second key partially consumed, got %s and %s", k.Keys[0].UsedString(), k.Keys[1].UsedString())
+	}
+	received := &Message{instance: &r, KeyId: RuneCopy(&msg.KeyId), CipherText: RuneCopy(&msg.CipherText)}
+	if err := received.Decipher(); err != nil {
+		t.Fatal(err)
+	}
+	if string(received.PlainText) != string(long) {
+		t.Error("Long message did not decipher correctly")
+	}
+	// Whole-key semantics must never reuse a partially consumed key.
+	k.PartialKeys = false
+	if key := k.FindKey(VettedRecipients("QJ")...); key == nil || key.Offset != 0 {
+		t.Error("Expected FindKey to skip partially consumed keys without PartialKeys")
+	}
+	msg = &Message{instance: &k, KeyId: RuneCopy(&k.Keys[1].Id), PlainText: []rune("HELLO")}
+	if err := msg.Encipher(); err == nil || k.Keys[1].Used {
+		t.Errorf("Expected enciphering with partially consumed key %s to fail without PartialKeys", k.Keys[1].IdString())
+	}
+}
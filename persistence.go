@@ -8,6 +8,7 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
@@ -21,7 +22,6 @@ import (
 	stream "github.com/nknorg/encrypted-stream"
 	"github.com/sa6mwa/krypto431/crand"
 	passwordvalidator "github.com/wagslane/go-password-validator"
-	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/term"
 )
 
@@ -46,10 +46,12 @@ var (
 	ErrCopyKeyFailure = errors.New("copy key failure")
 )
 
-// DerivePFKFromPassword uses PBKDF2 to produce the 32 byte long key used to
-// encrypt/decrypt the persistence file. The salt in the Krypto431 instance is
-// used to derive the key, either the default fixed salt or one that you
+// DerivePFKFromPassword uses Argon2id (or PBKDF2, see kdf.go) to produce the 32
+// byte long key used to encrypt/decrypt the persistence file. The salt in the
+// Krypto431 instance is used to derive the key, either the default fixed salt
+// (replaced with a random salt when Save() creates a new file) or one that you
 // provided earlier (e.g krypto431.New(krypto431.WithSalt(my64charHexString))).
+// A copy of the password is kept until Load() or Save() (see keepPassword()).
 func (k *Krypto431) DerivePFKFromPassword(password *[]byte) error {
 	if password == nil {
 		return ErrNilPointer
@@ -66,8 +68,12 @@ func (k *Krypto431) DerivePFKFromPassword(password *[]byte) error {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: %v (%.0f<%.0f)"+LineBreak, err, entropyBits, MinimumPasswordEntropyBits)
 	}
-	dk := pbkdf2.Key(*password, *k.salt, DefaultPBKDF2Iteration, 32, sha256.New)
-	k.persistenceKey = &dk
+	parameters := k.kdf.copyParameters()
+	parameters.salt = ByteCopy(k.salt)
+	if err := k.derivePFK(*password, parameters); err != nil {
+		return err
+	}
+	k.keepPassword(*password)
 	return nil
 }
 
@@ -88,11 +94,54 @@ func (k *Krypto431) DerivePFKFromPasswordWithValidation(password *[]byte) error
 	if err != nil {
 		return err
 	}
-	dk := pbkdf2.Key(*password, *k.salt, DefaultPBKDF2Iteration, 32, sha256.New)
+	parameters := k.kdf.copyParameters()
+	parameters.salt = ByteCopy(k.salt)
+	if err := k.derivePFK(*password, parameters); err != nil {
+		return err
+	}
+	k.keepPassword(*password)
+	return nil
+}
+
+// derivePFK derives the PFK from password with the KDF parameters.
+func (k *Krypto431) derivePFK(password []byte, parameters kdfParameters) error {
+	dk, err := parameters.derive(password)
+	if err != nil {
+		return err
+	}
+	ZeroWipeBytes(&k.pfkParameters.salt)
+	k.pfkParameters = parameters
 	k.persistenceKey = &dk
 	return nil
 }
 
+// keepPassword keeps a copy of password until Load() or Save() knows the KDF
+// parameters of the file, a file saved with other parameters than the key was
+// derived with needs the password to derive the key again. Load() and Save()
+// wipe the password (see wipePassword()) once the key of the file is derived.
+func (k *Krypto431) keepPassword(password []byte) {
+	// password may be the kept password itself, copy before wiping.
+	pwd := ByteCopy(&password)
+	k.wipePassword()
+	k.password = &pwd
+}
+
+// wipePassword wipes the password kept by keepPassword() or SetPassword().
+func (k *Krypto431) wipePassword() {
+	if k.password != nil {
+		WipeBytes(k.password)
+		k.password = nil
+	}
+}
+
+// forgetPassword wipes the password and the KDF parameters of the PFK when the
+// PFK is set directly.
+func (k *Krypto431) forgetPassword() {
+	k.wipePassword()
+	ZeroWipeBytes(&k.pfkParameters.salt)
+	k.pfkParameters = kdfParameters{}
+}
+
 // GetPFK returns a byte slice pointer to the instance persistence file key
 // (PFK). Function exists as the persistenceKey field is not exported.
 func (k *Krypto431) GetPFK() *[]byte {
@@ -128,10 +177,24 @@ func (k *Krypto431) GetPersistence() string {
 	return k.persistence
 }
 
+// expandPersistence resolves a persistence file name starting with a tilde to
+// the user's home directory.
+func (k *Krypto431) expandPersistence() error {
+	if strings.HasPrefix(k.persistence, "~/") {
+		dirname, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		k.persistence = filepath.Join(dirname, k.persistence[2:])
+	}
+	return nil
+}
+
 // SetPersistence sets the non-exported persistence property in the instance to
 // filename.
 func (k *Krypto431) SetPersistence(filename string) {
 	k.persistence = filename
+	k.checksum = nil
 }
 
 // Takes salt from a hex encoded string, converts it into a byte slice and sets
@@ -149,6 +212,7 @@ func (k *Krypto431) SetSaltFromString(salt string) error {
 		return ErrTooShortSalt
 	}
 	k.salt = &byteSalt
+	k.saltSet = true
 	return nil
 }
 
@@ -172,10 +236,36 @@ func (k *Krypto431) SetPFKFromString(key string) error {
 		WipeBytes(&byteKey)
 		return ErrInvalidPFK
 	}
+	k.forgetPassword()
 	k.persistenceKey = &byteKey
 	return nil
 }
 
+// SetPassword sets the password the persistence file key is derived from when
+// the file is loaded (with the KDF parameters in the file's header) or saved
+// (with the instance's KDF settings), see kdf.go. Unlike SetPFKFromPassword()
+// the key is derived once the parameters are known. The password is wiped
+// when the key has been derived.
+func (k *Krypto431) SetPassword(password string) {
+	pwd := []byte(password)
+	k.SetPasswordBytes(&pwd)
+}
+
+// SetPasswordBytes is SetPassword() with the password in a byte slice which is
+// wiped.
+func (k *Krypto431) SetPasswordBytes(password *[]byte) {
+	k.forgetPassword()
+	if k.persistenceKey != nil {
+		WipeBytes(k.persistenceKey)
+		k.persistenceKey = nil
+	}
+	if password == nil {
+		return
+	}
+	defer WipeBytes(password)
+	k.keepPassword(*password)
+}
+
 // Similar to SetPFKFromString() except it derives the key from a passphrase via
 // the PBKDF2 function DerivePFKFromPassword. The instance's
 // configured salt is used and need to be set before calling this function.
@@ -320,22 +410,25 @@ func GeneratePFK() string {
 }
 
 // Krypto431_Save persists a Krypto431 instance to file. The output file is a
-// gzipped GOB (Go Binary) which is XSalsa20Poly1305 encrypted using a 32 byte
-// key set via DerivePFKFromPassword(), SetPFKFromString() or WithPFK().
+// plaintext header (see header.go) followed by a gzipped GOB (Go Binary) which
+// is XSalsa20Poly1305 encrypted using a 32 byte key set via
+// DerivePFKFromPassword(), SetPFKFromString() or WithPFK(). The file is
+// replaced atomically and the previous file is kept as a backup (see
+// backup.go). When Save() creates a new file and the key is derived from a
+// password with the DefaultSalt, the salt is replaced with a random salt
+// unless it was set explicitly (WithSalt(), WithSaltString() or
+// SetSaltFromString(), e.g WithSaltString(DefaultSalt) keeps the DefaultSalt).
 func (k *Krypto431) Save() error {
+	k.lock()
+	defer k.unlock()
 	if len(k.persistence) == 0 {
 		return ErrNoPersistence
 	}
-	// If persistence file starts with a tilde, resolve it to the user's home
-	// directory.
-	if strings.HasPrefix(k.persistence, "~/") {
-		dirname, err := os.UserHomeDir()
-		if err != nil {
-			return err
-		}
-		k.persistence = filepath.Join(dirname, k.persistence[2:])
+	if err := k.expandPersistence(); err != nil {
+		return err
 	}
 	_, err := os.Stat(k.persistence)
+	newFile := err != nil
 	if err != nil {
 		if !errors.Is(err, fs.ErrNotExist) {
 			// Other error than file not found...
@@ -361,9 +454,24 @@ func (k *Krypto431) Save() error {
 			}
 		}
 	}
-	// Ask for password if instance key is empty and mode is interactive, fail otherwise.
+	// A new file never gets the published DefaultSalt (see kdf.go), the salt
+	// is replaced before the key is derived.
+	if newFile {
+		err = k.randomizeDefaultSalt()
+		if err != nil {
+			return err
+		}
+	}
+	// Derive the key from the password (see SetPassword()) or ask for password
+	// if instance key is empty and mode is interactive, fail otherwise.
 	if k.persistenceKey == nil {
-		if k.interactive && IsTerminal() {
+		if k.password != nil {
+			pwd := ByteCopy(k.password)
+			err := k.DerivePFKFromPassword(&pwd)
+			if err != nil {
+				return err
+			}
+		} else if k.interactive && IsTerminal() {
 			pwd, err := AskAndConfirmPassword(EncryptionPrompt, MinimumPasswordEntropyBits)
 			if err != nil {
 				return err
@@ -376,13 +484,50 @@ func (k *Krypto431) Save() error {
 			return ErrNilPFK
 		}
 	}
-	// Create persistence file if it does not exist or truncate it if it does exist.
-	f, err := os.OpenFile(k.persistence, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	// The header is written with the parameters of the key, the password is
+	// no longer needed.
+	k.wipePassword()
+	// Refuse to overwrite changes made by others since Load() (see lock.go).
+	unlock, err := k.lockPersistence(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = k.checkUnchanged()
+	if err != nil {
+		return err
+	}
+	// A journal of a previous file at the same path can not be appended to.
+	err = k.moveStaleJournalAside()
+	if err != nil {
+		return err
+	}
+	// The journal is written first, a key is never used according to the
+	// persistence file but not according to the journal (see journal.go).
+	err = k.writeJournal()
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file renamed over the persistence file (see
+	// backup.go).
+	err = k.writeAtomically(k.writePersistence)
+	if err != nil {
+		return err
+	}
+	k.checksum, err = fileChecksum(k.persistence)
+	return err
+}
+
+// writePersistence writes the header and the encrypted instance to f.
+func (k *Krypto431) writePersistence(f *os.File) error {
+	// The plaintext header comes first (see header.go).
+	_, err := f.Write(k.persistenceHeader().bytes(*k.persistenceKey))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	// Krypto431 persistence files are encrypted, gzipped GOB files.
+	// Krypto431 persistence files are encrypted, gzipped GOB files. The
+	// encrypted stream is not closed as it would close f, chunks are written
+	// to f as they are encrypted.
 	encrypter, err := stream.NewEncryptedStream(f, &stream.Config{
 		Cipher:          stream.NewXSalsa20Poly1305Cipher((*[32]byte)(*k.persistenceKey)),
 		SequentialNonce: false, // The key is the same and will leak if nonce is sequential.
@@ -391,33 +536,26 @@ func (k *Krypto431) Save() error {
 	if err != nil {
 		return err
 	}
-	defer encrypter.Close()
 	fgz := gzip.NewWriter(encrypter)
-	defer fgz.Close()
 	gobEncoder := gob.NewEncoder(fgz)
 	err = gobEncoder.Encode(k)
 	if err != nil {
 		return err
 	}
-	return nil
+	return fgz.Close()
 }
 
 // Krypto431_Load() loads a Krypto431 instance from the configured persistence
 // file (k.persistence). Only exported fields will be populated.
 func (k *Krypto431) Load() error {
+	k.lock()
+	defer k.unlock()
 	if len(k.persistence) == 0 {
 		return ErrNoPersistence
 	}
-	// If persistence file starts with a tilde, resolve it to the user's home
-	// directory.
-	if strings.HasPrefix(k.persistence, "~/") {
-		dirname, err := os.UserHomeDir()
-		if err != nil {
-			return err
-		}
-		k.persistence = filepath.Join(dirname, k.persistence[2:])
+	if err := k.expandPersistence(); err != nil {
+		return err
 	}
-	var f *os.File
 	_, err := os.Stat(k.persistence)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
@@ -425,28 +563,91 @@ func (k *Krypto431) Load() error {
 		}
 		return err
 	}
+	// Load persisted data, the file is read with the lock held and its
+	// checksum is kept to detect changes before Save() (see lock.go). The
+	// journal is read with the same lock so that it matches the file, it is
+	// replayed once the journal key has been decrypted.
+	unlock, err := k.lockPersistence(false)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(k.persistence)
+	if err != nil {
+		unlock()
+		return err
+	}
+	var journal io.Reader
+	if k.journal {
+		journalData, err := os.ReadFile(k.JournalFile())
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			unlock()
+			return err
+		}
+		if err == nil {
+			journal = bytes.NewReader(journalData)
+		}
+	}
+	unlock()
+	checksum := sha256.Sum256(data)
+	f := bytes.NewReader(data)
+	header, err := readPersistenceHeader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", k.persistence, err)
+	}
+	// The salt of the file is used to derive the key from the password.
+	if header.KDF != KDFNone && len(header.Salt) > 0 {
+		if k.salt != nil {
+			WipeBytes(k.salt)
+		}
+		k.salt = BytePtr(ByteCopy(&header.Salt))
+	}
 	// Persistence file exists, ask for password if instance key is empty.
-	if k.persistenceKey == nil {
+	if k.persistenceKey == nil && k.password == nil {
 		if k.interactive && IsTerminal() {
 			pwd := AskForPassword(DecryptionPrompt, 0)
 			if pwd == nil {
 				return ErrPasswordInput
 			}
-			err := k.DerivePFKFromPassword(pwd)
-			if err != nil {
-				return err
-			}
+			k.password = pwd
 		} else {
 			return ErrNilPFK
 		}
 	}
-	// Load persisted data...
-	f, err = os.Open(k.persistence)
-	if err != nil {
-		return err
+	// Derive the key with the KDF parameters of the file (again if the key was
+	// derived with other parameters). Files without a header used PBKDF2.
+	parameters := header.parameters()
+	if header.Version == 0 {
+		parameters = kdfParameters{kdf: KDFPBKDF2, iterations: DefaultPBKDF2Iteration, salt: ByteCopy(k.salt)}
+	}
+	if parameters.kdf != KDFNone && k.password != nil && (k.persistenceKey == nil || !parameters.equal(&k.pfkParameters)) {
+		err := k.derivePFK(*k.password, parameters)
+		if err != nil {
+			k.wipePassword()
+			return err
+		}
+	}
+	// The key is derived with the parameters of the file, the password is no
+	// longer needed.
+	k.wipePassword()
+	if k.persistenceKey == nil {
+		// The key of the file is not derived from a password.
+		return ErrNilPFK
+	}
+	if !header.CheckPFK(*k.persistenceKey) {
+		return fmt.Errorf("%s: %w", k.persistence, ErrWrongPFK)
 	}
-	defer f.Close()
-	decrypter, err := stream.NewEncryptedStream(f, &stream.Config{
+	// Errors decrypting a file with a header are corruption, a file without a
+	// header has no key check.
+	decryptionError := func(err error) error {
+		if header.Version == 0 {
+			return fmt.Errorf("%s: %w (%v)", k.persistence, ErrWrongPFKOrNotKrypto431, err)
+		}
+		return fmt.Errorf("%s: %w: %v", k.persistence, ErrCorruptPersistence, err)
+	}
+	decrypter, err := stream.NewEncryptedStream(struct {
+		io.Reader
+		io.Writer
+	}{f, io.Discard}, &stream.Config{
 		Cipher:          stream.NewXSalsa20Poly1305Cipher((*[32]byte)(*k.persistenceKey)),
 		SequentialNonce: false, // The key is the same and will leak if nonce is sequential.
 		Initiator:       false,
@@ -457,11 +658,15 @@ func (k *Krypto431) Load() error {
 	defer decrypter.Close()
 	fgz, err := gzip.NewReader(decrypter)
 	if err != nil {
-		return err
+		return decryptionError(err)
 	}
 	defer fgz.Close()
 	gobDecoder := gob.NewDecoder(fgz)
 	err = gobDecoder.Decode(k)
+	if err != nil {
+		return decryptionError(err)
+	}
+	err = k.migrate(header.Version)
 	if err != nil {
 		return err
 	}
@@ -472,8 +677,27 @@ func (k *Krypto431) Load() error {
 	for i := range k.Messages {
 		k.Messages[i].instance = k
 	}
+	// Keys used or compromised according to the journal are marked again if
+	// the file has been rolled back (see journal.go).
+	if k.journal {
+		var entries []JournalEntry
+		if journal != nil {
+			entries, err = k.readJournal(journal)
+			if err != nil {
+				return fmt.Errorf("unable to replay journal: %w", err)
+			}
+		}
+		report, err := k.replayJournal(entries)
+		if err != nil {
+			return fmt.Errorf("unable to replay journal: %w", err)
+		}
+		if len(report.Conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: %s is older than its journal, %d keys used, compromised or deleted since are marked used or compromised again"+LineBreak, k.persistence, len(report.Conflicts))
+		}
+	}
 	// Allow overwriting file after it's been loaded...
 	k.overwritePersistenceIfExists = true
+	k.checksum = checksum[:]
 	return nil
 }
 
@@ -486,9 +710,16 @@ func (k *Krypto431) Load() error {
 // afterwards with SetPersistence()). Any Option function (With*) can be used to
 // override any copied field.
 func (k *Krypto431) ExportKeys(filterFunction func(key *Key) bool, opts ...Option) Krypto431 {
+	k.lock()
+	defer k.unlock()
 	n := Krypto431{
 		persistenceKey:                BytePtr(ByteCopy(k.persistenceKey)),
 		salt:                          BytePtr(ByteCopy(k.salt)),
+		saltSet:                       k.saltSet,
+		kdf:                           k.kdf.copyParameters(),
+		pfkParameters:                 k.pfkParameters.copyParameters(),
+		backups:                       k.backups,
+		journal:                       k.journal,
 		overwritePersistenceIfExists:  false,
 		interactive:                   k.interactive,
 		overwriteExistingKeysOnImport: false,
@@ -496,6 +727,8 @@ func (k *Krypto431) ExportKeys(filterFunction func(key *Key) bool, opts ...Optio
 		KeyLength:                     k.KeyLength,
 		Columns:                       k.Columns,
 		KeyColumns:                    k.KeyColumns,
+		CodingScheme:                  k.CodingScheme,
+		Combiner:                      k.Combiner,
 		Keys:                          make([]Key, 0, len(k.Keys)),
 		Messages:                      make([]Message, 0),
 		CallSign:                      RuneCopy(&k.CallSign),
@@ -511,8 +744,19 @@ func (k *Krypto431) ExportKeys(filterFunction func(key *Key) bool, opts ...Optio
 			newKey := k.Keys[i]
 			newKey.instance = &n
 			n.Keys = append(n.Keys, newKey)
+			k.record(JournalExported, &k.Keys[i], nil, n.persistence)
+			// Export the book of the key as well.
+			if len(newKey.Book) > 0 {
+				if _, err := n.GetBook(newKey.Book); err != nil {
+					if book, err := k.GetBook(newKey.Book); err == nil {
+						n.Books = append(n.Books, copyBook(book))
+					}
+				}
+			}
 		}
 	}
+	// Export the nets keeping the keys.
+	n.Nets = k.netsOfKeys(n.Keys)
 	return n
 }
 
@@ -536,7 +780,6 @@ func (k *Krypto431) ExportKeys(filterFunction func(key *Key) bool, opts ...Optio
 // overwriting. To force overwriting without asking, add
 // WithOverwriteExistingKeysOnImport(true).
 func (k *Krypto431) ImportKeys(filterFunction func(key *Key) bool, opts ...Option) (int, error) {
-	keyCount := 0
 	incoming := New(opts...)
 	fmt.Fprintf(os.Stderr, "Importing keys from %s"+LineBreak, incoming.GetPersistence())
 
@@ -545,11 +788,24 @@ func (k *Krypto431) ImportKeys(filterFunction func(key *Key) bool, opts ...Optio
 		return 0, err
 	}
 	defer incoming.Wipe()
+	return k.importKeys(&incoming, filterFunction)
+}
+
+// importKeys copies the keys of incoming that pass the filterFunction (with
+// their books and nets) into the instance, see ImportKeys().
+func (k *Krypto431) importKeys(incoming *Krypto431, filterFunction func(key *Key) bool) (int, error) {
+	k.lock()
+	defer k.unlock()
+	keyCount := 0
 	for i := range incoming.Keys {
 		if len(incoming.Keys[i].Id) != k.GroupSize {
 			fmt.Fprintf(os.Stderr, "Key ID %s is not %d characters long (our group size), will not import.", string(incoming.Keys[i].Id), k.GroupSize)
 			continue
 		}
+		if _, err := incoming.Keys[i].GetCombiner(); err != nil {
+			fmt.Fprintf(os.Stderr, "Key ID %s: %v, will not import."+LineBreak, string(incoming.Keys[i].Id), err)
+			continue
+		}
 		if filterFunction(&incoming.Keys[i]) {
 			if k.ContainsKeyId(&incoming.Keys[i].Id) {
 				if !k.overwriteExistingKeysOnImport && k.interactive && IsTerminal() {
@@ -568,7 +824,7 @@ func (k *Krypto431) ImportKeys(filterFunction func(key *Key) bool, opts ...Optio
 					fmt.Fprintf(os.Stderr, "Key ID %s already exist, will not import.", string(incoming.Keys[i].Id))
 					continue
 				}
-				_, err := k.DeleteKey(incoming.Keys[i].Id)
+				_, err := k.deleteKey(incoming.Keys[i].Id)
 				if err != nil {
 					return keyCount, err
 				}
@@ -593,20 +849,51 @@ func (k *Krypto431) ImportKeys(filterFunction func(key *Key) bool, opts ...Optio
 			newKey.Created = incoming.Keys[i].Created
 			newKey.Expires = incoming.Keys[i].Expires
 			newKey.Used = incoming.Keys[i].Used
+			newKey.Offset = incoming.Keys[i].Offset
 			newKey.Compromised = incoming.Keys[i].Compromised
+			newKey.CodingScheme = incoming.Keys[i].CodingScheme
+			newKey.Combiner = incoming.Keys[i].Combiner
+			newKey.Book = RuneCopy(&incoming.Keys[i].Book)
+			newKey.Sheet = incoming.Keys[i].Sheet
+			if len(newKey.Book) > 0 {
+				if _, err := k.GetBook(newKey.Book); err != nil {
+					if book, err := incoming.GetBook(newKey.Book); err == nil {
+						newBook := copyBook(book)
+						newBook.RemoveKeeper(k.CallSign)
+						if !EqualRunesFold(&incoming.CallSign, &k.CallSign) {
+							newBook.AddKeeper(RuneCopy(&incoming.CallSign))
+						}
+						k.Books = append(k.Books, newBook)
+					}
+				}
+			}
 			newKey.Comment = make([]rune, len(incoming.Keys[i].Comment))
 			if copy(newKey.Comment, incoming.Keys[i].Comment) != len(incoming.Keys[i].Comment) {
 				return keyCount, ErrCopyKeyFailure
 			}
 			newKey.instance = k
 			// Remove my call-sign from keepers, add incoming station's call-sign to
-			// keepers and hand over key to our instance (importedKey.instance = k).
-			newKey.RemoveKeeper(k.CallSign).AddKeeper(incoming.Keys[i].GetCallSign()).
-				SetInstance(k)
+			// keepers (unless they are our own keys, e.g recovered from shares) and
+			// hand over key to our instance (importedKey.instance = k).
+			newKey.RemoveKeeper(k.CallSign)
+			if !EqualRunesFold(&incoming.CallSign, &k.CallSign) {
+				newKey.AddKeeper(RuneCopy(&incoming.CallSign))
+			}
+			newKey.SetInstance(k)
 			k.Keys = append(k.Keys, newKey)
+			k.record(JournalImported, &newKey, nil, "DE "+incoming.CallSignString())
 			keyCount++
 		}
 	}
+	// Nets exported with the keys are added unless we already have a net with
+	// the same name.
+	if keyCount > 0 {
+		for i := range incoming.Nets {
+			if _, err := k.GetNet(incoming.Nets[i].Name); err != nil {
+				k.Nets = append(k.Nets, copyNet(&incoming.Nets[i]))
+			}
+		}
+	}
 
 	return keyCount, nil
 }
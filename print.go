@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"strings"
 	"unicode/utf8"
 
 	"github.com/jung-kurt/gofpdf"
@@ -37,14 +36,25 @@ var (
 	// 	`CT2 0 1 2 3 4 5 6 7 8 9 ? - Å Ä Ö . Q , Z : + / ⬔ ↕ ⌥ ⎘    ⌥ Change key (Y)` + LineBreak
 )
 
-// Key_String returns a fully printable key with the DIANA reciprocal table and
-// coding legend. Instructions are not included in the output.
+// Key_String returns a fully printable key with the table of the key's combiner
+// (the DIANA reciprocal table by default) and coding legend. Instructions are
+// not included in the output.
 func (k *Key) String() string {
 	// Setup the key Blox canvas...
 	fieldPadding := 3
 	headerLines := 4
-	footerLines := blox.LineCount(KeyFooter)
-	rtLength, rtLines := blox.RowAndColumnCount(diana.ReciprocalTable)
+	footer := KeyFooter
+	if scheme, err := k.Scheme(); err == nil {
+		footer = scheme.Legend()
+	}
+	footerLines := blox.LineCount(footer)
+	table := diana.ReciprocalTable
+	combinerName := diana.DIANA
+	if combiner, err := k.GetCombiner(); err == nil {
+		table = combiner.Table()
+		combinerName = combiner.Name()
+	}
+	rtLength, rtLines := blox.RowAndColumnCount(table)
 	groups := ""
 	rptr, _ := k.GroupsBlock()
 	if rptr != nil {
@@ -72,16 +82,24 @@ func (k *Key) String() string {
 	if len(k.Keepers) > 0 {
 		keepers = k.JoinKeepers(",")
 	}
-	header := fmt.Sprintf("/ KEEPERS: %s / EXPIRES: %s / CREATED: %s / USED: [%s]",
-		keepers, k.Expires, k.Created, k.UsedOrNotString("X", " "))
+	scheme := k.CodingScheme
+	if scheme == "" {
+		scheme = DefaultCodingSchemeId
+	}
+	header := fmt.Sprintf("/ KEEPERS: %s / EXPIRES: %s / CREATED: %s / SCHEME: %s/%s / USED: [%s]",
+		keepers, k.Expires, k.Created, scheme, combinerName, k.UsedOrNotString("X", " "))
 
 	// Just because you can...
-	return blox.New().SetColumnsAndRows(cols, rows).Trim().
-		DrawSeparator('_').PutTextRightAligned(header).Move(0, 1).
+	b := blox.New().SetColumnsAndRows(cols, rows).Trim().DrawSeparator('_')
+	// Keys of a key book have the book id and sheet number in the top separator.
+	if sheet := k.sheetHeader(); sheet != "" {
+		b.PushPos().Move(0, 0).PutLine([]rune(sheet + " ")).PopPos()
+	}
+	return b.PutTextRightAligned(header).Move(0, 1).
 		PutLine(k.Id).MoveDown().DrawSeparator().MoveDown().PushPos().
-		PutTextRightAligned(diana.ReciprocalTable).PopPos().
+		PutTextRightAligned(table).PopPos().
 		SetLineSpacing(groupsLineSpacing).PutText(groups).SetLineSpacing(1).
-		Move(0, rows-footerLines).PutText(KeyFooter).String()
+		Move(0, rows-footerLines).PutText(footer).String()
 }
 
 // Message_String returns a formatted output intended to print. By default the
@@ -145,9 +163,7 @@ func (m *Message) String(width ...int) string {
 	wrappedCipherText := blox.WrapString(groupsPrependedWithKey, uint(w))
 	if utf8.RuneCountInString(wrappedCipherText) > 0 {
 		output += "=CIPHER=" + LineBreak + wrappedCipherText + LineBreak
-		groupCount := len(strings.Fields(wrappedCipherText))
-		traffic := blox.WrapString(strings.TrimSpace(fmt.Sprintf("%s DE %s %s %d = %s = K", m.JoinRecipients(" "), string(m.From), m.DTG.String(), groupCount, groupsPrependedWithKey)), uint(w))
-		output += "=TRAFFIC=EXAMPLE=" + LineBreak + traffic + LineBreak
+		output += "=TRAFFIC=EXAMPLE=" + LineBreak + m.Traffic(w) + LineBreak
 	}
 
 	return output
@@ -251,6 +267,13 @@ func (k *Krypto431) KeysPDF(filter func(key *Key) bool, filename string) error {
 	rowCount := 0
 	var page string
 	for i := range kp {
+		// Each key book starts on a new page.
+		if i > 0 && page != "" && !EqualRunes(&kp[i].Book, &kp[i-1].Book) {
+			pdf.AddPage()
+			pdf.MultiCell(0, 3, page, "", "", false)
+			rowCount = 0
+			page = ""
+		}
 		text := kp[i].String()
 		textLines := blox.LineCount(text) + 5
 		if textLines > maxRows {
@@ -0,0 +1,302 @@
+package krypto431
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// A CodingScheme describes the character tables and control characters used
+// to encode plain text before it is enciphered. Both ends of a circuit must
+// use the same scheme, which is why the scheme Id is recorded on the instance
+// (used for new keys) and on each key. A message is always coded using the
+// scheme of the key it is enciphered with.
+//
+// Tables[0] is the primary table and Tables[1] the secondary (control) table.
+// Each table is exactly 26 runes long, one for each column A-Z of the coding
+// legend. Control characters are marked with specialOpChar (¤) in the tables.
+// NextTableChar must be a control character in both tables while the other
+// control characters live in the secondary table only. As binary mode uses
+// A-P for nibbles, all control characters must be between Q and Z.
+//
+// Registered schemes are versioned by Id and must never change once
+// registered (existing keys and messages would decode differently), a changed
+// layout is a new scheme with a new Id (e.g SV2).
+type CodingScheme struct {
+	Id               string
+	Name             string
+	Tables           [][]rune
+	NextTableChar    rune
+	BinaryToggleChar rune
+	CaseToggleChar   rune
+	ChangeKeyChar    rune
+	// Checkerboard is set on numeric schemes where plain text is coded into
+	// digits instead of letters (Tables and control characters are not used),
+	// see checkerboard.go. Keys of numeric schemes are digits (0-9).
+	Checkerboard *Checkerboard
+}
+
+const (
+	DefaultCodingSchemeId string = "SV1"
+	codingSchemeColumns   int    = 26
+)
+
+var (
+	ErrCodingSchemeNotFound   = errors.New("coding scheme not found")
+	ErrCodingSchemeExists     = errors.New("coding scheme already registered")
+	ErrInvalidCodingScheme    = errors.New("invalid coding scheme")
+	ErrCodingSchemeMismatch   = errors.New("keys use different coding schemes")
+	ErrInvalidCodingSchemeId  = errors.New("coding scheme id must be upper case A-Z and 0-9")
+	ErrNoCodingScheme         = errors.New("received a nil coding scheme")
+	ErrCodingSchemeTableCount = errors.New("coding scheme must have exactly two character tables")
+)
+
+// newCodingScheme returns a scheme with the default control characters (Z, W,
+// X and Y as described in cipher.go) and the primary table used by all
+// built-in schemes. Only the secondary table differs.
+func newCodingScheme(id string, name string, secondary string) *CodingScheme {
+	return &CodingScheme{
+		Id:               id,
+		Name:             name,
+		Tables:           [][]rune{[]rune(`ABCDEFGHIJKLMNOP RSTUVWXY¤`), []rune(secondary)},
+		NextTableChar:    nextTableChar,
+		BinaryToggleChar: binaryToggleChar,
+		CaseToggleChar:   caseToggleChar,
+		ChangeKeyChar:    changeKeyChar,
+	}
+}
+
+// codingSchemes is the registry of coding schemes in registration order. The
+// first scheme is the original Swedish layout (the default).
+var codingSchemes []*CodingScheme = []*CodingScheme{
+	newCodingScheme(DefaultCodingSchemeId, "Swedish (ÅÄÖ)", `0123456789?-ÅÄÖ.Q,Z:+/¤¤¤¤`),
+	newCodingScheme("LAT1", "Generic Latin", `0123456789?-()'.Q,Z:+/¤¤¤¤`),
+	newCodingScheme("DE1", "German (ÄÖÜß)", `0123456789?-ÄÖÜ.Q,Z:ß/¤¤¤¤`),
+	newCodingScheme("DANO1", "Danish/Norwegian (ÆØÅ)", `0123456789?-ÆØÅ.Q,Z:+/¤¤¤¤`),
+	{Id: "CT37", Name: "Numeric straddling checkerboard", Checkerboard: ct37()},
+}
+
+// RegisterCodingScheme validates and adds a coding scheme to the registry.
+// Returns error if the scheme is invalid or if the Id is already registered.
+// The registry is not guarded by a mutex, register custom schemes in an init()
+// function or before using the package concurrently.
+func RegisterCodingScheme(scheme *CodingScheme) error {
+	if scheme == nil {
+		return ErrNoCodingScheme
+	}
+	err := scheme.Validate()
+	if err != nil {
+		return err
+	}
+	if _, err := GetCodingScheme(scheme.Id); err == nil {
+		return fmt.Errorf("%w: %s", ErrCodingSchemeExists, scheme.Id)
+	}
+	codingSchemes = append(codingSchemes, scheme)
+	return nil
+}
+
+// GetCodingScheme returns the registered coding scheme with id. An empty id
+// returns the default scheme (keys created before coding schemes were
+// introduced have no scheme id and use the original Swedish layout).
+func GetCodingScheme(id string) (*CodingScheme, error) {
+	id = strings.ToUpper(strings.TrimSpace(id))
+	if id == "" {
+		id = DefaultCodingSchemeId
+	}
+	for i := range codingSchemes {
+		if codingSchemes[i].Id == id {
+			return codingSchemes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrCodingSchemeNotFound, id)
+}
+
+// DefaultCodingScheme returns the default (Swedish) coding scheme.
+func DefaultCodingScheme() *CodingScheme {
+	scheme, err := GetCodingScheme(DefaultCodingSchemeId)
+	if err != nil {
+		panic(err)
+	}
+	return scheme
+}
+
+// CodingSchemes returns all registered coding schemes in registration order.
+func CodingSchemes() []*CodingScheme {
+	schemes := make([]*CodingScheme, len(codingSchemes))
+	copy(schemes, codingSchemes)
+	return schemes
+}
+
+// CodingSchemeIds returns the Id of all registered coding schemes in
+// registration order.
+func CodingSchemeIds() []string {
+	ids := make([]string, 0, len(codingSchemes))
+	for i := range codingSchemes {
+		ids = append(ids, codingSchemes[i].Id)
+	}
+	return ids
+}
+
+// String returns the Id and name of the scheme, e.g "SV1 (Swedish (ÅÄÖ))".
+func (s *CodingScheme) String() string {
+	return fmt.Sprintf("%s (%s)", s.Id, s.Name)
+}
+
+// Validate checks that the scheme can be used by the encoder and decoder.
+func (s *CodingScheme) Validate() error {
+	if len(s.Id) == 0 {
+		return ErrInvalidCodingSchemeId
+	}
+	for _, c := range s.Id {
+		if !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') {
+			return ErrInvalidCodingSchemeId
+		}
+	}
+	if s.IsNumeric() {
+		if len(s.Tables) != 0 {
+			return fmt.Errorf("%w: numeric scheme can not have character tables", ErrInvalidCodingScheme)
+		}
+		return s.Checkerboard.validate()
+	}
+	if len(s.Tables) != 2 {
+		return ErrCodingSchemeTableCount
+	}
+	controlChars := []rune{s.NextTableChar, s.BinaryToggleChar, s.CaseToggleChar, s.ChangeKeyChar}
+	for i, c := range controlChars {
+		if c < 'Q' || c > 'Z' {
+			return fmt.Errorf("%w: control character %c is not between Q and Z", ErrInvalidCodingScheme, c)
+		}
+		for _, other := range controlChars[i+1:] {
+			if c == other {
+				return fmt.Errorf("%w: control character %c is used more than once", ErrInvalidCodingScheme, c)
+			}
+		}
+	}
+	seen := make(map[rune]bool)
+	for t := range s.Tables {
+		if len(s.Tables[t]) != codingSchemeColumns {
+			return fmt.Errorf("%w: table %d is not %d characters long", ErrInvalidCodingScheme, t+1, codingSchemeColumns)
+		}
+		for col, c := range s.Tables[t] {
+			column := rune(col) + 'A'
+			if c == specialOpChar {
+				if !s.isControlChar(t, column) {
+					return fmt.Errorf("%w: column %c in table %d is not a control character", ErrInvalidCodingScheme, column, t+1)
+				}
+				continue
+			}
+			if s.isControlChar(t, column) {
+				return fmt.Errorf("%w: column %c in table %d must be a control character", ErrInvalidCodingScheme, column, t+1)
+			}
+			if unicode.ToUpper(c) != c {
+				return fmt.Errorf("%w: character %c in table %d is not upper case", ErrInvalidCodingScheme, c, t+1)
+			}
+			if seen[c] {
+				return fmt.Errorf("%w: character %c appears more than once", ErrInvalidCodingScheme, c)
+			}
+			seen[c] = true
+		}
+	}
+	return nil
+}
+
+// checkKey returns an error if key does not use this coding scheme (all keys
+// of a multi-key message must use the same scheme).
+func (s *CodingScheme) checkKey(key *Key) error {
+	scheme, err := key.Scheme()
+	if err != nil {
+		return err
+	}
+	if scheme != s {
+		return fmt.Errorf("%w: key %s uses %s, expected %s", ErrCodingSchemeMismatch, key.IdString(), scheme.Id, s.Id)
+	}
+	return nil
+}
+
+// controlCharactersNeededToChangeKey returns how many encoded characters are
+// needed at most to change key (see ControlCharactersNeededToChangeKey).
+// Numeric schemes may need to leave figures mode before the change key code.
+func (s *CodingScheme) controlCharactersNeededToChangeKey() int {
+	if s.IsNumeric() {
+		return len(s.Checkerboard.Figures) + len(s.Checkerboard.ChangeKey)
+	}
+	return ControlCharactersNeededToChangeKey
+}
+
+// isControlChar returns true if column (A-Z) is a control character in table.
+func (s *CodingScheme) isControlChar(table int, column rune) bool {
+	if column == s.NextTableChar {
+		return true
+	}
+	if table != secondaryTable {
+		return false
+	}
+	switch column {
+	case s.BinaryToggleChar, s.CaseToggleChar, s.ChangeKeyChar:
+		return true
+	}
+	return false
+}
+
+// find returns the table and column of a character (expected to be upper
+// case) in the scheme's tables. Both table and column are -1 if the character
+// can not be found in any of the tables.
+func (s *CodingScheme) find(c *rune) (table int, column int) {
+	for t := range s.Tables {
+		for i, tc := range s.Tables[t] {
+			if tc == specialOpChar {
+				// specialOpChar is not part of any character table, skip it
+				continue
+			}
+			if *c == tc {
+				return t, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// Legend returns the printable coding legend of the scheme (printed at the
+// bottom of each key). Control characters are symbolized with ASCII or
+// characters the PDF font can print.
+func (s *CodingScheme) Legend() string {
+	if s.IsNumeric() {
+		return s.Checkerboard.legend(s.Id)
+	}
+	symbols := map[rune]rune{
+		s.NextTableChar:    '>',
+		s.BinaryToggleChar: '¤',
+		s.CaseToggleChar:   '↕',
+		s.ChangeKeyChar:    '→',
+	}
+	explanations := []string{
+		fmt.Sprintf("> Switch table (%c)", s.NextTableChar),
+		fmt.Sprintf("¤ Toggle binary mode (%c)", s.BinaryToggleChar),
+		fmt.Sprintf("↕ Toggle case (%c)", s.CaseToggleChar),
+		fmt.Sprintf("→ Change key (%c)", s.ChangeKeyChar),
+	}
+	lines := []string{"CODING LEGEND", "IDX"}
+	for i := 0; i < codingSchemeColumns; i++ {
+		lines[1] += " " + string(rune(i)+'A')
+	}
+	for t := range s.Tables {
+		line := fmt.Sprintf("CT%d", t+1)
+		for col, c := range s.Tables[t] {
+			if c == specialOpChar {
+				c = symbols[rune(col)+'A']
+			}
+			line += " " + string(c)
+		}
+		lines = append(lines, line)
+	}
+	width := 4 + codingSchemeColumns*2 - 1 + 4
+	var legend string
+	for i := range lines {
+		if i < len(explanations) {
+			lines[i] += strings.Repeat(" ", width-len([]rune(lines[i]))) + explanations[i]
+		}
+		legend += lines[i] + LineBreak
+	}
+	return legend
+}
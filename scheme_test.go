@@ -0,0 +1,198 @@
+package krypto431
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCodingSchemes(t *testing.T) {
+	for _, scheme := range CodingSchemes() {
+		if err := scheme.Validate(); err != nil {
+			t.Errorf("Scheme %s does not validate: %v", scheme.Id, err)
+		}
+	}
+	if legend := DefaultCodingScheme().Legend(); legend != KeyFooter {
+		t.Errorf("Legend of %s differs from KeyFooter:\n%s\n%s", DefaultCodingSchemeId, legend, KeyFooter)
+	}
+	invalid := newCodingScheme("BAD1", "Invalid", `0123456789?-ÅÄÖ.Q,Z:+/¤¤A¤`)
+	if err := RegisterCodingScheme(invalid); !errors.Is(err, ErrInvalidCodingScheme) {
+		t.Errorf("Registering an invalid scheme returned %v, wanted %v", err, ErrInvalidCodingScheme)
+	}
+	if err := RegisterCodingScheme(DefaultCodingScheme()); !errors.Is(err, ErrCodingSchemeExists) {
+		t.Errorf("Registering %s again returned %v, wanted %v", DefaultCodingSchemeId, err, ErrCodingSchemeExists)
+	}
+}
+
+func TestCodingScheme_Encode(t *testing.T) {
+	testTable := []struct {
+		scheme  string
+		text    string
+		encoded string
+	}{
+		{"SV1", "Ö", "ZOZZZ"},
+		{"DE1", "Ü", "ZOZZZ"},
+		{"DE1", "ẞ", "ZWOBLKJOWZ"},
+		{"DANO1", "Æ", "ZMZZZ"},
+		{"LAT1", "(A)", "ZMZAZNZZZZ"},
+	}
+	for _, table := range testTable {
+		scheme, err := GetCodingScheme(table.scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		segments, err := scheme.Encode([]rune(table.text), EncodeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(segments[0].EncodedText); got != table.encoded {
+			t.Errorf("%s: Encode(\"%s\") = \"%s\", wanted \"%s\"", table.scheme, table.text, got, table.encoded)
+		}
+		plainText, _, _, err := scheme.Decode(segments[0].EncodedText, DefaultGroupSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plainText) != table.text {
+			t.Errorf("%s: Decode(\"%s\") = \"%s\", wanted \"%s\"", table.scheme, table.encoded, string(plainText), table.text)
+		}
+	}
+}
+
+func TestMessage_EncipherWithCodingScheme(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"), WithKeyLength(MinimumSupportedKeyLength), WithCodingScheme("de1"))
+	if err := k.Assert(); err != nil || k.CodingScheme != "DE1" {
+		t.Fatalf("Instance has coding scheme %q: %v", k.CodingScheme, err)
+	}
+	err := k.GenerateKeys(10, nil, "DL1ABC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := []rune("Grüße aus Göteborg, die Straße ist naß.")
+	msg := &Message{instance: &k, Recipients: VettedRecipients("DL1ABC"), PlainText: RuneCopy(&text)}
+	err = msg.Encipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range k.Keys {
+		if k.Keys[i].CodingScheme != "DE1" {
+			t.Fatalf("Key %s has coding scheme %q, wanted DE1", k.Keys[i].IdString(), k.Keys[i].CodingScheme)
+		}
+		k.Keys[i].Used = false
+	}
+	received := &Message{instance: &k, KeyId: RuneCopy(&msg.KeyId), CipherText: RuneCopy(&msg.CipherText)}
+	err = received.Decipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(received.PlainText) != string(text) {
+		t.Errorf("Got \"%s\", wanted \"%s\"", string(received.PlainText), string(text))
+	}
+	// Keys of other schemes are skipped when chaining keys.
+	for i := range k.Keys {
+		k.Keys[i].Used = false
+		if i > 0 {
+			k.Keys[i].CodingScheme = "SV1"
+		}
+	}
+	msg = &Message{instance: &k, Recipients: VettedRecipients("DL1ABC"), PlainText: RuneCopy(&text)}
+	err = msg.Encipher()
+	if !errors.Is(err, ErrOutOfKeys) {
+		t.Errorf("Encipher with one DE1 key returned %v, wanted %v", err, ErrOutOfKeys)
+	}
+	for i := 5; i < len(k.Keys); i++ {
+		k.Keys[i].CodingScheme = "DE1"
+	}
+	msg = &Message{instance: &k, Recipients: VettedRecipients("DL1ABC"), PlainText: RuneCopy(&text)}
+	if estimate, err := msg.Estimate(); err != nil || estimate.AvailableKeys != 6 || !estimate.Sufficient {
+		t.Errorf("Expected 6 available DE1 keys, got %+v, %v", estimate, err)
+	}
+	err = msg.Encipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range k.Keys {
+		if k.Keys[i].Used != (k.Keys[i].CodingScheme == "DE1") {
+			t.Errorf("Key %d (%s) used: %t", i, k.Keys[i].CodingScheme, k.Keys[i].Used)
+		}
+	}
+}
+
+func TestCodingScheme_EncodeNumeric(t *testing.T) {
+	scheme, err := GetCodingScheme("CT37")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTable := []struct {
+		text    string
+		encoded string
+	}{
+		{"Hello 42", "7598127878599904442229000"},
+		{"ATTACK AT 0600", "166171779916999000066600000090"},
+		{"Åke", "98019513389817720000"},
+	}
+	for _, table := range testTable {
+		segments, err := scheme.Encode([]rune(table.text), EncodeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(segments[0].EncodedText); got != table.encoded {
+			t.Errorf("Encode(\"%s\") = \"%s\", wanted \"%s\"", table.text, got, table.encoded)
+		}
+		plainText, _, _, err := scheme.Decode(segments[0].EncodedText, DefaultGroupSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plainText) != table.text {
+			t.Errorf("Decode(\"%s\") = \"%s\", wanted \"%s\"", table.encoded, string(plainText), table.text)
+		}
+	}
+	_, _, _, err = scheme.Decode([]rune("98"), DefaultGroupSize)
+	if !errors.Is(err, ErrIncompleteCode) {
+		t.Errorf("Decode of an incomplete code returned %v, wanted %v", err, ErrIncompleteCode)
+	}
+}
+
+func TestMessage_EncipherNumeric(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"), WithKeyLength(MinimumSupportedKeyLength*2), WithCodingScheme("CT37"))
+	err := k.GenerateKeys(50, nil, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range k.Keys[0].Runes {
+		if r < '0' || r > '9' {
+			t.Fatalf("Numeric key contains %c", r)
+		}
+	}
+	text := []rune("Meet at grid 1234 5678 at 0600Z, bring 2 radios. Ångström 😀!")
+	binary := []byte{0x00, 0x01, 0xfe, 0xff, 0x80}
+	msg := &Message{instance: &k, Recipients: VettedRecipients("QJ"), PlainText: RuneCopy(&text), Binary: ByteCopy(&binary)}
+	err = msg.Encipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := 0
+	for i := range k.Keys {
+		if k.Keys[i].Used {
+			used++
+		}
+		k.Keys[i].Used = false
+	}
+	if used < 2 {
+		t.Errorf("Expected the message to use several keys, used %d", used)
+	}
+	for _, r := range msg.CipherText {
+		if r < '0' || r > '9' {
+			t.Fatalf("Numeric cipher text contains %c", r)
+		}
+	}
+	received := &Message{instance: &k, KeyId: RuneCopy(&msg.KeyId), CipherText: RuneCopy(&msg.CipherText)}
+	err = received.Decipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(received.PlainText) != string(text) {
+		t.Errorf("Got \"%s\", wanted \"%s\"", string(received.PlainText), string(text))
+	}
+	if string(received.Binary) != string(binary) {
+		t.Errorf("Got binary %v, wanted %v", received.Binary, binary)
+	}
+}
@@ -0,0 +1,463 @@
+package krypto431
+
+// Keys (or the PFK of the persistence file) can be split into a number of
+// printable shares using Shamir's secret sharing where any threshold number of
+// shares recover the secret and fewer shares reveal nothing. The secret is
+// split byte-wise over GF(2^8). Each byte of a share is written as two
+// letters (AA-JV), the share ends with a CRC32 checksum (also as letters) over
+// the header and data so that typos are detected when shares are typed in
+// again. Selected keys are exported (see ExportKeys()), gob-encoded and
+// gzipped before splitting. A share is printed in the same layout as a key,
+// with the share number in the top separator and the share set id (all shares
+// of a split have the same set id) where the key id would be.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/sa6mwa/blox"
+	"github.com/sa6mwa/krypto431/crand"
+)
+
+const (
+	// ShareKindKeys is the kind of shares of keys.
+	ShareKindKeys string = "KEYS"
+	// ShareKindPFK is the kind of shares of the persistence file key.
+	ShareKindPFK string = "PFK"
+	// MaxShares is the maximum number of shares of a secret.
+	MaxShares int = 255
+	// shareDigestLength is the length of the digest appended to the secret to
+	// verify that shares combine into the original secret.
+	shareDigestLength int = 4
+)
+
+var (
+	ErrInvalidThreshold = fmt.Errorf("threshold must be at least 2 and shares at most %d", MaxShares)
+	ErrInvalidShare     = errors.New("invalid share")
+	ErrShareChecksum    = errors.New("share checksum mismatch (typo?)")
+	ErrShareMismatch    = errors.New("shares are not from the same split")
+	ErrNotEnoughShares  = errors.New("not enough shares")
+	ErrWrongShareKind   = errors.New("wrong kind of shares")
+	ErrNoKeysToSplit    = errors.New("no keys to split")
+)
+
+var (
+	shareNumberRegexp    *regexp.Regexp = regexp.MustCompile(`^\s*SHARE\s+(\d+)\s+OF\s+(\d+)`)
+	shareHeaderRegexp    *regexp.Regexp = regexp.MustCompile(`^\s*([A-Z]+)\s+/\s*KIND:\s*([A-Z]+)\s*/\s*THRESHOLD:\s*(\d+)\s*/\s*BYTES:\s*(\d+)`)
+	shareGroupLineRegexp *regexp.Regexp = regexp.MustCompile(`^\s*[A-Z]+(?:\s+[A-Z]+)*\s*$`)
+)
+
+// Share is one share of a split secret. Set identifies the split (all shares
+// of a split have the same Set), Number is the share number (1 to Shares) and
+// Threshold the number of shares needed to recover the secret.
+type Share struct {
+	Set       []rune
+	Kind      string
+	Number    int
+	Shares    int
+	Threshold int
+	Data      []byte
+	instance  *Krypto431
+}
+
+// Wipe overwrites the share data with zeroes.
+func (s *Share) Wipe() {
+	ZeroWipeBytes(&s.Data)
+	Wipe(&s.Set)
+}
+
+// checksum returns the CRC32 of the share header and data.
+func (s *Share) checksum() uint32 {
+	crc := crc32.NewIEEE()
+	fmt.Fprintf(crc, "%s %s %d %d %d ", string(s.Set), s.Kind, s.Number, s.Shares, s.Threshold)
+	crc.Write(s.Data)
+	return crc.Sum32()
+}
+
+// Letters returns the data and checksum of the share as letters, two letters
+// per byte.
+func (s *Share) Letters() []rune {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, s.checksum())
+	letters := make([]rune, 0, (len(s.Data)+len(sum))*2)
+	for _, b := range append(append([]byte{}, s.Data...), sum...) {
+		letters = append(letters, rune(b/26)+'A', rune(b%26)+'A')
+	}
+	return letters
+}
+
+// String returns the share printed in the layout of Key.String().
+func (s *Share) String() string {
+	groupSize, columns := DefaultGroupSize, DefaultColumns
+	if s.instance != nil {
+		groupSize, columns = s.instance.GroupSize, s.instance.Columns
+	}
+	letters := s.Letters()
+	defer Wipe(&letters)
+	// Pad the last group, padding is ignored as the length is in the header.
+	for len(letters)%groupSize != 0 {
+		letters = append(letters, 'X')
+	}
+	g, err := groups(&letters, groupSize, columns)
+	if err != nil {
+		return ""
+	}
+	defer Wipe(g)
+	text := string(*g)
+	_, groupsLines := blox.RowAndColumnCount(text)
+	footer := fmt.Sprintf("COMBINE ANY %d OF %d SHARES OF SET %s WITH: krypto431 keys --combine", s.Threshold, s.Shares, string(s.Set))
+	headerLines := 4
+	rows := headerLines + groupsLines + 2
+	header := fmt.Sprintf("/ KIND: %s / THRESHOLD: %d / BYTES: %d", s.Kind, s.Threshold, len(s.Data))
+	b := blox.New().SetColumnsAndRows(columns, rows).Trim().DrawSeparator('_')
+	b.PushPos().Move(0, 0).PutLine([]rune(fmt.Sprintf("SHARE %d OF %d ", s.Number, s.Shares))).PopPos()
+	return b.PutTextRightAligned(header).Move(0, 1).
+		PutLine(s.Set).MoveDown().DrawSeparator().MoveDown().
+		PutText(text).Move(0, rows-1).PutText(footer).String()
+}
+
+// gf256 log and exp tables with generator 3 (the AES polynomial 0x11b).
+var gf256Exp, gf256Log = func() ([512]byte, [256]byte) {
+	var exp [512]byte
+	var log [256]byte
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		log[x] = byte(i)
+		// Multiply by 3 (x*2 xor x).
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x = x2 ^ x
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gf256Mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gf256Exp[int(gf256Log[a])+int(gf256Log[b])]
+}
+
+func gf256Div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gf256Exp[int(gf256Log[a])+255-int(gf256Log[b])]
+}
+
+// shamirSplit splits secret into n shares (x = 1 to n) where any t shares
+// recover the secret.
+func shamirSplit(secret []byte, n, t int) [][]byte {
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	coefficients := make([]byte, t)
+	defer ZeroWipeBytes(&coefficients)
+	for i := range secret {
+		coefficients[0] = secret[i]
+		crand.Read(coefficients[1:])
+		for x := 1; x <= n; x++ {
+			// Horner's method.
+			var y byte
+			for c := t - 1; c >= 0; c-- {
+				y = gf256Mul(y, byte(x)) ^ coefficients[c]
+			}
+			shares[x-1][i] = y
+		}
+	}
+	return shares
+}
+
+// shamirCombine recovers the secret from shares ys at points xs (Lagrange
+// interpolation at x = 0).
+func shamirCombine(xs []byte, ys [][]byte) []byte {
+	secret := make([]byte, len(ys[0]))
+	for i := range xs {
+		// Lagrange basis polynomial of xs[i] at 0.
+		basis := byte(1)
+		for j := range xs {
+			if i != j {
+				basis = gf256Mul(basis, gf256Div(xs[j], xs[j]^xs[i]))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gf256Mul(ys[i][b], basis)
+		}
+	}
+	return secret
+}
+
+// split returns shares of secret (appended with a digest to verify the
+// combined secret).
+func (k *Krypto431) split(kind string, secret []byte, shares, threshold int) ([]Share, error) {
+	if threshold < 2 || shares < threshold || shares > MaxShares {
+		return nil, ErrInvalidThreshold
+	}
+	digest := sha256.Sum256(secret)
+	payload := append(append([]byte{}, secret...), digest[:shareDigestLength]...)
+	defer ZeroWipeBytes(&payload)
+	set := make([]rune, k.GroupSize)
+	for i := range set {
+		set[i] = rune(crand.Intn(26)) + 'A'
+	}
+	var result []Share
+	for i, data := range shamirSplit(payload, shares, threshold) {
+		result = append(result, Share{
+			Set:       RuneCopy(&set),
+			Kind:      kind,
+			Number:    i + 1,
+			Shares:    shares,
+			Threshold: threshold,
+			Data:      data,
+			instance:  k,
+		})
+	}
+	return result, nil
+}
+
+// SplitKeys splits the keys where filter returns true (with their books and
+// nets, see ExportKeys()) into shares where any threshold shares recover the
+// keys (see RecoverKeys()).
+func (k *Krypto431) SplitKeys(filter func(key *Key) bool, shares, threshold int) ([]Share, error) {
+	// Exported keys share their runes with ours, only wipe the copy of the PFK.
+	exported := k.ExportKeys(filter)
+	defer ZeroWipeBytes(exported.persistenceKey)
+	if len(exported.Keys) == 0 {
+		return nil, ErrNoKeysToSplit
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(gz).Encode(&exported); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	secret := buf.Bytes()
+	defer ZeroWipeBytes(&secret)
+	return k.split(ShareKindKeys, secret, shares, threshold)
+}
+
+// SplitPFK splits the persistence file key into shares where any threshold
+// shares recover the key (see RecoverPFK()).
+func (k *Krypto431) SplitPFK(shares, threshold int) ([]Share, error) {
+	if k.persistenceKey == nil {
+		return nil, ErrNilPFK
+	}
+	return k.split(ShareKindPFK, *k.persistenceKey, shares, threshold)
+}
+
+// CombineShares recovers the secret of shares. Shares must be from the same
+// split and at least the threshold number of shares are needed (shares with
+// the same number are only counted once). Returns the kind of shares and the
+// secret or error.
+func CombineShares(shares []Share) (string, []byte, error) {
+	if len(shares) == 0 {
+		return "", nil, ErrNotEnoughShares
+	}
+	first := &shares[0]
+	var xs []byte
+	var ys [][]byte
+	for i := range shares {
+		s := &shares[i]
+		if !EqualRunes(&s.Set, &first.Set) || s.Kind != first.Kind || s.Shares != first.Shares ||
+			s.Threshold != first.Threshold || len(s.Data) != len(first.Data) {
+			return "", nil, fmt.Errorf("%w: share %d of set %s and share %d of set %s", ErrShareMismatch, first.Number, string(first.Set), s.Number, string(s.Set))
+		}
+		if s.Number < 1 || s.Number > s.Shares {
+			return "", nil, fmt.Errorf("%w: share number %d of %d", ErrInvalidShare, s.Number, s.Shares)
+		}
+		duplicate := false
+		for _, x := range xs {
+			duplicate = duplicate || int(x) == s.Number
+		}
+		if !duplicate && len(xs) < first.Threshold {
+			xs = append(xs, byte(s.Number))
+			ys = append(ys, s.Data)
+		}
+	}
+	if len(xs) < first.Threshold || len(first.Data) <= shareDigestLength {
+		return "", nil, fmt.Errorf("%w: need %d of %d shares of set %s, got %d", ErrNotEnoughShares, first.Threshold, first.Shares, string(first.Set), len(xs))
+	}
+	payload := shamirCombine(xs, ys)
+	secret := payload[:len(payload)-shareDigestLength]
+	digest := sha256.Sum256(secret)
+	if !bytes.Equal(digest[:shareDigestLength], payload[len(secret):]) {
+		ZeroWipeBytes(&payload)
+		return "", nil, ErrShareMismatch
+	}
+	return first.Kind, secret, nil
+}
+
+// RecoverKeys combines shares of keys (see SplitKeys()) and imports the keys
+// (with their books and nets) as ImportKeys() would. Returns the number of keys
+// imported. Call Save() to persist the keys.
+func (k *Krypto431) RecoverKeys(shares []Share) (int, error) {
+	kind, secret, err := CombineShares(shares)
+	if err != nil {
+		return 0, err
+	}
+	defer ZeroWipeBytes(&secret)
+	if kind != ShareKindKeys {
+		return 0, fmt.Errorf("%w: %s, expected %s", ErrWrongShareKind, kind, ShareKindKeys)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(secret))
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	incoming := New()
+	defer incoming.Wipe()
+	if err := gob.NewDecoder(gz).Decode(&incoming); err != nil {
+		return 0, err
+	}
+	for i := range incoming.Keys {
+		incoming.Keys[i].instance = &incoming
+	}
+	return k.importKeys(&incoming, func(*Key) bool { return true })
+}
+
+// RecoverPFK combines shares of a persistence file key (see SplitPFK()) and
+// returns the key hex-encoded (for use with WithPFKString() or
+// SetPFKFromString()).
+func RecoverPFK(shares []Share) (string, error) {
+	kind, secret, err := CombineShares(shares)
+	if err != nil {
+		return "", err
+	}
+	defer ZeroWipeBytes(&secret)
+	if kind != ShareKindPFK {
+		return "", fmt.Errorf("%w: %s, expected %s", ErrWrongShareKind, kind, ShareKindPFK)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// ParseShares parses shares printed with Share.String() from r (until EOF).
+// Returns error on the first share with a problem (including checksum
+// mismatch).
+func ParseShares(r io.Reader) ([]Share, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var shares []Share
+	var current *Share
+	var letters []rune
+	defer Wipe(&letters)
+	flush := func() error {
+		if current == nil {
+			return nil
+		}
+		s := current
+		current = nil
+		if len(s.Set) == 0 {
+			return fmt.Errorf("%w: share %d has no header", ErrInvalidShare, s.Number)
+		}
+		need := (len(s.Data) + 4) * 2
+		if len(letters) < need {
+			return fmt.Errorf("%w: share %d of set %s has %d letters, expected %d", ErrInvalidShare, s.Number, string(s.Set), len(letters), need)
+		}
+		data := make([]byte, 0, len(s.Data)+4)
+		for i := 0; i < need; i += 2 {
+			v := int(letters[i]-'A')*26 + int(letters[i+1]-'A')
+			if v > 255 {
+				return fmt.Errorf("%w: share %d of set %s, group %d (%c%c is not a byte)", ErrInvalidShare, s.Number, string(s.Set), i/DefaultGroupSize+1, letters[i], letters[i+1])
+			}
+			data = append(data, byte(v))
+		}
+		s.Data = data[:len(s.Data)]
+		if s.checksum() != binary.BigEndian.Uint32(data[len(s.Data):]) {
+			return fmt.Errorf("%w: share %d of set %s", ErrShareChecksum, s.Number, string(s.Set))
+		}
+		shares = append(shares, *s)
+		letters = letters[:0]
+		return nil
+	}
+	inGroups := false
+	for _, line := range strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n") {
+		upper := strings.ToUpper(line)
+		trimmed := strings.TrimSpace(upper)
+		switch {
+		case shareNumberRegexp.MatchString(upper):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			m := shareNumberRegexp.FindStringSubmatch(upper)
+			current = &Share{}
+			current.Number, _ = strconv.Atoi(m[1])
+			current.Shares, _ = strconv.Atoi(m[2])
+			inGroups = false
+		case current == nil:
+			continue
+		case shareHeaderRegexp.MatchString(upper):
+			m := shareHeaderRegexp.FindStringSubmatch(upper)
+			current.Set = []rune(m[1])
+			current.Kind = m[2]
+			current.Threshold, _ = strconv.Atoi(m[3])
+			length, _ := strconv.Atoi(m[4])
+			current.Data = make([]byte, length)
+		case trimmed != "" && strings.Trim(trimmed, "-") == "":
+			inGroups = true
+		case inGroups && shareGroupLineRegexp.MatchString(upper):
+			letters = append(letters, []rune(strings.Join(strings.Fields(upper), ""))...)
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+// SharesAsText returns the shares printed one after the other.
+func SharesAsText(shares []Share) string {
+	var output string
+	for i := range shares {
+		output += shares[i].String() + LineBreak
+	}
+	return output
+}
+
+// SharesTextFile writes the shares to a text file.
+func SharesTextFile(shares []Share, filename string) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(SharesAsText(shares))
+	return err
+}
+
+// SharesPDF writes the shares to a PDF with one share per page (so that each
+// page can be handed to a different person).
+func SharesPDF(shares []Share, filename string) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes("LiberationMono", "B", fontLiberationMonoBold)
+	pdf.SetFont("LiberationMono", "B", 8)
+	for i := range shares {
+		pdf.AddPage()
+		pdf.MultiCell(0, 3, shares[i].String(), "", "", false)
+	}
+	return pdf.OutputFileAndClose(filename)
+}
@@ -0,0 +1,131 @@
+package krypto431
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestShamirSplitCombine(t *testing.T) {
+	secret := []byte("The quick brown fox jumps over the lazy dog")
+	shares := shamirSplit(secret, 5, 3)
+	for _, set := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}} {
+		var xs []byte
+		var ys [][]byte
+		for _, i := range set {
+			xs = append(xs, byte(i+1))
+			ys = append(ys, shares[i])
+		}
+		if got := shamirCombine(xs, ys); !bytes.Equal(got, secret) {
+			t.Errorf("shares %v: expected %q, got %q", set, secret, got)
+		}
+	}
+	if got := shamirCombine([]byte{1, 2}, shares[:2]); bytes.Equal(got, secret) {
+		t.Errorf("Expected 2 of 3 shares not to recover the secret")
+	}
+}
+
+func TestKrypto431_SplitPFK(t *testing.T) {
+	k := New(WithInteractive(false))
+	if err := k.SetPFKFromString("0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	for _, nt := range [][2]int{{3, 1}, {2, 3}, {256, 2}} {
+		if _, err := k.SplitPFK(nt[0], nt[1]); !errors.Is(err, ErrInvalidThreshold) {
+			t.Errorf("%d of %d: expected %v, got %v", nt[1], nt[0], ErrInvalidThreshold, err)
+		}
+	}
+	shares, err := k.SplitPFK(5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := SharesAsText(shares)
+	parsed, err := ParseShares(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed) != 5 {
+		t.Fatalf("Expected 5 shares, got %d", len(parsed))
+	}
+	pfk, err := RecoverPFK(parsed[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pfk != "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" {
+		t.Errorf("Unexpected PFK %s", pfk)
+	}
+	if _, err := RecoverPFK([]Share{parsed[0], parsed[0], parsed[1]}); !errors.Is(err, ErrNotEnoughShares) {
+		t.Errorf("Expected %v, got %v", ErrNotEnoughShares, err)
+	}
+	if _, err := RecoverPFK([]Share{parsed[0], parsed[1], shares[4]}); err != nil {
+		t.Errorf("Expected parsed and original shares to combine, got %v", err)
+	}
+
+	// A typo in a group is detected by the checksum.
+	lines := strings.Split(shares[1].String(), LineBreak)
+	for i := range lines {
+		if strings.HasPrefix(lines[i], "---") {
+			group := lines[i+2]
+			swapped := []rune(group)
+			swapped[1], swapped[2] = swapped[2], swapped[1]
+			if swapped[1] == swapped[2] {
+				swapped[1]++
+			}
+			lines[i+2] = string(swapped)
+			break
+		}
+	}
+	if _, err := ParseShares(strings.NewReader(strings.Join(lines, LineBreak))); !errors.Is(err, ErrShareChecksum) && !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("Expected a typo to be detected, got %v", err)
+	}
+
+	other, err := k.SplitPFK(5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := CombineShares([]Share{shares[0], shares[1], other[2]}); !errors.Is(err, ErrShareMismatch) {
+		t.Errorf("Expected %v, got %v", ErrShareMismatch, err)
+	}
+}
+
+func TestKrypto431_RecoverKeys(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"), WithInteractive(false))
+	if err := k.GenerateKeys(3, nil, "QJ"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.GenerateKeys(2, nil, "SM5ABC"); err != nil {
+		t.Fatal(err)
+	}
+	shares, err := k.SplitKeys(func(key *Key) bool { return key.ContainsKeeper([]rune("QJ")) }, 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RecoverPFK(shares[:2]); !errors.Is(err, ErrWrongShareKind) {
+		t.Errorf("Expected %v, got %v", ErrWrongShareKind, err)
+	}
+	parsed, err := ParseShares(strings.NewReader(SharesAsText(shares[1:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// QJ receives our keys, we are the keeper of the recovered keys.
+	qj := New(WithCallSign("QJ"), WithInteractive(false))
+	imported, err := qj.RecoverKeys(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 3 || len(qj.Keys) != 3 {
+		t.Fatalf("Expected 3 recovered keys, got %d", imported)
+	}
+	for i := range qj.Keys {
+		if !EqualRunes(&qj.Keys[i].Id, &k.Keys[i].Id) || !EqualRunes(&qj.Keys[i].Runes, &k.Keys[i].Runes) {
+			t.Errorf("Recovered key %s differs from %s", string(qj.Keys[i].Id), string(k.Keys[i].Id))
+		}
+		if !qj.Keys[i].ContainsKeeper([]rune("SA6MWA")) || qj.Keys[i].ContainsKeeper([]rune("QJ")) {
+			t.Errorf("Unexpected keepers %s", qj.Keys[i].JoinKeepers(","))
+		}
+	}
+	if _, err := k.SplitKeys(func(key *Key) bool { return false }, 3, 2); !errors.Is(err, ErrNoKeysToSplit) {
+		t.Errorf("Expected %v, got %v", ErrNoKeysToSplit, err)
+	}
+}
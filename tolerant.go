@@ -0,0 +1,131 @@
+package krypto431
+
+// A garbled or dropped character makes Decipher() fail on the first rune that
+// can not be deciphered or decoded. DecipherTolerant() continues past such
+// runes, marks them inline in the plain text (e.g [?14] where 14 is the group
+// number) and returns a report of suspect groups the operator can ask a repeat
+// of. Groups are numbered as transmitted where the key id is group 1.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SuspectGroupMarker is the format of the inline marker written to the plain
+// text where a suspect group could not be deciphered or decoded. The argument
+// is the group number or a range of groups (e.g 14 or 14-20).
+var SuspectGroupMarker string = "[?%s]"
+
+// DecipherReport is returned by DecipherTolerant().
+type DecipherReport struct {
+	// SuspectGroups are the groups (as transmitted, the key id is group 1)
+	// where runes could not be deciphered or decoded, in order of appearance.
+	SuspectGroups []int
+	// Errors holds the first error of each suspect group (same index as
+	// SuspectGroups).
+	Errors []error
+	// Integrity is set if the instance has IntegrityCheck enabled and one or
+	// more chunks failed the check.
+	Integrity *IntegrityError
+}
+
+// OK returns true if no group is suspect.
+func (r *DecipherReport) OK() bool {
+	return len(r.SuspectGroups) == 0 && r.Integrity == nil
+}
+
+// RepeatGroups returns all suspect groups and groups in chunks that failed the
+// integrity check, sorted and without duplicates.
+func (r *DecipherReport) RepeatGroups() []int {
+	seen := make(map[int]bool)
+	groups := make([]int, 0, len(r.SuspectGroups))
+	add := func(group int) {
+		if !seen[group] {
+			seen[group] = true
+			groups = append(groups, group)
+		}
+	}
+	for _, group := range r.SuspectGroups {
+		add(group)
+	}
+	if r.Integrity != nil {
+		for _, chunk := range r.Integrity.Chunks {
+			first, last := r.Integrity.GroupRange(chunk)
+			for group := first; group <= last; group++ {
+				add(group)
+			}
+		}
+	}
+	sort.Ints(groups)
+	return groups
+}
+
+// String returns the groups to ask a repeat of as ranges, e.g "REPEAT GR 7
+// 14-16" or an empty string if no group is suspect.
+func (r *DecipherReport) String() string {
+	groups := r.RepeatGroups()
+	if len(groups) == 0 {
+		return ""
+	}
+	ranges := make([]string, 0, len(groups))
+	for i := 0; i < len(groups); i++ {
+		first := groups[i]
+		for i+1 < len(groups) && groups[i+1] == groups[i]+1 {
+			i++
+		}
+		if groups[i] == first {
+			ranges = append(ranges, fmt.Sprint(first))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", first, groups[i]))
+		}
+	}
+	return "REPEAT GR " + strings.Join(ranges, " ")
+}
+
+// add adds a suspect group to the report. Returns the marker to write to the
+// plain text or nil if the group was already marked (the previous suspect).
+func (r *DecipherReport) add(group int, err error) []rune {
+	if n := len(r.SuspectGroups); n > 0 && r.SuspectGroups[n-1] == group {
+		return nil
+	}
+	r.SuspectGroups = append(r.SuspectGroups, group)
+	r.Errors = append(r.Errors, err)
+	return []rune(fmt.Sprintf(SuspectGroupMarker, fmt.Sprint(group)))
+}
+
+// addRest adds groups first to last as suspect (the rest of the message could
+// not be deciphered) and returns the marker to write to the plain text.
+func (r *DecipherReport) addRest(first int, last int, err error) []rune {
+	if n := len(r.SuspectGroups); n > 0 && r.SuspectGroups[n-1] == first {
+		first++
+	}
+	if first > last {
+		return nil
+	}
+	for group := first; group <= last; group++ {
+		r.SuspectGroups = append(r.SuspectGroups, group)
+		r.Errors = append(r.Errors, err)
+	}
+	if first == last {
+		return []rune(fmt.Sprintf(SuspectGroupMarker, fmt.Sprint(first)))
+	}
+	return []rune(fmt.Sprintf(SuspectGroupMarker, fmt.Sprintf("%d-%d", first, last)))
+}
+
+// DecipherTolerant is Decipher() that continues past runes that can not be
+// deciphered or decoded (e.g a miscopied group). Such runes are skipped and
+// marked in the PlainText (see SuspectGroupMarker) and their groups are listed
+// in the returned report. If the instance has IntegrityCheck enabled, chunks
+// that fail the check are reported instead of failing the decipher. If a key
+// in a key change is not found, the rest of the message is marked suspect.
+// Error is only returned if the message can not be deciphered at all (e.g the
+// first key is missing). As with Decipher(), all keys used are marked used.
+func (m *Message) DecipherTolerant() (*DecipherReport, error) {
+	report := &DecipherReport{}
+	err := m.decipher(report)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
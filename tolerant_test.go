@@ -0,0 +1,75 @@
+package krypto431
+
+import (
+	"testing"
+)
+
+func TestMessage_DecipherTolerant(t *testing.T) {
+	k := New(WithCallSign("SA6MWA"), WithKeyLength(MinimumSupportedKeyLength*2))
+	err := k.GenerateKeys(10, nil, "QJ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := []rune("HELLO WORLD THIS IS A LONGER TEST MESSAGE THAT NEEDS MORE THAN ONE KEY")
+	msg := &Message{instance: &k, Recipients: VettedRecipients("QJ"), PlainText: RuneCopy(&text)}
+	err = msg.Encipher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range k.Keys {
+		k.Keys[i].Used = false
+	}
+	// Garble the 11th character (the D in WORLD) which is in the 3rd cipher text
+	// group, group 4 as transmitted.
+	received := &Message{instance: &k, KeyId: RuneCopy(&msg.KeyId), CipherText: RuneCopy(&msg.CipherText)}
+	received.CipherText[10] = '?'
+	if err := received.Decipher(); err == nil {
+		t.Fatal("Expected Decipher() to fail on garbled cipher text")
+	}
+	report, err := received.DecipherTolerant()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "HELLO WORL[?4] THIS IS A LONGER TEST MESSAGE THAT NEEDS MORE THAN ONE KEY"
+	if string(received.PlainText) != expected {
+		t.Errorf("Got \"%s\", wanted \"%s\"", string(received.PlainText), expected)
+	}
+	if report.OK() || len(report.SuspectGroups) != 1 || report.SuspectGroups[0] != 4 {
+		t.Errorf("Expected group 4 to be suspect, got %v", report.SuspectGroups)
+	}
+	if report.Errors[0] == nil {
+		t.Errorf("Expected an error for group 4, got %v", report.Errors[0])
+	}
+	if report.String() != "REPEAT GR 4" {
+		t.Errorf("Got \"%s\", wanted \"REPEAT GR 4\"", report.String())
+	}
+	// Without the second key, the rest of the message is suspect.
+	for i := range k.Keys {
+		k.Keys[i].Used = false
+	}
+	secondKey := k.Keys[1].Id
+	k.Keys[1].Id = []rune("XXXXX")
+	received.CipherText = RuneCopy(&msg.CipherText)
+	report, err = received.DecipherTolerant()
+	k.Keys[1].Id = secondKey
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := len(msg.CipherText) / k.GroupSize
+	if report.OK() || report.SuspectGroups[len(report.SuspectGroups)-1] != groups+1 {
+		t.Errorf("Expected the rest of the message (up to group %d) to be suspect, got %v", groups+1, report.SuspectGroups)
+	}
+	if report.Errors[len(report.Errors)-1] == nil {
+		t.Error("Expected an error for the rest of the message")
+	}
+}
+
+func TestDecipherReport_String(t *testing.T) {
+	report := &DecipherReport{
+		SuspectGroups: []int{14, 7, 15, 16},
+		Integrity:     &IntegrityError{Chunks: []int{4}, GroupSize: 5, Groups: 18},
+	}
+	if got := report.String(); got != "REPEAT GR 7 14-19" {
+		t.Errorf("Got \"%s\", wanted \"REPEAT GR 7 14-19\"", got)
+	}
+}